@@ -2,9 +2,12 @@ module finescript
 
 go 1.23.6
 
+require (
+	github.com/sanity-io/litter v1.5.8
+	github.com/spf13/cobra v1.9.1
+)
+
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/sanity-io/litter v1.5.8 // indirect
-	github.com/spf13/cobra v1.9.1 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
 )
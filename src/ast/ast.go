@@ -29,3 +29,15 @@ func (e Error) _type() {}
 func (e Error) Pos() lexer.Position {
 	return *e.Position
 }
+
+/*
+Привязка имени к слоту окружения, которую вычисляет resolver.
+
+Depth - сколько окружений нужно пройти вверх от места использования,
+Slot - номер переменной в найденном окружении. Глобальные имена
+привязки не получают и ищутся по имени.
+*/
+type Binding struct {
+	Depth int
+	Slot  int
+}
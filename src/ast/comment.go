@@ -0,0 +1,48 @@
+package ast
+
+import (
+	"finescript/src/lexer"
+	"strings"
+)
+
+/*
+Комментарий вместе с открывающими и закрывающими символами. Парсер собирает комментарии, только
+если токены получены без потерь (lexer.TokenizeTrivia).
+*/
+type Comment struct {
+	Text     string
+	Position lexer.Position
+	// Комментарий стоит на одной строке с кодом перед ним: a = 1 // ...
+	Trailing bool
+}
+
+func (c Comment) IsBlock() bool {
+	return strings.HasPrefix(c.Text, "/*")
+}
+
+/*
+Строка, на которой комментарий заканчивается.
+*/
+func (c Comment) EndLine() int {
+	return c.Position.Line + strings.Count(c.Text, "\n")
+}
+
+/*
+Документирующий комментарий узла в позиции pos: идущие подряд
+комментарии, последний из которых заканчивается на строке прямо перед
+узлом. comments должны быть упорядочены, как в Program.Comments.
+*/
+func Doc(comments []Comment, pos lexer.Position) []Comment {
+	end := len(comments)
+	for end > 0 && comments[end-1].Position.StartPos >= pos.StartPos {
+		end--
+	}
+
+	start := end
+	line := pos.Line
+	for start > 0 && !comments[start-1].Trailing && comments[start-1].EndLine() == line-1 {
+		start--
+		line = comments[start].Position.Line
+	}
+	return comments[start:end]
+}
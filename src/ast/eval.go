@@ -0,0 +1,112 @@
+package ast
+
+/*
+Встроенные функции, через которые код выполняется в окружении места
+вызова или получает его. После их вызова в области видимости могут
+появиться имена, которых нет в исходном тексте.
+*/
+var scopeBuiltins = map[string]bool{
+	"eval":       true,
+	"evalIn":     true,
+	"currentEnv": true,
+}
+
+/*
+Вызывает ли тело eval, evalIn или currentEnv прямо или во вложенных
+блоках и ветках if. Тела вложенных функций не проверяются: у них своё
+окружение. Вызов через другое имя (var e = eval) не распознаётся.
+*/
+func CallsEval(body []Stmt) bool {
+	return bodyCallsEval(body, false)
+}
+
+/*
+Как CallsEval, но с телами вложенных функций: их окружения лежат внутри
+окружения тела, и eval в них видит его переменные.
+*/
+func ContainsEval(body []Stmt) bool {
+	return bodyCallsEval(body, true)
+}
+
+/*
+Как CallsEval, но только для выражений, которые выполняются в окружении
+самого тела: без вложенных блоков и веток if. Только в таком окружении
+eval может объявить новое имя.
+*/
+func ScopeCallsEval(body []Stmt) bool {
+	for _, node := range body {
+		switch stmt := node.(type) {
+		case ExprStmt:
+			if exprCallsEval(stmt.Expr) {
+				return true
+			}
+		case VarDeclStmt:
+			if exprCallsEval(stmt.Value) {
+				return true
+			}
+		case IfStmt:
+			if exprCallsEval(stmt.Condition) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func bodyCallsEval(body []Stmt, functions bool) bool {
+	for _, node := range body {
+		if stmtCallsEval(node, functions) {
+			return true
+		}
+	}
+	return false
+}
+
+func stmtCallsEval(node Stmt, functions bool) bool {
+	switch stmt := node.(type) {
+	case BlockStmt:
+		return bodyCallsEval(stmt.Body, functions)
+	case ExprStmt:
+		return exprCallsEval(stmt.Expr)
+	case VarDeclStmt:
+		return exprCallsEval(stmt.Value)
+	case IfStmt:
+		return exprCallsEval(stmt.Condition) || bodyCallsEval(stmt.Consequent, functions) || bodyCallsEval(stmt.Alternate, functions)
+	case FunDeclStmt:
+		return functions && bodyCallsEval(stmt.Body, true)
+	default:
+		return false
+	}
+}
+
+func exprCallsEval(node Expr) bool {
+	switch expr := node.(type) {
+	case CallExpr:
+		if ident, ok := expr.Caller.(Identifier); ok && scopeBuiltins[ident.Name] {
+			return true
+		}
+		for _, arg := range expr.Args {
+			if exprCallsEval(arg) {
+				return true
+			}
+		}
+		return exprCallsEval(expr.Caller)
+	case UnaryExpr:
+		return exprCallsEval(expr.Expr)
+	case BinaryExpr:
+		return exprCallsEval(expr.Left) || exprCallsEval(expr.Right)
+	case AssignExpr:
+		return exprCallsEval(expr.Assigne) || exprCallsEval(expr.Expr)
+	case ConditionalExpr:
+		return exprCallsEval(expr.Condition) || exprCallsEval(expr.Consequent) || exprCallsEval(expr.Alternate)
+	case TemplateExpr:
+		for _, part := range expr.Parts {
+			if exprCallsEval(part) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
@@ -7,6 +7,7 @@ name
 */
 type Identifier struct {
 	Name     string
+	Binding  *Binding // Заполняется resolver
 	Position lexer.Position
 }
 
@@ -138,11 +139,15 @@ func (e AssignExpr) Pos() lexer.Position {
 }
 
 /*
-caller(args)
+caller(args) или caller?.(args)
+
+Optional вызов возвращает undefined, если caller равен null или undefined.
 */
 type CallExpr struct {
 	Caller   Expr
 	Args     []Expr
+	Optional bool
+	Pipe     *Pipe // Если вызов записан этапом конвейера
 	Position lexer.Position
 }
 
@@ -151,6 +156,33 @@ func (e CallExpr) Pos() lexer.Position {
 	return e.Position
 }
 
+/*
+x |> f(a) или x |> f
+
+Arg - номер аргумента, в который попало значение слева. Placeholder
+означает, что оно стояло на месте _, Bare - что у этапа не было скобок.
+*/
+type Pipe struct {
+	Arg         int
+	Placeholder bool
+	Bare        bool
+}
+
+/*
+`Hello, ${name}!`
+
+Parts - текстовые куски (StringLiteral) и вставленные выражения по порядку.
+*/
+type TemplateExpr struct {
+	Parts    []Expr
+	Position lexer.Position
+}
+
+func (e TemplateExpr) expr() {}
+func (e TemplateExpr) Pos() lexer.Position {
+	return e.Position
+}
+
 /*
 condition ? consequent : alternate
 */
@@ -6,7 +6,9 @@ import "finescript/src/lexer"
 program
 */
 type Program struct {
-	Body     []Stmt
+	Body []Stmt
+	// Все комментарии файла по порядку, если они сохранялись
+	Comments []Comment
 	Position lexer.Position
 }
 
@@ -49,7 +51,8 @@ const name = expr
 type VarDeclStmt struct {
 	IsConstant bool
 	Name       string
-	Value      Expr // Опционально или нет зависит от IsConstant
+	Value      Expr     // Опционально или нет зависит от IsConstant
+	Binding    *Binding // Заполняется resolver
 	Position   lexer.Position
 }
 
@@ -76,6 +79,7 @@ type FunDeclStmt struct {
 	Params     []Param
 	Body       []Stmt
 	ReturnType Type
+	Binding    *Binding // Заполняется resolver
 	Position   lexer.Position
 }
 
@@ -109,6 +113,7 @@ type name = int
 type TypeAliasDecl struct {
 	Name     string
 	Type     Type
+	Binding  *Binding // Заполняется resolver
 	Position lexer.Position
 }
 
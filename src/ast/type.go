@@ -263,8 +263,9 @@ type Member interface {
 name: type
 */
 type PropertySignature struct {
-	Name string
-	Type Type
+	Name     string
+	Type     Type
+	Position lexer.Position
 }
 
 func (t PropertySignature) member() {}
@@ -273,9 +274,10 @@ func (t PropertySignature) member() {}
 name(params): type
 */
 type MethodSignature struct {
-	Name   string
-	Params []Param
-	Type   Type
+	Name     string
+	Params   []Param
+	Type     Type
+	Position lexer.Position
 }
 
 func (t MethodSignature) member() {}
@@ -0,0 +1,161 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"finescript/src/lexer"
+	"finescript/src/runtime"
+	"fmt"
+)
+
+type Instructions []byte
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota // индекс константы
+	OpNull
+	OpUndefined
+	OpPop
+	OpDefineLocal // слот, константность
+	OpDefine      // слот, константность, индекс константы с именем
+	OpDefineName  // константность, индекс константы с именем
+	OpGetLocal    // глубина кадра, слот, индекс константы с именем
+	OpSetLocal    // глубина кадра, слот, индекс константы с именем
+	OpGetEnv      // глубина окружения, слот, индекс константы с именем
+	OpSetEnv      // глубина окружения, слот, индекс константы с именем
+	OpGetName     // индекс константы с именем
+	OpSetName     // индекс константы с именем
+	OpEnterFrame  // число слотов
+	OpLeaveFrame
+	OpEnterScope
+	OpLeaveScope
+	OpBinary           // вид токена оператора
+	OpUnary            // вид токена оператора
+	OpCompound         // вид токена оператора
+	OpJump             // адрес
+	OpJumpIfFalse      // адрес
+	OpJumpIfNotNullish // адрес
+	OpJumpIfNullish    // адрес
+	OpClosure          // индекс константы
+	OpCall             // число аргументов
+	OpOptionalCall     // число аргументов, функция лежит под ними
+	OpReturn
+	OpTemplate // число частей
+)
+
+/*
+Ширины операндов каждой инструкции в байтах.
+*/
+var operandWidths = [...][]int{
+	OpConstant:         {4},
+	OpNull:             {},
+	OpUndefined:        {},
+	OpPop:              {},
+	OpDefineLocal:      {4, 1},
+	OpDefine:           {4, 1, 4},
+	OpDefineName:       {1, 4},
+	OpGetLocal:         {2, 4, 4},
+	OpSetLocal:         {2, 4, 4},
+	OpGetEnv:           {2, 4, 4},
+	OpSetEnv:           {2, 4, 4},
+	OpGetName:          {4},
+	OpSetName:          {4},
+	OpEnterFrame:       {4},
+	OpLeaveFrame:       {},
+	OpEnterScope:       {},
+	OpLeaveScope:       {},
+	OpBinary:           {2},
+	OpUnary:            {2},
+	OpCompound:         {2},
+	OpJump:             {4},
+	OpJumpIfFalse:      {4},
+	OpJumpIfNotNullish: {4},
+	OpJumpIfNullish:    {4},
+	OpClosure:          {4},
+	OpCall:             {2},
+	OpOptionalCall:     {2},
+	OpReturn:           {},
+	OpTemplate:         {4},
+}
+
+/*
+Собирает инструкцию из кода операции и операндов. Операнд, который не
+помещается в свою ширину, - ошибка; компилятор проверяет их через
+fits до вызова Make.
+*/
+func Make(op Opcode, operands ...int) []byte {
+	widths := operandWidths[op]
+	length := 1
+	for _, w := range widths {
+		length += w
+	}
+
+	instruction := make([]byte, length)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, operand := range operands {
+		if !fits(operand, widths[i]) {
+			panic(fmt.Sprintf("Operand %d does not fit in %d bytes", operand, widths[i]))
+		}
+		switch widths[i] {
+		case 1:
+			instruction[offset] = byte(operand)
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(operand))
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(operand))
+		}
+		offset += widths[i]
+	}
+
+	return instruction
+}
+
+func fits(operand int, width int) bool {
+	return operand >= 0 && uint64(operand) < 1<<(8*width)
+}
+
+func ReadUint8(ins Instructions) int {
+	return int(ins[0])
+}
+
+func ReadUint16(ins Instructions) int {
+	return int(binary.BigEndian.Uint16(ins))
+}
+
+func ReadUint32(ins Instructions) int {
+	return int(binary.BigEndian.Uint32(ins))
+}
+
+/*
+Прототип функции: её байткод. Параметры занимают первые слоты кадра
+вызова или, если тело вызывает eval, окружения вызова. Slots - размер
+кадра.
+
+Positions сопоставляет смещению инструкции OpCall позицию вызова
+в исходном коде.
+*/
+type Function struct {
+	Name         string
+	Instructions Instructions
+	Positions    map[int]lexer.Position
+	Env          bool
+	Slots        int
+}
+
+/*
+Результат компиляции программы. Main выполняется в окружении, переданном
+vm, переменные верхнего уровня объявляются в нём по имени.
+*/
+type Bytecode struct {
+	Main      *Function
+	Constants []runtime.RuntimeVal
+}
+
+func newFunction(name string) *Function {
+	return &Function{
+		Name:      name,
+		Positions: make(map[int]lexer.Position),
+	}
+}
@@ -0,0 +1,475 @@
+package compiler
+
+import (
+	"finescript/src/ast"
+	"finescript/src/helpers"
+	"finescript/src/lexer"
+	"finescript/src/runtime"
+	"fmt"
+	"math"
+)
+
+type compiler struct {
+	constants     []runtime.RuntimeVal
+	constantIndex map[runtime.RuntimeVal]int // Индексы уже добавленных простых значений
+	scope         *scope
+	fn            *Function
+	pos           lexer.Position // Компилируемая инструкция, для ошибок в операндах
+	text          func(pos lexer.Position) string
+	errors        []string
+}
+
+/*
+Переводит программу в байткод для vm.
+
+Имена связываются так же, как в resolver: глобальные переменные,
+встроенные функции и имена из областей, которые вызывают eval, ищутся
+по имени во время выполнения, остальные - по слоту. Локальные переменные
+лежат в кадрах vm, а если до них может добраться eval - в окружениях
+runtime, как в интерпретаторе.
+*/
+func Compile(program ast.Program, initialSource string) (*Bytecode, []string) {
+	return compileProgram(program, func(pos lexer.Position) string {
+		return helpers.Snippet(initialSource, pos.StartPos, pos.EndPos)
+	})
+}
+
+/*
+Как Compile, но фрагменты кода в ошибках берутся из потока, из которого
+программа была разобрана.
+*/
+func CompileStream(program ast.Program, stream *lexer.Stream) (*Bytecode, []string) {
+	return compileProgram(program, stream.Text)
+}
+
+func compileProgram(program ast.Program, text func(pos lexer.Position) string) (*Bytecode, []string) {
+	c := &compiler{
+		constants:     make([]runtime.RuntimeVal, 0),
+		constantIndex: make(map[runtime.RuntimeVal]int),
+		scope:         newScope(nil),
+		fn:            newFunction("main"),
+		text:          text,
+		errors:        make([]string, 0),
+	}
+	c.scope.global = true
+	c.scope.env = true
+	c.scope.dynamic = ast.ScopeCallsEval(program.Body)
+	c.hoist(program.Body)
+
+	c.compileBody(program.Body)
+	c.emit(OpReturn)
+
+	return &Bytecode{
+		Main:      c.fn,
+		Constants: c.constants,
+	}, c.errors
+}
+
+func (c *compiler) emit(op Opcode, operands ...int) int {
+	pos := len(c.fn.Instructions)
+	c.fn.Instructions = append(c.fn.Instructions, c.instruction(op, operands...)...)
+	return pos
+}
+
+func (c *compiler) patchJump(pos int) {
+	copy(c.fn.Instructions[pos:], c.instruction(Opcode(c.fn.Instructions[pos]), len(c.fn.Instructions)))
+}
+
+/*
+Собирает инструкцию, а операнд, который не помещается в свою ширину,
+записывает ошибкой компиляции вместо того, чтобы обрезать.
+*/
+func (c *compiler) instruction(op Opcode, operands ...int) []byte {
+	for i, operand := range operands {
+		if width := operandWidths[op][i]; !fits(operand, width) {
+			c.error(fmt.Sprintf("Program is too large: operand %d does not fit in %d bytes", operand, width), c.pos)
+			operands[i] = 0
+		}
+	}
+	return Make(op, operands...)
+}
+
+/*
+Одинаковые числа, строки и логические значения хранятся одной
+константой.
+*/
+func (c *compiler) addConstant(val runtime.RuntimeVal) int {
+	if shared(val) {
+		if index, exists := c.constantIndex[val]; exists {
+			return index
+		}
+		c.constantIndex[val] = len(c.constants)
+	}
+	c.constants = append(c.constants, val)
+	return len(c.constants) - 1
+}
+
+func shared(val runtime.RuntimeVal) bool {
+	switch v := val.(type) {
+	case runtime.IntVal, runtime.StringVal, runtime.BoolVal:
+		return true
+	case runtime.FloatVal:
+		// -0.0 равен 0.0 как ключ, но отличается при делении
+		return v.Value != 0 || !math.Signbit(v.Value)
+	}
+	return false
+}
+
+/*
+Индекс константы с именем переменной.
+*/
+func (c *compiler) name(name string) int {
+	return c.addConstant(runtime.StringVal{Value: name})
+}
+
+func (c *compiler) error(err any, pos lexer.Position) {
+	c.errors = append(c.errors, fmt.Sprintf("Compile Error at %s:\n%s\n%s", pos.String(), c.text(pos), err))
+}
+
+/*
+Открывает область для тела блока или ветки. Размер кадра известен
+заранее: объявления тела регистрируются до его компиляции.
+*/
+func (c *compiler) enterScope(body []ast.Stmt) {
+	c.scope = newScope(c.scope)
+	c.scope.env = ast.ContainsEval(body)
+	c.scope.dynamic = ast.ScopeCallsEval(body)
+	c.hoist(body)
+
+	if c.scope.env {
+		c.emit(OpEnterScope)
+	} else {
+		c.emit(OpEnterFrame, c.scope.slots)
+	}
+}
+
+func (c *compiler) leaveScope() {
+	if c.scope.env {
+		c.emit(OpLeaveScope)
+	} else {
+		c.emit(OpLeaveFrame)
+	}
+	c.scope = c.scope.parent
+}
+
+/*
+Заранее регистрирует объявления тела, как resolver: функции могут
+ссылаться на имена, объявленные после них.
+*/
+func (c *compiler) hoist(body []ast.Stmt) {
+	for _, node := range body {
+		switch stmt := node.(type) {
+		case ast.VarDeclStmt:
+			c.declare(stmt.Name, stmt.IsConstant, stmt.Position)
+		case ast.FunDeclStmt:
+			c.declare(stmt.Name, true, stmt.Position)
+		case ast.TypeAliasDecl:
+			c.declare(stmt.Name, true, stmt.Position)
+		}
+	}
+}
+
+/*
+Регистрирует имя в текущей области, оно получает следующий слот её
+кадра или окружения.
+*/
+func (c *compiler) declare(name string, constant bool, pos lexer.Position) *symbol {
+	if _, exists := c.scope.symbols[name]; exists {
+		c.error("Variable exists", pos)
+	}
+
+	sym := &symbol{
+		name:     name,
+		slot:     c.scope.slots,
+		constant: constant,
+	}
+	c.scope.slots++
+	c.scope.symbols[name] = sym
+	return sym
+}
+
+/*
+Объявляет зарегистрированное имя текущей области значением с вершины
+стека.
+*/
+func (c *compiler) define(name string) *symbol {
+	sym := c.scope.symbols[name]
+	sym.declared = true
+
+	constant := 0
+	if sym.constant {
+		constant = 1
+	}
+	switch {
+	case c.scope.global:
+		c.emit(OpDefineName, constant, c.name(sym.name))
+	case c.scope.env:
+		c.emit(OpDefine, sym.slot, constant, c.name(sym.name))
+	default:
+		c.emit(OpDefineLocal, sym.slot, constant)
+	}
+	return sym
+}
+
+func (c *compiler) load(ident ast.Identifier) {
+	loc := c.scope.resolve(ident.Name)
+	switch {
+	case loc.named:
+		c.emit(OpGetName, c.name(ident.Name))
+	case loc.env:
+		c.emit(OpGetEnv, loc.depth, loc.sym.slot, c.name(ident.Name))
+	default:
+		c.emit(OpGetLocal, loc.depth, loc.sym.slot, c.name(ident.Name))
+	}
+}
+
+func (c *compiler) store(ident ast.Identifier) {
+	loc := c.scope.resolve(ident.Name)
+	if loc.sym != nil && loc.sym.constant {
+		c.error(fmt.Sprintf("Cannot reasign to variable \"%s\" as it was declared constant.", ident.Name), ident.Position)
+	}
+	switch {
+	case loc.named:
+		c.emit(OpSetName, c.name(ident.Name))
+	case loc.env:
+		c.emit(OpSetEnv, loc.depth, loc.sym.slot, c.name(ident.Name))
+	default:
+		c.emit(OpSetLocal, loc.depth, loc.sym.slot, c.name(ident.Name))
+	}
+}
+
+/*
+Каждая инструкция тела оставляет на стеке одно значение; результатом
+тела считается значение последней, как и в интерпретаторе.
+*/
+func (c *compiler) compileBody(body []ast.Stmt) {
+	if len(body) == 0 {
+		c.emit(OpNull)
+		return
+	}
+	for i, stmt := range body {
+		c.compileStmt(stmt)
+		if i < len(body)-1 {
+			c.emit(OpPop)
+		}
+	}
+}
+
+func (c *compiler) compileStmt(node ast.Stmt) {
+	c.pos = node.Pos()
+	switch stmt := node.(type) {
+	case ast.BlockStmt:
+		c.enterScope(stmt.Body)
+		c.compileBody(stmt.Body)
+		c.leaveScope()
+	case ast.VarDeclStmt:
+		c.compileExpr(stmt.Value)
+		c.define(stmt.Name)
+	case ast.FunDeclStmt:
+		c.compileFunction(stmt)
+		c.define(stmt.Name)
+	case ast.TypeAliasDecl:
+		c.compileTypeAlias(stmt)
+	case ast.IfStmt:
+		c.compileIfStmt(stmt)
+	case ast.ExprStmt:
+		c.compileExpr(stmt.Expr)
+	default:
+		c.error("Unknown Stmt", node.Pos())
+		c.emit(OpNull)
+	}
+}
+
+func (c *compiler) compileFunction(stmt ast.FunDeclStmt) {
+	outerFn := c.fn
+	c.fn = newFunction(stmt.Name)
+	c.scope = newScope(c.scope)
+	c.scope.env = ast.ContainsEval(stmt.Body)
+	c.scope.dynamic = ast.ScopeCallsEval(stmt.Body)
+
+	for _, param := range stmt.Params {
+		c.declare(param.Name, false, stmt.Position).declared = true
+	}
+	c.hoist(stmt.Body)
+	c.compileBody(stmt.Body)
+	c.emit(OpReturn)
+
+	fn := c.fn
+	fn.Env = c.scope.env
+	fn.Slots = c.scope.slots
+	c.scope = c.scope.parent
+	c.fn = outerFn
+	c.pos = stmt.Position
+
+	c.emit(OpClosure, c.addConstant(runtime.CompiledFnVal{
+		Name:       stmt.Name,
+		Params:     stmt.Params,
+		ReturnType: stmt.ReturnType,
+		Code:       fn,
+	}))
+}
+
+/*
+Псевдонимы типов раскрываются при компиляции, в байткод попадает
+готовое значение.
+*/
+func (c *compiler) compileTypeAlias(stmt ast.TypeAliasDecl) {
+	alias := runtime.TypeAliasVal{Name: stmt.Name}
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.error(r, stmt.Position)
+			}
+		}()
+		alias.Type = runtime.ResolveType(stmt.Type, func(name string) runtime.RuntimeVal {
+			sym := c.scope.lookup(name)
+			if sym == nil {
+				panic(fmt.Sprintf("Cannot resolve \"%s\" as it does not exist.", name))
+			}
+			if sym.alias == nil {
+				return runtime.NullVal{}
+			}
+			return *sym.alias
+		})
+	}()
+
+	c.emit(OpConstant, c.addConstant(alias))
+	c.define(stmt.Name).alias = &alias
+}
+
+func (c *compiler) compileIfStmt(stmt ast.IfStmt) {
+	c.compileExpr(stmt.Condition)
+	jumpIfFalse := c.emit(OpJumpIfFalse, 0)
+
+	c.compileBranch(stmt.Consequent)
+	jump := c.emit(OpJump, 0)
+
+	c.patchJump(jumpIfFalse)
+	c.compileBranch(stmt.Alternate)
+	c.patchJump(jump)
+
+	c.emit(OpNull)
+}
+
+func (c *compiler) compileBranch(body []ast.Stmt) {
+	c.enterScope(body)
+	for _, stmt := range body {
+		c.compileStmt(stmt)
+		c.emit(OpPop)
+	}
+	c.leaveScope()
+}
+
+func (c *compiler) compileExpr(node ast.Expr) {
+	switch expr := node.(type) {
+	case ast.Identifier:
+		c.load(expr)
+	case ast.IntLiteral:
+		c.emit(OpConstant, c.addConstant(runtime.IntVal{Value: expr.Value}))
+	case ast.FloatLiteral:
+		c.emit(OpConstant, c.addConstant(runtime.FloatVal{Value: expr.Value}))
+	case ast.StringLiteral:
+		c.emit(OpConstant, c.addConstant(runtime.StringVal{Value: expr.Value}))
+	case ast.BoolLiteral:
+		c.emit(OpConstant, c.addConstant(runtime.BoolVal{Value: expr.Value}))
+	case ast.NullLiteral:
+		c.emit(OpNull)
+	case ast.UndefinedLiteral:
+		c.emit(OpUndefined)
+	case ast.BinaryExpr:
+		c.compileExpr(expr.Left)
+		if expr.Op.Kind == lexer.QUESTION_QUESTION {
+			jump := c.emit(OpJumpIfNotNullish, 0)
+			c.compileExpr(expr.Right)
+			c.patchJump(jump)
+			break
+		}
+		c.compileExpr(expr.Right)
+		c.emit(OpBinary, int(expr.Op.Kind))
+	case ast.UnaryExpr:
+		c.compileExpr(expr.Expr)
+		c.emit(OpUnary, int(expr.Op.Kind))
+		if ident, ok := expr.Expr.(ast.Identifier); ok {
+			switch expr.Op.Kind {
+			case lexer.PLUS_PLUS, lexer.MINUS_MINUS:
+				c.store(ident)
+			}
+		}
+	case ast.AssignExpr:
+		c.compileAssignExpr(expr)
+	case ast.CallExpr:
+		c.compileCallExpr(expr)
+	case ast.ConditionalExpr:
+		c.compileExpr(expr.Condition)
+		jumpIfFalse := c.emit(OpJumpIfFalse, 0)
+		c.compileExpr(expr.Consequent)
+		jump := c.emit(OpJump, 0)
+		c.patchJump(jumpIfFalse)
+		c.compileExpr(expr.Alternate)
+		c.patchJump(jump)
+	case ast.TemplateExpr:
+		for _, part := range expr.Parts {
+			c.compileExpr(part)
+		}
+		c.emit(OpTemplate, len(expr.Parts))
+	default:
+		c.error(fmt.Sprintf("Unknown Expr at %s", expr.Pos().String()), expr.Pos())
+		c.emit(OpNull)
+	}
+}
+
+/*
+У f?.(args) сначала вычисляется функция: если её нет, аргументы не
+вычисляются и результатом становится undefined.
+*/
+func (c *compiler) compileCallExpr(expr ast.CallExpr) {
+	if !expr.Optional {
+		for _, arg := range expr.Args {
+			c.compileExpr(arg)
+		}
+		c.compileExpr(expr.Caller)
+		pos := c.emit(OpCall, len(expr.Args))
+		c.fn.Positions[pos] = expr.Position
+		return
+	}
+
+	c.compileExpr(expr.Caller)
+	jump := c.emit(OpJumpIfNullish, 0)
+	for _, arg := range expr.Args {
+		c.compileExpr(arg)
+	}
+	pos := c.emit(OpOptionalCall, len(expr.Args))
+	c.fn.Positions[pos] = expr.Position
+	c.patchJump(jump)
+}
+
+func (c *compiler) compileAssignExpr(expr ast.AssignExpr) {
+	ident, ok := expr.Assigne.(ast.Identifier)
+	if !ok {
+		c.error("Invalid left hand side expr inside assignment expr", expr.Position)
+		c.emit(OpNull)
+		return
+	}
+
+	switch {
+	case expr.Op.Kind == lexer.ASSIGNMENT:
+		c.compileExpr(expr.Expr)
+	case expr.Op.Kind == lexer.QUESTION_QUESTION_EQUALS:
+		c.load(ident)
+		jump := c.emit(OpJumpIfNotNullish, 0)
+		c.compileExpr(expr.Expr)
+		c.store(ident)
+		c.patchJump(jump)
+		return
+	case runtime.IsCompoundAssign(expr.Op.Kind):
+		c.load(ident)
+		c.compileExpr(expr.Expr)
+		c.emit(OpCompound, int(expr.Op.Kind))
+	default:
+		c.error("Unknown Op kind", expr.Position)
+		c.emit(OpNull)
+		return
+	}
+	c.store(ident)
+}
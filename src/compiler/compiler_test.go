@@ -0,0 +1,170 @@
+package compiler
+
+import (
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"finescript/src/runtime"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func compile(t *testing.T, source string) *Bytecode {
+	t.Helper()
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	program, errs := parser.Parse(tokens, source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	bytecode, errs := Compile(program, source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	return bytecode
+}
+
+/*
+Коды операций байткода по порядку, без операндов.
+*/
+func opcodes(ins Instructions) []Opcode {
+	ops := make([]Opcode, 0)
+	for ip := 0; ip < len(ins); {
+		op := Opcode(ins[ip])
+		ops = append(ops, op)
+		ip++
+		for _, width := range operandWidths[op] {
+			ip += width
+		}
+	}
+	return ops
+}
+
+func function(t *testing.T, bytecode *Bytecode, name string) *Function {
+	t.Helper()
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(runtime.CompiledFnVal); ok && fn.Name == name {
+			return fn.Code.(*Function)
+		}
+	}
+	t.Fatalf("function %s is not compiled", name)
+	return nil
+}
+
+func TestMakeOperands(t *testing.T) {
+	ins := Instructions(Make(OpGetLocal, 3, 70000, 1<<31))
+	if len(ins) != 11 || Opcode(ins[0]) != OpGetLocal {
+		t.Fatalf("instruction % x", ins)
+	}
+	if depth, slot, name := ReadUint16(ins[1:]), ReadUint32(ins[3:]), ReadUint32(ins[7:]); depth != 3 || slot != 70000 || name != 1<<31 {
+		t.Errorf("operands %d %d %d", depth, slot, name)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("operand 70000 fits in 2 bytes")
+		}
+	}()
+	Make(OpCall, 70000)
+}
+
+/*
+Локальные переменные лежат в кадрах, пока до них не может добраться
+eval: из самой области, из вложенной или из вложенной функции.
+*/
+func TestLocalsInFrames(t *testing.T) {
+	tests := []struct {
+		source string
+		env    bool
+		slots  int
+	}{
+		{"fun f(a: int) {\n  var b = a\n  b\n}", false, 2},
+		{"fun f() {\n  eval(\"1\")\n}", true, 0},
+		{"fun f() {\n  var x = 1\n  {\n    eval(\"x\")\n  }\n}", true, 1},
+		{"fun f(a: int) {\n  fun g() { eval(\"a\") }\n  g()\n}", true, 2},
+		{"fun g() { eval(\"1\") }\nfun f() { g() }", false, 0},
+	}
+	for _, tt := range tests {
+		fn := function(t, compile(t, tt.source), "f")
+		if fn.Env != tt.env || fn.Slots != tt.slots {
+			t.Errorf("%q: env %v slots %d, want env %v slots %d", tt.source, fn.Env, fn.Slots, tt.env, tt.slots)
+		}
+	}
+}
+
+/*
+Глобальные переменные и имена в областях с eval ищутся по имени, как
+у resolver.
+*/
+func TestNameBinding(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []Opcode
+	}{
+		{"var g = 1\ng", []Opcode{OpConstant, OpDefineName, OpPop, OpGetName, OpReturn}},
+		{"{\n  var x = 1\n  x\n}", []Opcode{OpEnterFrame, OpConstant, OpDefineLocal, OpPop, OpGetLocal, OpLeaveFrame, OpReturn}},
+		{"var g = 1\n{\n  eval(\"var g = 2\")\n  g\n}", []Opcode{
+			OpConstant, OpDefineName, OpPop,
+			OpEnterScope, OpConstant, OpGetName, OpCall, OpPop, OpGetName, OpLeaveScope, OpReturn,
+		}},
+		{"{\n  var x = 1\n  {\n    eval(\"x\")\n    x\n  }\n}", []Opcode{
+			OpEnterScope, OpConstant, OpDefine, OpPop,
+			OpEnterScope, OpConstant, OpGetName, OpCall, OpPop, OpGetName, OpLeaveScope, OpLeaveScope, OpReturn,
+		}},
+		{"{\n  var x = 1\n  {\n    eval(\"1\")\n  }\n  x\n}", []Opcode{
+			OpEnterScope, OpConstant, OpDefine, OpPop,
+			OpEnterScope, OpConstant, OpGetName, OpCall, OpLeaveScope, OpPop, OpGetEnv, OpLeaveScope, OpReturn,
+		}},
+	}
+	for _, tt := range tests {
+		if got := opcodes(compile(t, tt.source).Main.Instructions); !slices.Equal(got, tt.want) {
+			t.Errorf("%q:\ngot  %v\nwant %v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"const c = 1\nc = 2", "Cannot reasign to variable \"c\" as it was declared constant."},
+		{"fun f() {\n  const c = 1\n  c++\n}", "Cannot reasign to variable \"c\" as it was declared constant."},
+		{"fun f(a: int) {\n  var a = 1\n}", "Variable exists"},
+	}
+	for _, tt := range tests {
+		tokens, _ := lexer.Tokenize(tt.source)
+		program, errs := parser.Parse(tokens, tt.source)
+		if len(errs) > 0 {
+			t.Fatal(strings.Join(errs, "\n"))
+		}
+		_, errs = Compile(program, tt.source)
+		if len(errs) != 1 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: errors %q, want %q", tt.source, errs, tt.want)
+		}
+	}
+}
+
+func TestCompileStreamSnippet(t *testing.T) {
+	source := "const c = 1\n" + strings.Repeat("println(c)\n", 20) + "c = 2\n"
+	spool, err := os.CreateTemp(t.TempDir(), "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	stream := lexer.NewStream(iotest.OneByteReader(strings.NewReader(source))).KeepSource(spool)
+	program, errs := parser.ParseStream(stream)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	_, errs = CompileStream(program, stream)
+	want := "Compile Error at 22:1:\nc\nCannot reasign to variable \"c\" as it was declared constant."
+	if len(errs) != 1 || errs[0] != want {
+		t.Errorf("errors %q, want %q", errs, want)
+	}
+}
@@ -0,0 +1,98 @@
+package compiler
+
+import (
+	"finescript/src/runtime"
+)
+
+type symbol struct {
+	name     string
+	slot     int
+	constant bool
+	declared bool
+	alias    *runtime.TypeAliasVal // Только у псевдонимов типов
+}
+
+/*
+Лексическая область видимости: программа, блок, ветка if или вызов
+функции.
+
+Переменные области, до которых может добраться eval, живут в окружении
+runtime, как в интерпретаторе: по слоту и по имени. Остальные vm хранит
+в кадре - массиве без имён, который не требует окружения на каждый блок
+и вызов. Если окружение нужно области, оно нужно и всем внешним, поэтому
+кадры лежат только внутри окружений, но не наоборот.
+*/
+type scope struct {
+	symbols map[string]*symbol
+	parent  *scope
+	slots   int
+	// Переменные программы, как и в resolver, доступны только по имени
+	global bool
+	// Область или вложенные в неё вызывают eval, evalIn или currentEnv
+	env bool
+	// Сама область вызывает их, и eval может объявить в ней новое имя
+	dynamic bool
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{
+		symbols: make(map[string]*symbol),
+		parent:  parent,
+	}
+}
+
+/*
+Где лежит переменная во время выполнения. depth - число областей того
+же вида (кадров или окружений) между местом использования и
+объявлением, по нему vm поднимается к нужному кадру или окружению.
+*/
+type location struct {
+	sym   *symbol
+	depth int
+	env   bool
+	named bool // Ищется по имени: глобальная или объявленная через eval
+}
+
+/*
+Ищет имя во вложенных областях так же, как resolver: имя, которого нет
+в области с eval, ищется во время выполнения по имени, ведь eval мог
+его объявить.
+*/
+func (s *scope) resolve(name string) location {
+	frames, envs := 0, 0
+	for current := s; current != nil; current = current.parent {
+		if sym, exists := current.symbols[name]; exists {
+			switch {
+			case current.global:
+				return location{sym: sym, named: true}
+			case current.env:
+				return location{sym: sym, depth: envs, env: true}
+			default:
+				return location{sym: sym, depth: frames}
+			}
+		}
+		if current.dynamic {
+			break
+		}
+		if current.env {
+			envs++
+		} else {
+			frames++
+		}
+	}
+	return location{named: true}
+}
+
+/*
+Уже объявленное имя без учёта eval, для псевдонимов типов, которые
+раскрываются при компиляции. Как и во время выполнения, имя, которое
+ещё не объявлено, не перекрывает внешнее.
+*/
+func (s *scope) lookup(name string) *symbol {
+	for current := s; current != nil; current = current.parent {
+		if sym, exists := current.symbols[name]; exists && sym.declared {
+			return sym
+		}
+	}
+	return nil
+}
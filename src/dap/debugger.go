@@ -0,0 +1,403 @@
+package dap
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/runtime"
+	"fmt"
+	"sync"
+)
+
+type stepMode int
+
+const (
+	running stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+	pausing
+	// Остановка на первой строке программы
+	entering
+)
+
+/*
+Кадр стека: программа или вызов FunctionVal.
+*/
+type frame struct {
+	name string
+	// Окружение вызова с параметрами, у программы - глобальное
+	scope runtime.Environment
+	// Окружение последнего вычисленного выражения
+	env runtime.Environment
+	// Строка последней точки остановки, 0 до первой
+	line, column int
+}
+
+/*
+Сигнал, которым хук прерывает программу по запросу клиента.
+*/
+type terminated struct{}
+
+/*
+Хук интерпретатора. Точка остановки - первая инструкция или выражение
+на новой строке кадра: программа останавливается на ней, если там стоит
+точка останова с истинным условием или этого требует шаг.
+
+Хук работает в горутине программы и останавливает её, ожидая команду
+в resume. Запросы клиента в это время читают кадры из горутины сервера,
+поэтому состояние защищено mu.
+*/
+type debugger struct {
+	mu     sync.Mutex
+	frames []*frame
+	// Условия точек останова по строкам, пустое - без условия
+	breakpoints map[int]string
+	mode        stepMode
+	// Глубина стека на момент команды шага
+	depth int
+	// Позиция последнего вычисленного выражения для сообщений об ошибках
+	position    lexer.Position
+	paused      bool
+	evaluating  bool
+	terminating bool
+	// Окружения, которые раскрывает variablesReference, по номерам
+	// с единицы. Действительны, пока программа стоит
+	handles [][]runtime.Environment
+	resume  chan bool
+	stopped func(reason string)
+}
+
+func newDebugger(global runtime.Environment, stopped func(reason string)) *debugger {
+	return &debugger{
+		frames: []*frame{{
+			name:  "<program>",
+			scope: global,
+			env:   global,
+		}},
+		breakpoints: make(map[int]string),
+		resume:      make(chan bool),
+		stopped:     stopped,
+	}
+}
+
+func (d *debugger) Stmt(stmt ast.Stmt, env runtime.Environment) {
+	if _, ok := stmt.(ast.Program); ok {
+		return
+	}
+	d.reach(stmt.Pos(), env)
+}
+
+func (d *debugger) Expr(expr ast.Expr, env runtime.Environment) {
+	d.reach(expr.Pos(), env)
+}
+
+func (d *debugger) EnterCall(fn runtime.FunctionVal, scope runtime.Environment, site lexer.Position) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.evaluating {
+		return
+	}
+	d.frames = append(d.frames, &frame{
+		name:  fn.Name,
+		scope: scope,
+		env:   scope,
+	})
+}
+
+func (d *debugger) ExitCall(fn runtime.FunctionVal) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.evaluating || len(d.frames) <= 1 {
+		return
+	}
+	d.frames = d.frames[:len(d.frames)-1]
+}
+
+func (d *debugger) reach(pos lexer.Position, env runtime.Environment) {
+	d.mu.Lock()
+	if d.evaluating {
+		d.mu.Unlock()
+		return
+	}
+	if d.terminating {
+		d.mu.Unlock()
+		panic(terminated{})
+	}
+
+	d.position = pos
+	top := d.frames[len(d.frames)-1]
+	top.env = env
+	if pos.Line == 0 || pos.Line == top.line {
+		d.mu.Unlock()
+		return
+	}
+	top.line, top.column = pos.Line, pos.Column
+
+	reason := d.stepReason()
+	condition, hasBreakpoint := d.breakpoints[pos.Line]
+	d.mu.Unlock()
+
+	if reason == "" && hasBreakpoint && d.holds(condition, env) {
+		reason = "breakpoint"
+	}
+	if reason != "" {
+		d.pause(reason)
+	}
+}
+
+func (d *debugger) stepReason() string {
+	switch {
+	case d.mode == pausing:
+		return "pause"
+	case d.mode == entering:
+		return "entry"
+	case d.mode == stepIn,
+		d.mode == stepOver && len(d.frames) <= d.depth,
+		d.mode == stepOut && len(d.frames) < d.depth:
+		return "step"
+	}
+	return ""
+}
+
+/*
+Истинно ли условие точки останова. Ошибка в условии останавливает
+программу, чтобы её было видно.
+*/
+func (d *debugger) holds(condition string, env runtime.Environment) bool {
+	if condition == "" {
+		return true
+	}
+	value, err := d.evaluate(condition, env)
+	if err != nil {
+		return true
+	}
+	return runtime.ToBool(value).Value
+}
+
+/*
+Останавливает программу до команды клиента.
+*/
+func (d *debugger) pause(reason string) {
+	d.mu.Lock()
+	d.paused = true
+	d.mode = running
+	d.mu.Unlock()
+
+	d.stopped(reason)
+	if terminate := <-d.resume; terminate {
+		panic(terminated{})
+	}
+}
+
+/*
+Продолжает остановленную программу в режиме mode. Если программа
+выполняется, меняется только режим: так работает pause.
+*/
+func (d *debugger) proceed(mode stepMode) {
+	d.mu.Lock()
+	d.mode = mode
+	d.depth = len(d.frames)
+	d.handles = nil
+	paused := d.paused
+	d.paused = false
+	d.mu.Unlock()
+
+	if paused {
+		d.resume <- false
+	}
+}
+
+/*
+Прерывает программу: остановленную сразу, выполняющуюся - на
+следующем выражении.
+*/
+func (d *debugger) terminate() {
+	d.mu.Lock()
+	d.terminating = true
+	paused := d.paused
+	d.paused = false
+	d.mu.Unlock()
+
+	if paused {
+		d.resume <- true
+	}
+}
+
+func (d *debugger) setBreakpoints(breakpoints map[int]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = breakpoints
+}
+
+/*
+Вычисляет код в окружении env. Хук на это время отключается, чтобы
+вычисление не останавливалось и не меняло стек.
+*/
+func (d *debugger) evaluate(code string, env runtime.Environment) (value runtime.RuntimeVal, err error) {
+	d.mu.Lock()
+	d.evaluating = true
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.evaluating = false
+		d.mu.Unlock()
+
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	return runtime.Eval([]runtime.RuntimeVal{runtime.StringVal{Value: code}}, env), nil
+}
+
+/*
+Кадры сверху вниз. Номер кадра - его глубина, начиная с единицы у
+программы.
+*/
+func (d *debugger) stackTrace() []StackFrame {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	frames := make([]StackFrame, 0, len(d.frames))
+	for i := len(d.frames) - 1; i >= 0; i-- {
+		f := d.frames[i]
+		frames = append(frames, StackFrame{
+			ID:     i + 1,
+			Name:   f.name,
+			Line:   f.line,
+			Column: f.column,
+		})
+	}
+	return frames
+}
+
+func (d *debugger) frame(id int) (*frame, error) {
+	if !d.paused {
+		return nil, fmt.Errorf("the program is not paused")
+	}
+	if id < 1 || id > len(d.frames) {
+		return nil, fmt.Errorf("unknown frame %d", id)
+	}
+	return d.frames[id-1], nil
+}
+
+/*
+Области кадра: локальные окружения от текущего блока до вызова,
+окружения, захваченные функцией, и глобальное окружение.
+*/
+func (d *debugger) scopes(id int) ([]Scope, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := d.frame(id)
+	if err != nil {
+		return nil, err
+	}
+
+	global := &f.env
+	for global.Parent() != nil {
+		global = global.Parent()
+	}
+
+	locals := make([]runtime.Environment, 0)
+	for env := &f.env; env != nil && !env.Same(global); env = env.Parent() {
+		locals = append(locals, *env)
+		if env.Same(&f.scope) {
+			break
+		}
+	}
+	closure := make([]runtime.Environment, 0)
+	for env := f.scope.Parent(); env != nil && !env.Same(global); env = env.Parent() {
+		closure = append(closure, *env)
+	}
+
+	scopes := make([]Scope, 0, 3)
+	if len(locals) > 0 {
+		scopes = append(scopes, Scope{
+			Name:               "Locals",
+			PresentationHint:   "locals",
+			VariablesReference: d.handle(locals),
+		})
+	}
+	if len(closure) > 0 {
+		scopes = append(scopes, Scope{
+			Name:               "Closure",
+			VariablesReference: d.handle(closure),
+		})
+	}
+	return append(scopes, Scope{
+		Name:               "Globals",
+		VariablesReference: d.handle([]runtime.Environment{*global}),
+	}), nil
+}
+
+func (d *debugger) handle(envs []runtime.Environment) int {
+	d.handles = append(d.handles, envs)
+	return len(d.handles)
+}
+
+/*
+Переменные окружений по порядку: имя из внутреннего окружения
+перекрывает внешнее. Встроенные функции глобального окружения не
+показываются.
+*/
+func (d *debugger) variables(reference int) ([]Variable, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if reference < 1 || reference > len(d.handles) {
+		return nil, fmt.Errorf("unknown variables reference %d", reference)
+	}
+
+	seen := make(map[string]bool)
+	variables := make([]Variable, 0)
+	for _, env := range d.handles[reference-1] {
+		for _, name := range env.Names() {
+			value, _ := env.Lookup(name)
+			if _, native := value.(runtime.NativeFnVal); seen[name] || native && env.Parent() == nil {
+				continue
+			}
+			seen[name] = true
+			variables = append(variables, d.variable(name, value))
+		}
+	}
+	return variables, nil
+}
+
+/*
+Окружение, созданное newEnv или currentEnv, раскрывается как вложенная
+переменная.
+*/
+func (d *debugger) variable(name string, value runtime.RuntimeVal) Variable {
+	variable := Variable{
+		Name:  name,
+		Value: runtime.Display(value),
+		Type:  typeName(value),
+	}
+	if env, ok := value.(runtime.EnvironmentVal); ok {
+		variable.VariablesReference = d.handle([]runtime.Environment{env.Env})
+	}
+	return variable
+}
+
+func typeName(value runtime.RuntimeVal) string {
+	switch value.(type) {
+	case runtime.IntVal:
+		return "int"
+	case runtime.FloatVal:
+		return "float"
+	case runtime.StringVal:
+		return "string"
+	case runtime.BoolVal:
+		return "bool"
+	case runtime.NullVal:
+		return "null"
+	case runtime.UndefinedVal:
+		return "undefined"
+	case runtime.FunctionVal, runtime.CompiledFnVal, runtime.NativeFnVal:
+		return "fun"
+	case runtime.EnvironmentVal:
+		return "environment"
+	case runtime.TypeAliasVal:
+		return "type"
+	}
+	return ""
+}
@@ -0,0 +1,563 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"finescript/src/ast"
+	"finescript/src/jsonrpc"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"finescript/src/resolver"
+	"finescript/src/runtime"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Программа выполняется в одном потоке
+const threadID = 1
+
+/*
+Сервер Debug Adapter Protocol. Запросы обрабатываются по одному,
+программа выполняется в отдельной горутине деревом интерпретатора.
+
+Поток протокола - это stdin и stdout отладчика, поэтому вывод программы
+клиент получает событиями output, а ввода у программы нет.
+*/
+type Server struct {
+	reader *bufio.Reader
+	writer io.Writer
+	caps   runtime.Capability
+	// Сообщения отправляют и сервер, и горутина программы
+	mu  sync.Mutex
+	seq int
+	// 0 или 1 - с чего клиент считает строки и колонки
+	lineBase, columnBase int
+
+	path        string
+	program     ast.Program
+	env         runtime.Environment
+	debugger    *debugger
+	stopOnEntry bool
+	noDebug     bool
+	// Строки, на которых программа может остановиться
+	lines       map[int]bool
+	breakpoints []SourceBreakpoint
+	launched    bool
+	configured  bool
+}
+
+/*
+caps - группы встроенных функций, доступные программе.
+*/
+func NewServer(in io.Reader, out io.Writer, caps runtime.Capability) *Server {
+	return &Server{
+		reader:     bufio.NewReader(in),
+		writer:     out,
+		caps:       caps,
+		lineBase:   1,
+		columnBase: 1,
+	}
+}
+
+/*
+Обрабатывает запросы, пока клиент не пришлёт disconnect или не закроет
+поток.
+*/
+func (s *Server) Serve() error {
+	for {
+		body, err := jsonrpc.ReadMessage(s.reader)
+		if errors.Is(err, io.EOF) {
+			s.stop()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			return err
+		}
+
+		result, err := s.handle(req)
+		resp := response{
+			Type:       "response",
+			RequestSeq: req.Seq,
+			Success:    err == nil,
+			Command:    req.Command,
+			Body:       result,
+		}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		if err := s.send(&resp); err != nil {
+			return err
+		}
+
+		// Ответ на запрос уходит раньше события или запуска программы
+		var next error
+		switch {
+		case req.Command == "disconnect":
+			return nil
+		case req.Command == "initialize":
+			next = s.event("initialized", nil)
+		case err == nil && (req.Command == "launch" || req.Command == "configurationDone"):
+			next = s.start()
+		}
+		if next != nil {
+			return next
+		}
+	}
+}
+
+/*
+Отправляет ответ или событие со следующим номером.
+*/
+func (s *Server) send(message any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	switch m := message.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+	return jsonrpc.WriteMessage(s.writer, message)
+}
+
+func (s *Server) event(name string, body any) error {
+	return s.send(&event{
+		Type:  "event",
+		Event: name,
+		Body:  body,
+	})
+}
+
+func (s *Server) handle(req request) (any, error) {
+	switch req.Command {
+	case "initialize":
+		var args InitializeArguments
+		if err := unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+			s.lineBase = 0
+		}
+		if args.ColumnsStartAt1 != nil && !*args.ColumnsStartAt1 {
+			s.columnBase = 0
+		}
+		return Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsConditionalBreakpoints:   true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}, nil
+	case "launch":
+		var args LaunchArguments
+		if err := unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.launch(args)
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return map[string]any{"breakpoints": s.setBreakpoints(args)}, nil
+	case "setExceptionBreakpoints":
+		return map[string]any{"breakpoints": []Breakpoint{}}, nil
+	case "configurationDone":
+		s.configured = true
+		return nil, nil
+	case "threads":
+		return map[string]any{"threads": []Thread{{ID: threadID, Name: "main"}}}, nil
+	case "stackTrace":
+		if s.debugger == nil {
+			return nil, fmt.Errorf("the program is not running")
+		}
+		frames := s.debugger.stackTrace()
+		for i := range frames {
+			frames[i].Source = s.source()
+			frames[i].Line += s.lineBase - 1
+			frames[i].Column += s.columnBase - 1
+		}
+		return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}, nil
+	case "scopes":
+		var args ScopesArguments
+		if err := unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if s.debugger == nil {
+			return nil, fmt.Errorf("the program is not running")
+		}
+		scopes, err := s.debugger.scopes(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"scopes": scopes}, nil
+	case "variables":
+		var args VariablesArguments
+		if err := unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if s.debugger == nil {
+			return nil, fmt.Errorf("the program is not running")
+		}
+		variables, err := s.debugger.variables(args.VariablesReference)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"variables": variables}, nil
+	case "evaluate":
+		var args EvaluateArguments
+		if err := unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.evaluate(args)
+	case "continue":
+		s.proceed(running)
+		return map[string]any{"allThreadsContinued": true}, nil
+	case "next":
+		s.proceed(stepOver)
+		return nil, nil
+	case "stepIn":
+		s.proceed(stepIn)
+		return nil, nil
+	case "stepOut":
+		s.proceed(stepOut)
+		return nil, nil
+	case "pause":
+		s.proceed(pausing)
+		return nil, nil
+	case "terminate", "disconnect":
+		s.stop()
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("command %q is not supported", req.Command)
+	}
+}
+
+func unmarshal(arguments json.RawMessage, v any) error {
+	if len(arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(arguments, v)
+}
+
+/*
+Читает и разбирает программу. Оптимизатор не применяется: он меняет
+структуру кода, по которой идут шаги.
+*/
+func (s *Server) launch(args LaunchArguments) error {
+	path, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+	sourceBytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	source := string(sourceBytes)
+
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	program, errs := parser.Parse(tokens, source)
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	env := runtime.NewGlobalEnv(s.caps)
+	program, errs = resolver.Resolve(program, source, env.Names())
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	env.SetConsole(output{s}, nil)
+
+	s.path = path
+	s.program = program
+	s.env = env
+	s.stopOnEntry = args.StopOnEntry
+	s.noDebug = args.NoDebug
+	s.lines = stopLines(program)
+	s.launched = true
+	return nil
+}
+
+func (s *Server) source() *Source {
+	return &Source{
+		Name: filepath.Base(s.path),
+		Path: s.path,
+	}
+}
+
+/*
+Запускает программу, когда она разобрана и клиент закончил настройку.
+*/
+func (s *Server) start() error {
+	if !s.launched || !s.configured || s.debugger != nil {
+		return nil
+	}
+
+	s.debugger = newDebugger(s.env, func(reason string) {
+		s.event("stopped", StoppedEventBody{
+			Reason:            reason,
+			ThreadID:          threadID,
+			AllThreadsStopped: true,
+		})
+	})
+	if !s.noDebug {
+		s.debugger.setBreakpoints(s.relocate(s.breakpoints))
+		s.env.SetDebugger(s.debugger)
+	}
+	if s.stopOnEntry {
+		s.debugger.mode = entering
+	}
+
+	go s.run()
+	return nil
+}
+
+/*
+Вывод print и println, который уходит клиенту событиями output.
+*/
+type output struct {
+	server *Server
+}
+
+func (o output) Write(p []byte) (int, error) {
+	err := o.server.event("output", OutputEventBody{
+		Category: "stdout",
+		Output:   string(p),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *Server) run() {
+	code, err := s.execute()
+	if err != nil {
+		s.event("output", OutputEventBody{
+			Category: "stderr",
+			Output:   err.Error() + "\n",
+		})
+	}
+	s.event("exited", ExitedEventBody{ExitCode: code})
+	s.event("terminated", nil)
+}
+
+func (s *Server) execute() (code int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(terminated); ok {
+				return
+			}
+			s.debugger.mu.Lock()
+			pos := s.debugger.position
+			s.debugger.mu.Unlock()
+			if pos.Line == 0 {
+				code, err = 1, fmt.Errorf("Runtime Error: %v", r)
+				return
+			}
+			code, err = 1, fmt.Errorf("Runtime Error at %s:\n%v", pos.String(), r)
+		}
+	}()
+
+	_, exit := runtime.Run(s.program, s.env)
+	if exit != nil {
+		return exit.Code, nil
+	}
+	return 0, nil
+}
+
+func (s *Server) proceed(mode stepMode) {
+	if s.debugger != nil {
+		s.debugger.proceed(mode)
+	}
+}
+
+func (s *Server) stop() {
+	if s.debugger != nil {
+		s.debugger.terminate()
+	}
+}
+
+/*
+Запоминает точки останова программы. Точка на строке, где программа
+не может остановиться, переносится на ближайшую следующую такую строку.
+*/
+func (s *Server) setBreakpoints(args SetBreakpointsArguments) []Breakpoint {
+	if s.launched && args.Source.Path != "" {
+		if path, err := filepath.Abs(args.Source.Path); err == nil && path != s.path {
+			breakpoints := make([]Breakpoint, len(args.Breakpoints))
+			for i := range args.Breakpoints {
+				breakpoints[i] = Breakpoint{
+					ID:      i + 1,
+					Message: "The file is not the debugged program",
+				}
+			}
+			return breakpoints
+		}
+	}
+
+	requested := make([]SourceBreakpoint, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		requested[i] = SourceBreakpoint{
+			Line:      bp.Line - s.lineBase + 1,
+			Condition: bp.Condition,
+		}
+	}
+	s.breakpoints = requested
+	if s.debugger != nil && !s.noDebug {
+		s.debugger.setBreakpoints(s.relocate(requested))
+	}
+
+	breakpoints := make([]Breakpoint, len(requested))
+	for i, bp := range requested {
+		breakpoints[i] = Breakpoint{
+			ID:       i + 1,
+			Verified: true,
+			Line:     bp.Line + s.lineBase - 1,
+		}
+		if !s.launched {
+			continue
+		}
+		line, ok := s.nextLine(bp.Line)
+		if !ok {
+			breakpoints[i].Verified = false
+			breakpoints[i].Message = "No code at or after this line"
+			continue
+		}
+		breakpoints[i].Line = line + s.lineBase - 1
+		breakpoints[i].Source = s.source()
+	}
+	return breakpoints
+}
+
+func (s *Server) relocate(requested []SourceBreakpoint) map[int]string {
+	breakpoints := make(map[int]string)
+	for _, bp := range requested {
+		if line, ok := s.nextLine(bp.Line); ok {
+			breakpoints[line] = bp.Condition
+		}
+	}
+	return breakpoints
+}
+
+func (s *Server) nextLine(line int) (int, bool) {
+	lines := make([]int, 0, len(s.lines))
+	for l := range s.lines {
+		lines = append(lines, l)
+	}
+	sort.Ints(lines)
+	i := sort.SearchInts(lines, line)
+	if i == len(lines) {
+		return 0, false
+	}
+	return lines[i], true
+}
+
+/*
+Вычисляет выражение в окружении кадра. Без кадра - в глобальном
+окружении, если программа не остановлена.
+*/
+func (s *Server) evaluate(args EvaluateArguments) (any, error) {
+	if s.debugger == nil {
+		return nil, fmt.Errorf("the program is not running")
+	}
+
+	d := s.debugger
+	d.mu.Lock()
+	id := args.FrameID
+	if id == 0 {
+		id = len(d.frames)
+	}
+	f, err := d.frame(id)
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := d.evaluate(args.Expression, f.env)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	variable := d.variable("", value)
+	d.mu.Unlock()
+	return map[string]any{
+		"result":             variable.Value,
+		"type":               variable.Type,
+		"variablesReference": variable.VariablesReference,
+	}, nil
+}
+
+/*
+Строки, на которых начинается хотя бы одна инструкция или выражение.
+*/
+func stopLines(program ast.Program) map[int]bool {
+	lines := make(map[int]bool)
+	var stmts func(body []ast.Stmt)
+	var expr func(node ast.Expr)
+	expr = func(node ast.Expr) {
+		if node == nil {
+			return
+		}
+		lines[node.Pos().Line] = true
+		switch e := node.(type) {
+		case ast.UnaryExpr:
+			expr(e.Expr)
+		case ast.BinaryExpr:
+			expr(e.Left)
+			expr(e.Right)
+		case ast.AssignExpr:
+			expr(e.Assigne)
+			expr(e.Expr)
+		case ast.CallExpr:
+			expr(e.Caller)
+			for _, arg := range e.Args {
+				expr(arg)
+			}
+		case ast.ConditionalExpr:
+			expr(e.Condition)
+			expr(e.Consequent)
+			expr(e.Alternate)
+		case ast.TemplateExpr:
+			for _, part := range e.Parts {
+				expr(part)
+			}
+		}
+	}
+	stmts = func(body []ast.Stmt) {
+		for _, node := range body {
+			lines[node.Pos().Line] = true
+			switch stmt := node.(type) {
+			case ast.BlockStmt:
+				stmts(stmt.Body)
+			case ast.VarDeclStmt:
+				expr(stmt.Value)
+			case ast.FunDeclStmt:
+				stmts(stmt.Body)
+			case ast.IfStmt:
+				expr(stmt.Condition)
+				stmts(stmt.Consequent)
+				stmts(stmt.Alternate)
+			case ast.ExprStmt:
+				expr(stmt.Expr)
+			}
+		}
+	}
+	stmts(program.Body)
+	delete(lines, 0)
+	return lines
+}
@@ -0,0 +1,383 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"finescript/src/jsonrpc"
+	"finescript/src/runtime"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Клиент DAP, подключённый к серверу через io.Pipe. События программы
+приходят в любой момент, поэтому те, что пришли во время ожидания
+ответа, откладываются в events.
+*/
+type client struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	messages chan json.RawMessage
+	done     chan error
+	seq      int
+	events   []message
+}
+
+type message struct {
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{
+		t:        t,
+		in:       clientOut,
+		messages: make(chan json.RawMessage, 16),
+		done:     make(chan error, 1),
+	}
+
+	server := NewServer(serverIn, serverOut, runtime.CapAll)
+	go func() {
+		c.done <- server.Serve()
+		serverOut.Close()
+	}()
+	go func() {
+		reader := bufio.NewReader(clientIn)
+		for {
+			body, err := jsonrpc.ReadMessage(reader)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			c.messages <- body
+		}
+	}()
+	t.Cleanup(func() {
+		clientOut.Close()
+	})
+	return c
+}
+
+func (c *client) next() message {
+	c.t.Helper()
+	select {
+	case body, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("server closed the connection")
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			c.t.Fatal(err)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("no message from server")
+	}
+	return message{}
+}
+
+/*
+Отправляет запрос и разбирает тело ответа в body. Неуспешный ответ -
+ошибка теста.
+*/
+func (c *client) request(command string, arguments any, body any) {
+	c.t.Helper()
+	c.seq++
+	seq := c.seq
+	req := map[string]any{"seq": seq, "type": "request", "command": command}
+	if arguments != nil {
+		req["arguments"] = arguments
+	}
+	if err := jsonrpc.WriteMessage(c.in, req); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		msg := c.next()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg.RequestSeq != seq {
+			c.t.Fatalf("%s: expected response to %d, got %+v", command, seq, msg)
+		}
+		if !msg.Success {
+			c.t.Fatalf("%s: %s", command, msg.Message)
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return
+	}
+}
+
+/*
+Ждёт событие name. События output, пришедшие до него, копятся в output.
+*/
+func (c *client) event(name string, body any, output *strings.Builder) {
+	c.t.Helper()
+	for {
+		var msg message
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg.Type != "event" {
+			c.t.Fatalf("expected event %s, got %+v", name, msg)
+		}
+		if msg.Event == "output" && name != "output" {
+			var out OutputEventBody
+			if err := json.Unmarshal(msg.Body, &out); err != nil {
+				c.t.Fatal(err)
+			}
+			if output != nil {
+				output.WriteString(out.Category + ": " + out.Output)
+			}
+			continue
+		}
+		if msg.Event != name {
+			c.t.Fatalf("expected event %s, got %s %s", name, msg.Event, msg.Body)
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return
+	}
+}
+
+/*
+Ждёт остановку программы и возвращает её причину и строку верхнего
+кадра.
+*/
+func (c *client) stopped() (string, int) {
+	c.t.Helper()
+	var stopped StoppedEventBody
+	c.event("stopped", &stopped, nil)
+	return stopped.Reason, c.stackTrace()[0].Line
+}
+
+func (c *client) stackTrace() []StackFrame {
+	c.t.Helper()
+	var trace struct {
+		StackFrames []StackFrame `json:"stackFrames"`
+	}
+	c.request("stackTrace", map[string]any{"threadId": threadID}, &trace)
+	return trace.StackFrames
+}
+
+/*
+Ждёт конца программы и возвращает её код выхода и вывод.
+*/
+func (c *client) exited() (int, string) {
+	c.t.Helper()
+	var output strings.Builder
+	var exited ExitedEventBody
+	c.event("exited", &exited, &output)
+	c.event("terminated", nil, &output)
+	return exited.ExitCode, output.String()
+}
+
+func (c *client) disconnect() {
+	c.t.Helper()
+	c.request("disconnect", nil, nil)
+	select {
+	case err := <-c.done:
+		if err != nil {
+			c.t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("server did not exit")
+	}
+}
+
+const testProgram = `fun add(a: int, b: int) {
+  var sum = a + b
+  sum
+}
+var total = add(1, 2)
+println("total ", total)
+exit(total)
+`
+
+/*
+Запускает программу source до configurationDone включительно.
+breakpoints - строки точек останова.
+*/
+func launch(t *testing.T, source string, stopOnEntry bool, breakpoints ...SourceBreakpoint) (*client, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "main.fs")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	var capabilities Capabilities
+	c.request("initialize", map[string]any{"adapterID": "finescript"}, &capabilities)
+	if !capabilities.SupportsConfigurationDoneRequest || !capabilities.SupportsConditionalBreakpoints {
+		t.Errorf("unexpected capabilities %+v", capabilities)
+	}
+	c.event("initialized", nil, nil)
+	c.request("launch", LaunchArguments{Program: path, StopOnEntry: stopOnEntry}, nil)
+	if len(breakpoints) > 0 {
+		var set struct {
+			Breakpoints []Breakpoint `json:"breakpoints"`
+		}
+		c.request("setBreakpoints", SetBreakpointsArguments{Source: Source{Path: path}, Breakpoints: breakpoints}, &set)
+		for i, bp := range set.Breakpoints {
+			if !bp.Verified || bp.Line != breakpoints[i].Line {
+				t.Errorf("breakpoint %+v, want verified on line %d", bp, breakpoints[i].Line)
+			}
+		}
+	}
+	c.request("configurationDone", nil, nil)
+	return c, path
+}
+
+func TestBreakpoints(t *testing.T) {
+	c, path := launch(t, testProgram, false, SourceBreakpoint{Line: 2, Condition: "a == 1"}, SourceBreakpoint{Line: 6})
+
+	if reason, line := c.stopped(); reason != "breakpoint" || line != 2 {
+		t.Errorf("stopped by %s on line %d, want breakpoint on line 2", reason, line)
+	}
+	frames := c.stackTrace()
+	if len(frames) != 2 || frames[0].Name != "add" || frames[1].Name != "<program>" || frames[1].Line != 5 {
+		t.Errorf("unexpected stack %+v", frames)
+	}
+	if frames[0].Source == nil || frames[0].Source.Path != path {
+		t.Errorf("frame source %+v, want %s", frames[0].Source, path)
+	}
+
+	c.request("continue", map[string]any{"threadId": threadID}, nil)
+	if reason, line := c.stopped(); reason != "breakpoint" || line != 6 {
+		t.Errorf("stopped by %s on line %d, want breakpoint on line 6", reason, line)
+	}
+	c.request("continue", map[string]any{"threadId": threadID}, nil)
+	if code, output := c.exited(); code != 3 || output != "stdout: total 3\n" {
+		t.Errorf("exited with %d and output %q", code, output)
+	}
+	c.disconnect()
+}
+
+func TestFalseConditionSkipsBreakpoint(t *testing.T) {
+	c, _ := launch(t, testProgram, false, SourceBreakpoint{Line: 3, Condition: "sum > 10"})
+	if code, _ := c.exited(); code != 3 {
+		t.Errorf("exited with %d, want 3", code)
+	}
+	c.disconnect()
+}
+
+func TestStepping(t *testing.T) {
+	c, _ := launch(t, testProgram, true)
+
+	steps := []struct {
+		command string
+		reason  string
+		line    int
+	}{
+		{"", "entry", 1},
+		{"next", "step", 5},
+		{"stepIn", "step", 2},
+		{"next", "step", 3},
+		{"stepOut", "step", 6},
+	}
+	for _, step := range steps {
+		if step.command != "" {
+			c.request(step.command, map[string]any{"threadId": threadID}, nil)
+		}
+		if reason, line := c.stopped(); reason != step.reason || line != step.line {
+			t.Fatalf("after %q stopped by %s on line %d, want %s on line %d", step.command, reason, line, step.reason, step.line)
+		}
+	}
+
+	c.request("continue", map[string]any{"threadId": threadID}, nil)
+	if code, _ := c.exited(); code != 3 {
+		t.Errorf("exited with %d, want 3", code)
+	}
+	c.disconnect()
+}
+
+func TestVariables(t *testing.T) {
+	c, _ := launch(t, testProgram, false, SourceBreakpoint{Line: 3})
+	c.stopped()
+
+	var scopes struct {
+		Scopes []Scope `json:"scopes"`
+	}
+	c.request("scopes", ScopesArguments{FrameID: 2}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "Locals" || scopes.Scopes[1].Name != "Globals" {
+		t.Fatalf("unexpected scopes %+v", scopes.Scopes)
+	}
+
+	values := func(reference int) map[string]string {
+		var variables struct {
+			Variables []Variable `json:"variables"`
+		}
+		c.request("variables", VariablesArguments{VariablesReference: reference}, &variables)
+		found := make(map[string]string)
+		for _, v := range variables.Variables {
+			found[v.Name] = v.Type + " " + v.Value
+		}
+		return found
+	}
+	locals := values(scopes.Scopes[0].VariablesReference)
+	if len(locals) != 3 || locals["a"] != "int 1" || locals["b"] != "int 2" || locals["sum"] != "int 3" {
+		t.Errorf("locals %v", locals)
+	}
+	if globals := values(scopes.Scopes[1].VariablesReference); globals["add"] != "fun fun add" || globals["println"] != "" {
+		t.Errorf("globals %v", globals)
+	}
+
+	var result struct {
+		Result string `json:"result"`
+		Type   string `json:"type"`
+	}
+	c.request("evaluate", EvaluateArguments{Expression: "sum * 2", FrameID: 2}, &result)
+	if result.Result != "6" || result.Type != "int" {
+		t.Errorf("evaluate = %+v", result)
+	}
+
+	c.request("continue", map[string]any{"threadId": threadID}, nil)
+	c.exited()
+	c.disconnect()
+}
+
+/*
+stdin отладчика занят протоколом: input() должен завершить программу
+ошибкой, а не ждать строку из потока протокола.
+*/
+func TestInputUnavailable(t *testing.T) {
+	c, _ := launch(t, "print(\"before \")\nvar name = input(\"name? \")\nprintln(name)\n", false)
+	code, output := c.exited()
+	if code != 1 || !strings.HasPrefix(output, "stdout: before stderr: Runtime Error at 2:") ||
+		!strings.Contains(output, "input() is not available") {
+		t.Errorf("exited with %d and output %q", code, output)
+	}
+	c.disconnect()
+}
+
+func TestTerminate(t *testing.T) {
+	c, _ := launch(t, testProgram, true)
+	c.stopped()
+	c.request("terminate", nil, nil)
+	if code, output := c.exited(); code != 0 || output != "" {
+		t.Errorf("exited with %d and output %q", code, output)
+	}
+	c.disconnect()
+}
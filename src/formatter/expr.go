@@ -0,0 +1,365 @@
+package formatter
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+Выражение после prefix. Если строка не помещается, разбивается самое
+внешнее: правая часть присваивания, цепочка |> по этапу на строку или
+аргументы вызова по одному на строку.
+*/
+func (f *formatter) layout(prefix string, expr ast.Expr, min int) string {
+	single := prefix + f.expr(expr, min)
+	if f.fits(single) {
+		return single
+	}
+
+	switch e := expr.(type) {
+	case ast.AssignExpr:
+		if min <= parser.PrecAssignment {
+			return f.layout(prefix+f.left(e.Assigne, parser.PrecPipeline)+" "+e.Op.Value+" ", e.Expr, 0)
+		}
+	case ast.CallExpr:
+		if e.Pipe != nil {
+			if min > parser.PrecPipeline {
+				break
+			}
+			stages := make([]string, 0)
+			var value ast.Expr = e
+			for call, ok := value.(ast.CallExpr); ok && call.Pipe != nil; call, ok = value.(ast.CallExpr) {
+				stages = append(stages, f.stage(call, len(stages) > 0))
+				value = call.Args[call.Pipe.Arg]
+			}
+
+			var sb strings.Builder
+			sb.WriteString(prefix + f.left(value, parser.PrecPipeline))
+			for i := len(stages) - 1; i >= 0; i-- {
+				sb.WriteString(lineBreak + indentUnit + "|> " + stages[i])
+			}
+			return sb.String()
+		}
+		if len(e.Args) == 0 {
+			break
+		}
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = f.expr(arg, parser.PrecPipeline)
+		}
+		return prefix + f.left(e.Caller, parser.PrecCall) + f.openCall(e) + broken(args) + ")"
+	}
+	return single
+}
+
+/*
+Элементы списка по одному на строку с запятой после каждого.
+*/
+func broken(items []string) string {
+	var sb strings.Builder
+	for _, item := range items {
+		sb.WriteString(lineBreak + indentUnit + indentBreaks(item) + ",")
+	}
+	sb.WriteString(lineBreak)
+	return sb.String()
+}
+
+/*
+Сдвигает вложенные разбитые строки на уровень глубже.
+*/
+func indentBreaks(s string) string {
+	return strings.ReplaceAll(s, lineBreak, lineBreak+indentUnit)
+}
+
+/*
+Выражение в одну строку, в скобках, если его приоритет ниже min.
+*/
+func (f *formatter) expr(expr ast.Expr, min int) string {
+	code, prec := f.render(expr)
+	if prec < min {
+		return "(" + code + ")"
+	}
+	return code
+}
+
+/*
+Операнд, за которым в строке идёт что-то ещё. Выражение, которое
+заканчивается условным оператором, забрало бы продолжение себе, поэтому
+оно берётся в скобки.
+*/
+func (f *formatter) left(expr ast.Expr, min int) string {
+	if openEnded(expr) {
+		code, _ := f.render(expr)
+		return "(" + code + ")"
+	}
+	return f.expr(expr, min)
+}
+
+func openEnded(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case ast.ConditionalExpr:
+		return true
+	case ast.CallExpr:
+		return e.Pipe != nil && e.Pipe.Bare && openEnded(e.Caller)
+	}
+	return false
+}
+
+func (f *formatter) render(expr ast.Expr) (string, int) {
+	switch e := expr.(type) {
+	case ast.Identifier:
+		return e.Name, parser.PrecPrimary
+	case ast.IntLiteral, ast.FloatLiteral, ast.StringLiteral, ast.BoolLiteral, ast.NullLiteral, ast.UndefinedLiteral:
+		// Литерал печатается как записан: 0xff, r"..." и """...""" не
+		// меняются
+		return f.text(e.Pos()), parser.PrecPrimary
+	case ast.TemplateExpr:
+		var sb strings.Builder
+		sb.WriteString("`")
+		for _, part := range e.Parts {
+			if f.tokenKindAt(part.Pos().StartPos) == lexer.TEMPLATE_TEXT {
+				sb.WriteString(f.text(part.Pos()))
+				continue
+			}
+			sb.WriteString("${" + f.expr(part, 0) + "}")
+		}
+		sb.WriteString("`")
+		return sb.String(), parser.PrecPrimary
+	case ast.UnaryExpr:
+		if e.Op.Position.StartPos > e.Expr.Pos().StartPos {
+			return f.left(e.Expr, parser.PrecUnary+1) + e.Op.Value, parser.PrecUnary
+		}
+		return e.Op.Value + f.expr(e.Expr, parser.PrecUnary+1), parser.PrecUnary
+	case ast.BinaryExpr:
+		prec := parser.Precedence(e.Op.Kind)
+		left := f.left(e.Left, prec)
+		right := f.expr(e.Right, prec+1)
+		// a ?? b ? c : d читается неоднозначно, хотя скобки не нужны
+		if _, ok := e.Right.(ast.ConditionalExpr); ok {
+			right = f.left(e.Right, prec)
+		}
+		// ** правоассоциативен, а справа от него допустим унарный минус
+		if e.Op.Kind == lexer.STAR_STAR {
+			left = f.left(e.Left, prec+1)
+			right = f.expr(e.Right, prec)
+			if unary, ok := e.Right.(ast.UnaryExpr); ok && unary.Op.Position.StartPos < unary.Expr.Pos().StartPos {
+				right, _ = f.render(e.Right)
+			}
+		}
+		return left + " " + e.Op.Value + " " + right, prec
+	case ast.AssignExpr:
+		return f.left(e.Assigne, parser.PrecPipeline) + " " + e.Op.Value + " " + f.expr(e.Expr, 0), parser.PrecAssignment
+	case ast.CallExpr:
+		if e.Pipe != nil {
+			return f.left(e.Args[e.Pipe.Arg], parser.PrecPipeline) + " |> " + f.stage(e, false), parser.PrecPipeline
+		}
+		return f.left(e.Caller, parser.PrecCall) + f.openCall(e) + f.args(e.Args) + ")", parser.PrecCall
+	case ast.ConditionalExpr:
+		return f.left(e.Condition, parser.PrecLogical) + " ? " + f.expr(e.Consequent, 0) + " : " + f.expr(e.Alternate, 0), parser.PrecLogical
+	}
+	return "", parser.PrecPrimary
+}
+
+func (f *formatter) openCall(call ast.CallExpr) string {
+	if call.Optional {
+		return "?.("
+	}
+	return "("
+}
+
+func (f *formatter) args(args []ast.Expr) string {
+	printed := make([]string, len(args))
+	for i, arg := range args {
+		printed[i] = f.expr(arg, parser.PrecPipeline)
+	}
+	return strings.Join(printed, ", ")
+}
+
+/*
+Этап конвейера без значения слева: f, f(a) или f(a, _). more - за этапом
+идут следующие.
+*/
+func (f *formatter) stage(call ast.CallExpr, more bool) string {
+	if call.Pipe.Bare && more {
+		return f.left(call.Caller, parser.PrecPipeline+1)
+	}
+	if call.Pipe.Bare {
+		return f.expr(call.Caller, parser.PrecPipeline+1)
+	}
+
+	args := make([]ast.Expr, 0, len(call.Args))
+	for i, arg := range call.Args {
+		switch {
+		case i != call.Pipe.Arg:
+			args = append(args, arg)
+		case call.Pipe.Placeholder:
+			args = append(args, ast.Identifier{Name: "_"})
+		}
+	}
+	return f.left(call.Caller, parser.PrecCall) + f.openCall(call) + f.args(args) + ")"
+}
+
+func (f *formatter) typ(t ast.Type) string {
+	switch t := t.(type) {
+	case ast.IntKeyword:
+		return "int"
+	case ast.FloatKeyword:
+		return "float"
+	case ast.StringKeyword:
+		return "string"
+	case ast.BoolKeyword:
+		return "bool"
+	case ast.NullKeyword:
+		return "null"
+	case ast.UndefinedKeyword:
+		return "undefined"
+	case ast.ObjectKeyword:
+		return "object"
+	case ast.ArrayKeyword:
+		return "array"
+	case ast.AnyKeyword:
+		return "any"
+	case ast.VoidKeyword:
+		return "void"
+	case ast.FunKeyword:
+		return "fun"
+	case ast.TypeAlias:
+		return t.Name
+	case ast.StringLiteralType:
+		return strconv.Quote(t.Type)
+	case ast.IntLiteralType:
+		return strconv.FormatInt(t.Type, 10)
+	case ast.FloatLiteralType:
+		return strconv.FormatFloat(t.Type, 'g', -1, 64)
+	case ast.BoolLiteralType:
+		return strconv.FormatBool(t.Type)
+	case ast.ArrayType:
+		return "[]" + f.typ(t.ElementType)
+	case ast.UnionType:
+		return f.types(t.Types, " | ")
+	case ast.IntersectionType:
+		return f.types(t.Types, " & ")
+	case ast.FunType:
+		return "fun " + f.params(t.Params) + " => " + f.typ(t.ReturnType)
+	case ast.Struct:
+		return f.structType(t)
+	}
+	return ""
+}
+
+func (f *formatter) types(types []ast.Type, separator string) string {
+	printed := make([]string, len(types))
+	for i, t := range types {
+		printed[i] = f.typ(t)
+	}
+	return strings.Join(printed, separator)
+}
+
+/*
+struct {} или поля и методы по одному на строку. Комментарии внутри
+фигурных скобок остаются при своих членах: стоящие перед членом идут
+строками перед ним, стоящие на его строке - после запятой.
+*/
+func (f *formatter) structType(t ast.Struct) string {
+	members := make([]string, len(t.Members))
+	positions := make([]lexer.Position, len(t.Members))
+	for i, member := range t.Members {
+		switch m := member.(type) {
+		case ast.PropertySignature:
+			members[i] = m.Name + ": " + f.typ(m.Type)
+			positions[i] = m.Position
+		case ast.MethodSignature:
+			members[i] = m.Name + f.params(m.Params)
+			if _, ok := m.Type.(ast.VoidKeyword); !ok {
+				members[i] += ": " + f.typ(m.Type)
+			}
+			positions[i] = m.Position
+		}
+	}
+
+	// Комментарии вложенных структур уже напечатаны и убраны
+	leading := make([][]string, len(members)+1)
+	trailing := make([]string, len(members))
+	start, end := f.next, f.next
+	for start < len(f.comments) && f.comments[start].Position.StartPos < t.Position.StartPos {
+		start++
+	}
+	for end = start; end < len(f.comments) && f.comments[end].Position.StartPos < t.Position.EndPos; end++ {
+		comment := f.comments[end]
+		if i := f.memberOnLine(positions, comment); i >= 0 {
+			trailing[i] += " " + comment.Text
+			continue
+		}
+		// Перед ближайшим следующим членом или перед }
+		next := len(members)
+		for i, pos := range positions {
+			if pos.StartPos > comment.Position.StartPos && (next == len(members) || pos.StartPos < positions[next].StartPos) {
+				next = i
+			}
+		}
+		leading[next] = append(leading[next], comment.Text)
+	}
+	f.comments = slices.Delete(f.comments, start, end)
+
+	if len(members) == 0 && len(leading[0]) == 0 {
+		return "struct {}"
+	}
+	var sb strings.Builder
+	sb.WriteString("struct {")
+	for i, member := range members {
+		for _, comment := range leading[i] {
+			sb.WriteString(lineBreak + indentUnit + comment)
+		}
+		sb.WriteString(lineBreak + indentUnit + indentBreaks(member) + "," + trailing[i])
+	}
+	for _, comment := range leading[len(members)] {
+		sb.WriteString(lineBreak + indentUnit + comment)
+	}
+	sb.WriteString(lineBreak + "}")
+	return sb.String()
+}
+
+/*
+Член структуры, на последней строке которого стоит комментарий после
+кода, или -1.
+*/
+func (f *formatter) memberOnLine(positions []lexer.Position, comment ast.Comment) int {
+	if !comment.Trailing {
+		return -1
+	}
+	for i, pos := range positions {
+		if pos.StartPos < comment.Position.StartPos && f.lineOf(pos.EndPos-1) == comment.Position.Line {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *formatter) params(params []ast.Param) string {
+	printed := make([]string, len(params))
+	for i, param := range params {
+		printed[i] = param.Name + ": " + f.typ(param.Type)
+	}
+	if strings.Contains(strings.Join(printed, ""), lineBreak) {
+		return "(" + broken(printed) + ")"
+	}
+	return "(" + strings.Join(printed, ", ") + ")"
+}
+
+/*
+Параметры по одному на строку, если объявление не помещается.
+*/
+func (f *formatter) paramsBroken(params []ast.Param) string {
+	if len(params) == 0 {
+		return "()"
+	}
+	printed := make([]string, len(params))
+	for i, param := range params {
+		printed[i] = param.Name + ": " + f.typ(param.Type)
+	}
+	return "(" + broken(printed) + ")"
+}
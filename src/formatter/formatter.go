@@ -0,0 +1,323 @@
+package formatter
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	indentUnit = "  "
+	maxWidth   = 100
+	// Перевод строки, который вставил форматтер. Переводы строк внутри
+	// литералов остаются как есть и не получают отступ
+	lineBreak = "\x00"
+)
+
+/*
+Форматирует исходный код: одна инструкция на строке без ';', отступ
+в два пробела, не больше одной пустой строки подряд. Комментарии
+сохраняются. Код с ошибками лексера или парсера не форматируется.
+
+Результат не меняется при повторном форматировании.
+*/
+func Format(source string) (string, []string) {
+	tokens, errs := lexer.TokenizeTrivia(source)
+	if len(errs) > 0 {
+		return "", errs
+	}
+	program, errs := parser.Parse(tokens, source)
+	if len(errs) > 0 {
+		return "", errs
+	}
+
+	f := &formatter{
+		source:   source,
+		tokens:   tokens,
+		comments: program.Comments,
+		starts:   []int{0},
+	}
+	for i := 0; i < len(source); i++ {
+		if source[i] == '\n' {
+			f.starts = append(f.starts, i+1)
+		}
+	}
+
+	f.stmts(program.Body, len(source)+1)
+	if len(f.lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(f.lines, "\n") + "\n", nil
+}
+
+/*
+Тип в том виде, в котором его печатает форматтер. Члены структуры идут
+по одному на строку.
+*/
+func Type(t ast.Type) string {
+	f := &formatter{}
+	return strings.ReplaceAll(f.typ(t), lineBreak, "\n")
+}
+
+type formatter struct {
+	source   string
+	tokens   []lexer.Token
+	comments []ast.Comment
+	// Первый ещё не напечатанный комментарий
+	next int
+	// Смещения начал строк исходного кода
+	starts []int
+	lines  []string
+	indent int
+	// Последняя строка исходного кода, занятая уже напечатанной
+	// инструкцией или комментарием текущего блока. 0 в начале блока
+	last int
+}
+
+/*
+Инструкции блока вместе с комментариями до boundary. Пустые строки
+между ними сохраняются, но не больше одной подряд.
+*/
+func (f *formatter) stmts(body []ast.Stmt, boundary int) {
+	saved := f.last
+	f.last = 0
+
+	for _, stmt := range body {
+		pos := stmt.Pos()
+		f.commentsBefore(pos.StartPos)
+		f.gap(pos.Line)
+		f.stmt(stmt)
+		f.last = f.lineOf(pos.EndPos - 1)
+		f.trailing(pos.EndPos, boundary)
+	}
+	f.commentsBefore(boundary)
+
+	f.last = saved
+}
+
+/*
+Комментарии перед pos, каждый на своей строке.
+*/
+func (f *formatter) commentsBefore(pos int) {
+	for f.next < len(f.comments) && f.comments[f.next].Position.StartPos < pos {
+		comment := f.comments[f.next]
+		f.gap(comment.Position.Line)
+		f.emit(comment.Text)
+		f.last = comment.EndLine()
+		f.next++
+	}
+}
+
+/*
+Комментарии после инструкции, заканчивающейся в end: стоящие на её
+последней строке и те, что оказались внутри неё. Первый дописывается
+в конец строки, остальные идут отдельными строками.
+*/
+func (f *formatter) trailing(end int, boundary int) {
+	line := f.last
+	first := true
+	for f.next < len(f.comments) {
+		comment := f.comments[f.next]
+		inside := comment.Position.StartPos < end
+		sameLine := comment.Position.StartPos < boundary && comment.Trailing && comment.Position.Line == line
+		if !inside && !sameLine {
+			break
+		}
+
+		if first {
+			f.lines[len(f.lines)-1] += " " + comment.Text
+			first = false
+		} else {
+			f.emit(comment.Text)
+		}
+		f.last = max(f.last, comment.EndLine())
+		f.next++
+	}
+}
+
+/*
+Пустая строка перед элементом, начинающимся на line, если в исходном
+коде перед ним была пустая строка.
+*/
+func (f *formatter) gap(line int) {
+	if f.last > 0 && line > f.last+1 {
+		f.lines = append(f.lines, "")
+	}
+}
+
+/*
+Печатает код с текущим отступом. Строки, на которые его разбил
+форматтер, получают отступ, строки внутри литералов - нет.
+*/
+func (f *formatter) emit(code string) {
+	prefix := strings.Repeat(indentUnit, f.indent)
+	for _, line := range strings.Split(code, lineBreak) {
+		f.lines = append(f.lines, prefix+line)
+	}
+}
+
+func (f *formatter) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case ast.ExprStmt:
+		f.emit(f.layout("", s.Expr, 0))
+	case ast.VarDeclStmt:
+		head := f.keyword(s.Position) + " " + s.Name
+		if f.implicitUndefined(s.Value) {
+			f.emit(head)
+			return
+		}
+		f.emit(f.layout(head+" = ", s.Value, parser.PrecPipeline))
+	case ast.TypeAliasDecl:
+		f.emit("type " + s.Name + " = " + f.typ(s.Type))
+	case ast.FunDeclStmt:
+		f.funDecl(s)
+	case ast.IfStmt:
+		f.ifStmt(s, false)
+	case ast.BlockStmt:
+		f.block("", s.Body, s.Position.EndPos-1, false)
+	}
+}
+
+/*
+let, var или const, как в исходном коде.
+*/
+func (f *formatter) keyword(pos lexer.Position) string {
+	for _, keyword := range []string{"let", "var", "const"} {
+		if strings.HasPrefix(f.source[pos.StartPos:], keyword) {
+			return keyword
+		}
+	}
+	return "let"
+}
+
+/*
+Парсер подставляет undefined в объявление без значения, указывая на
+имя переменной.
+*/
+func (f *formatter) implicitUndefined(value ast.Expr) bool {
+	if _, ok := value.(ast.UndefinedLiteral); !ok {
+		return false
+	}
+	return f.text(value.Pos()) != "undefined"
+}
+
+func (f *formatter) funDecl(s ast.FunDeclStmt) {
+	head := "fun " + s.Name
+	returns := ""
+	if _, ok := s.ReturnType.(ast.VoidKeyword); !ok {
+		returns = ": " + f.typ(s.ReturnType)
+	}
+
+	params := f.params(s.Params)
+	if !f.fits(head + params + returns + " {") {
+		params = f.paramsBroken(s.Params)
+	}
+
+	// Тело без фигурных скобок тоже печатается блоком
+	boundary := s.Position.EndPos
+	if f.source[boundary-1] == '}' {
+		boundary--
+	}
+	f.block(head+params+returns+" ", s.Body, boundary, false)
+}
+
+/*
+if, а в else - блок или следующий if. join продолжает строку с '}'
+предыдущего блока.
+*/
+func (f *formatter) ifStmt(s ast.IfStmt, join bool) {
+	head := "if " + f.expr(s.Condition, parser.PrecPipeline) + " "
+	if s.Alternate == nil {
+		f.block(head, s.Consequent, s.Position.EndPos-1, join)
+		return
+	}
+
+	consequentEnd := s.Condition.Pos().EndPos
+	if len(s.Consequent) > 0 {
+		consequentEnd = s.Consequent[len(s.Consequent)-1].Pos().EndPos
+	}
+	elseIndex := f.tokenAfter(lexer.ELSE, consequentEnd)
+	f.block(head, s.Consequent, f.tokens[elseIndex].Position.StartPos, join)
+
+	f.lines[len(f.lines)-1] += " else"
+	// else { if ... } остаётся блоком
+	if f.tokens[elseIndex+1].Kind == lexer.IF {
+		f.ifStmt(s.Alternate[0].(ast.IfStmt), true)
+		return
+	}
+	f.block("", s.Alternate, s.Position.EndPos-1, true)
+}
+
+/*
+head { тело }. Пустое тело без комментариев печатается как {}.
+*/
+func (f *formatter) block(head string, body []ast.Stmt, boundary int, join bool) {
+	empty := len(body) == 0 && (f.next >= len(f.comments) || f.comments[f.next].Position.StartPos >= boundary)
+	open := head + "{"
+	if empty {
+		open += "}"
+	}
+
+	if join {
+		f.lines[len(f.lines)-1] += " " + open
+	} else {
+		f.emit(open)
+	}
+	if empty {
+		return
+	}
+
+	f.indent++
+	f.stmts(body, boundary)
+	f.indent--
+	f.emit("}")
+}
+
+/*
+Номер первого токена вида kind, начинающегося не раньше pos.
+*/
+func (f *formatter) tokenAfter(kind lexer.TokenKind, pos int) int {
+	i := sort.Search(len(f.tokens), func(i int) bool {
+		return f.tokens[i].Position.StartPos >= pos
+	})
+	for i < len(f.tokens) && f.tokens[i].Kind != kind {
+		i++
+	}
+	return i
+}
+
+/*
+Вид токена, который начинается в pos.
+*/
+func (f *formatter) tokenKindAt(pos int) lexer.TokenKind {
+	i := sort.Search(len(f.tokens), func(i int) bool {
+		return f.tokens[i].Position.StartPos >= pos
+	})
+	if i < len(f.tokens) {
+		return f.tokens[i].Kind
+	}
+	return lexer.EOF
+}
+
+func (f *formatter) lineOf(offset int) int {
+	return sort.Search(len(f.starts), func(i int) bool {
+		return f.starts[i] > offset
+	})
+}
+
+func (f *formatter) text(pos lexer.Position) string {
+	return f.source[pos.StartPos:pos.EndPos]
+}
+
+/*
+Помещается ли строка в maxWidth с текущим отступом.
+*/
+func (f *formatter) fits(line string) bool {
+	if strings.Contains(line, lineBreak) {
+		return false
+	}
+	return len(indentUnit)*f.indent+utf8.RuneCountInString(line) <= maxWidth
+}
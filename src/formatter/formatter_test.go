@@ -0,0 +1,74 @@
+package formatter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+Повторное форматирование ничего не меняет: format(format(x)) == format(x).
+*/
+func TestIdempotentOnExamples(t *testing.T) {
+	files, err := filepath.Glob("../../examples/*.fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no examples found")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertIdempotent(t, string(source))
+		})
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	sources := []string{
+		"var y = 1\n  // c1\n  |> inc\n\n\n// c2\ny\n  |> f(2, _) // t\n  |> g\n",
+		"fun f(a: int): int {\n  if a > 0 { a } else if a < 0 { -a } else { 0 } // sign\n}\n",
+		"type Point = struct { x: int, y: int }\nvar s = `x = ${1 + 2}`;\n",
+		"var i = 0; i++; -(1 + 2) * 3\n",
+		"type P = struct {\n  // field\n  x: int, // x\n  m(): struct { y: int /* y */ } // m\n  // end\n}\n",
+	}
+	for _, source := range sources {
+		assertIdempotent(t, source)
+	}
+}
+
+/*
+Комментарии внутри структуры остаются при своих членах, а не уходят
+за закрывающую скобку.
+*/
+func TestStructComments(t *testing.T) {
+	source := "type P = struct {\n  // field comment\n  x: int, // trailing x\n  y: int\n  // last\n}\nvar p = 1 // p\n"
+	want := "type P = struct {\n  // field comment\n  x: int, // trailing x\n  y: int,\n  // last\n}\nvar p = 1 // p\n"
+	got, errs := Format(source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func assertIdempotent(t *testing.T, source string) {
+	t.Helper()
+	once, errs := Format(source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	twice, errs := Format(once)
+	if len(errs) > 0 {
+		t.Fatalf("formatted code does not parse:\n%s\n%s", once, strings.Join(errs, "\n"))
+	}
+	if once != twice {
+		t.Errorf("second format changed the code:\n%s\n---\n%s", once, twice)
+	}
+}
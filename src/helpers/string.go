@@ -1,15 +1,27 @@
 package helpers
 
-import "strconv"
+import (
+	"strconv"
+	"unicode/utf8"
+)
 
 /*
-Возвращает укороченную до заданной длины искомую строку
+Возвращает укороченную до заданной длины искомую строку. Длина
+считается в символах, многобайтовые символы UTF-8 не разрезаются.
 */
 func Ellipsis(s string, maxLen int) string {
-	if len(s) <= maxLen {
+	end := 0
+	for i := 0; i < maxLen; i++ {
+		if end >= len(s) {
+			return s
+		}
+		_, size := utf8.DecodeRuneInString(s[end:])
+		end += size
+	}
+	if end >= len(s) {
 		return s
 	}
-	return s[:maxLen] + "..."
+	return s[:end] + "..."
 }
 
 /*
@@ -66,3 +78,14 @@ func RemoveEscapeSigns(escapeSeq string) string {
 
 	return escapeSeq
 }
+
+/*
+Фрагмент source[start:end] или пустая строка, если границы выходят
+за пределы source, например когда исходный код читался потоком.
+*/
+func Snippet(source string, start, end int) string {
+	if start < 0 || end > len(source) || start > end {
+		return ""
+	}
+	return source[start:end]
+}
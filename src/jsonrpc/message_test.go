@@ -0,0 +1,62 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	for _, value := range []any{map[string]int{"id": 1}, "привет", nil} {
+		if err := WriteMessage(&buf, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.HasPrefix(buf.String(), "Content-Length: 8\r\n\r\n{\"id\":1}") {
+		t.Errorf("unexpected framing %q", buf.String())
+	}
+
+	r := bufio.NewReader(&buf)
+	for _, want := range []string{`{"id":1}`, `"привет"`, `null`} {
+		body, err := ReadMessage(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != want {
+			t.Errorf("body %q, want %q", body, want)
+		}
+	}
+	if _, err := ReadMessage(r); !errors.Is(err, io.EOF) {
+		t.Errorf("after the last message: %v, want EOF", err)
+	}
+}
+
+func TestReadHeaders(t *testing.T) {
+	input := "content-length: 2\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n{}"
+	body, err := ReadMessage(bufio.NewReader(strings.NewReader(input)))
+	if err != nil || string(body) != "{}" {
+		t.Errorf("body %q, error %v", body, err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Content-Type: text\r\n\r\n{}", "missing Content-Length header"},
+		{"Content-Length 2\r\n\r\n{}", "malformed header"},
+		{"Content-Length: two\r\n\r\n{}", "invalid Content-Length"},
+		{"Content-Length: 10\r\n\r\n{}", "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		_, err := ReadMessage(bufio.NewReader(strings.NewReader(tt.input)))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package lexer
+
+/*
+Ошибка лексера, парсера или resolver с позицией во входе. Text -
+сообщение в том виде, в котором его печатают команды, Message - то же
+без позиции и фрагмента кода. У ошибок чтения входа позиции нет,
+Position.Line равен 0.
+*/
+type Error struct {
+	Message  string
+	Position Position
+	Text     string
+}
+
+/*
+Тексты ошибок для тех, кому нужны только сообщения.
+*/
+func Messages(errs []Error) []string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Text
+	}
+	return messages
+}
@@ -0,0 +1,95 @@
+package lexer
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+/*
+Токены в текстовом виде: по одному на строке с позицией, видом,
+значением и признаком перевода строки перед ним, затем ошибки.
+*/
+func dumpTokens(tokens []Token, errs []string) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		newline := ""
+		if token.NewlineBefore {
+			newline = " nl"
+		}
+		fmt.Fprintf(&sb, "%d:%d %d-%d %s %q%s\n", token.Position.Line, token.Position.Column,
+			token.Position.StartPos, token.Position.EndPos, TokenKindString(token.Kind), token.Value, newline)
+		for _, trivia := range token.Leading {
+			fmt.Fprintf(&sb, "\t%s %q\n", TriviaKindString(trivia.Kind), trivia.Text)
+		}
+	}
+	for _, err := range errs {
+		fmt.Fprintf(&sb, "error: %s\n", err)
+	}
+	return sb.String()
+}
+
+/*
+Сравнивает токены каждого файла testdata/*.fs и examples/*.fs с
+testdata/<имя>.golden. Оба лексера, сканер и собранный с тегом
+regexlexer, проверяются по одним и тем же файлам, поэтому их потоки
+токенов совпадают. С флагом -update файлы пересоздаются.
+*/
+func checkGolden(t *testing.T) {
+	for _, input := range testInputs(t) {
+		name := strings.TrimSuffix(filepath.Base(input), ".fs")
+		t.Run(name, func(t *testing.T) {
+			source, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := dumpTokens(Tokenize(string(source))) + "--- trivia\n" + dumpTokens(TokenizeTrivia(string(source)))
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("tokens differ from %s:\n%s", golden, firstDifference(got, string(want)))
+			}
+		})
+	}
+}
+
+/*
+Файлы testdata/*.fs и examples/*.fs.
+*/
+func testInputs(t *testing.T) []string {
+	t.Helper()
+	inputs, err := filepath.Glob("testdata/*.fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	examples, err := filepath.Glob("../../examples/*.fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(inputs, examples...)
+}
+
+func firstDifference(got string, want string) string {
+	gotLines, wantLines := strings.Split(got, "\n"), strings.Split(want, "\n")
+	for i := range min(len(gotLines), len(wantLines)) {
+		if gotLines[i] != wantLines[i] {
+			return fmt.Sprintf("line %d:\n  got:  %s\n  want: %s", i+1, gotLines[i], wantLines[i])
+		}
+	}
+	return fmt.Sprintf("got %d lines, want %d", len(gotLines), len(wantLines))
+}
@@ -4,54 +4,57 @@ import (
 	"finescript/src/helpers"
 	"fmt"
 	"regexp"
+	"strings"
+	"unicode/utf8"
 )
 
 var escapeRegex = regexp.MustCompile(`\\(?:[nrt\\'"]|x[0-9a-fA-F]{2}|u[0-9a-fA-F]{4}|U[0-9a-fA-F]{8})`)
 
-type regexHandler func(lex *lexer, regex *regexp.Regexp)
-type regexPattern struct {
-	regex   *regexp.Regexp
-	handler regexHandler
-}
+type scanResult int
+
+const (
+	scanned scanResult = iota
+	unrecognized
+	// Токен упирается в конец окна, нужно дочитать вход
+	incomplete
+)
 
 type lexer struct {
-	patterns []regexPattern
-	Tokens   []Token
-	source   string
-	pos      int
-	errors   []string
+	Tokens []Token
+	source string
+	pos    int
+	errors []Error
+	// Позиция source[0] во всём входе: потоковый лексер хранит только окно
+	offset int
+	// Весь вход уже в source, дальше данных не будет
+	final bool
+	// Строка и колонка в символах для lex.pos, с единицы
+	line   int
+	column int
+	// Строка, на которой закончился последний токен
+	tokenLine int
+	// Режим без потерь: токены получают Raw и Leading
+	trivia  bool
+	pending []Trivia
+	// Открытые шаблонные строки: глубина фигурных скобок во вставке
+	// ${...} или templateText, пока разбирается текст
+	templates []int
 }
 
-func Tokenize(source string) ([]Token, []string) {
-	lex := createLexer(source)
+const templateText = -1
 
-	for !lex.at_eof() {
-		matched := false
-		remainder := lex.remainder()
-
-		for _, pattern := range lex.patterns {
-			loc := pattern.regex.FindStringIndex(remainder)
-			if loc != nil && loc[0] == 0 {
-				pattern.handler(lex, pattern.regex)
-				matched = true
-				break
-			}
-		}
-
-		if !matched {
-			lex.errors = append(lex.errors, fmt.Sprintf("unrecognized token near \"%v\" at %d", helpers.Ellipsis(remainder, 20), lex.pos))
-			lex.advanceN(len(remainder))
-		}
-	}
-
-	lex.push(Token{EOF, "eof", Position{
-		StartPos: lex.pos,
-		EndPos:   lex.pos,
-	}})
-	return lex.Tokens, lex.errors
+func (lex *lexer) inTemplateText() bool {
+	return len(lex.templates) > 0 && lex.templates[len(lex.templates)-1] == templateText
 }
 
 func (lex *lexer) advanceN(n int) int {
+	consumed := lex.source[lex.pos : lex.pos+n]
+	if newline := strings.LastIndexByte(consumed, '\n'); newline >= 0 {
+		lex.line += strings.Count(consumed, "\n")
+		lex.column = 1 + utf8.RuneCountInString(consumed[newline+1:])
+	} else {
+		lex.column += utf8.RuneCountInString(consumed)
+	}
 	lex.pos += n
 	return lex.pos
 }
@@ -69,6 +72,13 @@ func (lex *lexer) remainder() string {
 }
 
 func (lex *lexer) push(token Token) {
+	token.NewlineBefore = token.Position.Line > lex.tokenLine
+	lex.tokenLine = lex.line
+	if lex.trivia {
+		token.Raw = lex.source[token.Position.StartPos-lex.offset : token.Position.EndPos-lex.offset]
+		token.Leading = lex.pending
+		lex.pending = nil
+	}
 	lex.Tokens = append(lex.Tokens, token)
 }
 
@@ -76,131 +86,195 @@ func (lex *lexer) at_eof() bool {
 	return lex.pos >= len(lex.source)
 }
 
+/*
+Записывает ошибку о нераспознанном символе и пропускает весь остаток.
+*/
+func (lex *lexer) skipUnrecognized() {
+	remainder := lex.remainder()
+	_, size := utf8.DecodeRuneInString(remainder)
+	lex.error(fmt.Sprintf("unrecognized token near \"%v\"", helpers.Ellipsis(remainder, 20)), size)
+	lex.skip(len(remainder), SKIPPED)
+}
+
+func (lex *lexer) pushEOF() {
+	if len(lex.templates) > 0 {
+		lex.error("unterminated template string", 0)
+		lex.templates = nil
+	}
+	lex.push(Token{Kind: EOF, Value: "eof", Position: lex.consume(0)})
+}
+
+/*
+Разбирает весь source, уже загруженный в лексер.
+*/
+func (lex *lexer) tokenize() ([]Token, []Error) {
+	for !lex.at_eof() {
+		if lex.scanToken() == unrecognized {
+			lex.skipUnrecognized()
+		}
+	}
+
+	lex.pushEOF()
+	return lex.Tokens, lex.errors
+}
+
 func createLexer(source string) *lexer {
 	return &lexer{
-		pos:    0,
-		errors: make([]string, 0),
-		source: source,
-		Tokens: make([]Token, 0),
-		patterns: []regexPattern{
-			{regexp.MustCompile(`\/\/.*|\/\*[\s\S]*?\*\/`), skipHandler},
-			{regexp.MustCompile(`\s+`), skipHandler},
-			{regexp.MustCompile(`^\s*$`), skipHandler},
-			{regexp.MustCompile(`"(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*'`), stringHandler},
-			{regexp.MustCompile(`[0-9]+\.[0-9]+`), numberHandler(FLOAT)},
-			{regexp.MustCompile(`[0-9]+`), numberHandler(INT)},
-			{regexp.MustCompile(`[a-zA-Z_][a-zA-Z0-9_]*`), identifierHandler},
-			{regexp.MustCompile(`\[`), defaultHandler(OPEN_BRACKET, "[")},
-			{regexp.MustCompile(`\]`), defaultHandler(CLOSE_BRACKET, "]")},
-			{regexp.MustCompile(`\{`), defaultHandler(OPEN_CURLY, "{")},
-			{regexp.MustCompile(`\}`), defaultHandler(CLOSE_CURLY, "}")},
-			{regexp.MustCompile(`\(`), defaultHandler(OPEN_PAREN, "(")},
-			{regexp.MustCompile(`\)`), defaultHandler(CLOSE_PAREN, ")")},
-			{regexp.MustCompile(`==`), defaultHandler(EQUALS, "==")},
-			{regexp.MustCompile(`!=`), defaultHandler(NOT_EQUALS, "!=")},
-			{regexp.MustCompile(`=`), defaultHandler(ASSIGNMENT, "=")},
-			{regexp.MustCompile(`!`), defaultHandler(NOT, "!")},
-			{regexp.MustCompile(`<=`), defaultHandler(LESS_EQUALS, "<=")},
-			{regexp.MustCompile(`<`), defaultHandler(LESS, "<")},
-			{regexp.MustCompile(`>=`), defaultHandler(GREATER_EQUALS, ">=")},
-			{regexp.MustCompile(`>`), defaultHandler(GREATER, ">")},
-			{regexp.MustCompile(`\|\|`), defaultHandler(OR, "||")},
-			{regexp.MustCompile(`&&`), defaultHandler(AND, "&&")},
-			{regexp.MustCompile(`\.\.`), defaultHandler(DOT_DOT, "..")},
-			{regexp.MustCompile(`\.`), defaultHandler(DOT, ".")},
-			{regexp.MustCompile(`;`), defaultHandler(SEMI_COLON, ";")},
-			{regexp.MustCompile(`:`), defaultHandler(COLON, ":")},
-			{regexp.MustCompile(`\?`), defaultHandler(QUESTION, "?")},
-			{regexp.MustCompile(`,`), defaultHandler(COMMA, ",")},
-			{regexp.MustCompile(`\+\+`), defaultHandler(PLUS_PLUS, "++")},
-			{regexp.MustCompile(`--`), defaultHandler(MINUS_MINUS, "--")},
-			{regexp.MustCompile(`\+=`), defaultHandler(PLUS_EQUALS, "+=")},
-			{regexp.MustCompile(`-=`), defaultHandler(MINUS_EQUALS, "-=")},
-			// {regexp.MustCompile(`/=`), defaultHandler(SLASH_EQUALS, "/=")},
-			// {regexp.MustCompile(`\*=`), defaultHandler(STAR_EQUALS, "*=")},
-			// {regexp.MustCompile(`%=`), defaultHandler(PERCENT_EQUALS, "%=")},
-			{regexp.MustCompile(`\+`), defaultHandler(PLUS, "+")},
-			{regexp.MustCompile(`-`), defaultHandler(MINUS, "-")},
-			{regexp.MustCompile(`/`), defaultHandler(SLASH, "/")},
-			{regexp.MustCompile(`\*`), defaultHandler(STAR, "*")},
-			{regexp.MustCompile(`%`), defaultHandler(PERCENT, "%")},
-		},
+		pos:       0,
+		errors:    make([]Error, 0),
+		source:    source,
+		Tokens:    make([]Token, 0),
+		final:     true,
+		line:      1,
+		column:    1,
+		tokenLine: 1,
 	}
 }
 
-func defaultHandler(kind TokenKind, value string) regexHandler {
-	return func(lex *lexer, _ *regexp.Regexp) {
-		lex.push(Token{
-			Kind:  kind,
-			Value: value,
-			Position: Position{
-				StartPos: lex.pos,
-				EndPos:   lex.advanceN(len(value)),
-			},
-		})
-	}
+/*
+Позиция во всём входе с учётом смещения окна.
+*/
+func (lex *lexer) absPos() int {
+	return lex.offset + lex.pos
 }
 
-func stringHandler(lex *lexer, regex *regexp.Regexp) {
-	startPos := lex.pos
-	match := regex.FindStringIndex(lex.remainder())
-	if match == nil {
-		lex.errors = append(lex.errors, fmt.Sprintf("unterminated string near \"%v\" at %d", lex.remainder(), lex.pos))
+/*
+Позиция токена, начинающегося в lex.pos, длиной length байт.
+Сдвигает лексер за токен.
+*/
+func (lex *lexer) consume(length int) Position {
+	pos := Position{
+		StartPos: lex.absPos(),
+		Line:     lex.line,
+		Column:   lex.column,
 	}
+	pos.EndPos = lex.offset + lex.advanceN(length)
+	return pos
+}
 
-	stringWithQuotes := lex.remainder()[match[0]:match[1]]
+/*
+Записывает ошибку о length байтах с текущей позиции, не сдвигая лексер.
+*/
+func (lex *lexer) error(message string, length int) {
+	pos := Position{
+		StartPos: lex.absPos(),
+		EndPos:   lex.absPos() + length,
+		Line:     lex.line,
+		Column:   lex.column,
+	}
+	lex.errors = append(lex.errors, Error{
+		Message:  message,
+		Position: pos,
+		Text:     message + " at " + pos.String(),
+	})
+}
 
+func (lex *lexer) pushString(stringWithQuotes string) {
 	stringLiteral := stringWithQuotes[1 : len(stringWithQuotes)-1]
-	stringLiteral = escapeRegex.ReplaceAllStringFunc(stringLiteral, helpers.RemoveEscapeSigns)
+	lex.pushStringValue(len(stringWithQuotes), unescape(stringLiteral))
+}
 
+/*
+Сырая строка r"..." или r'...': escape-последовательности не
+обрабатываются.
+*/
+func (lex *lexer) pushRawString(literal string) {
+	lex.pushStringValue(len(literal), literal[2:len(literal)-1])
+}
+
+/*
+Многострочная строка в тройных кавычках. Перевод строки сразу после
+открывающих кавычек и последняя строка из одних пробелов отбрасываются,
+общий отступ остальных строк убирается. В сырой форме r"""...""" escape-
+последовательности не обрабатываются.
+*/
+func (lex *lexer) pushTextBlock(literal string, raw bool) {
+	start := 3
+	if raw {
+		start = 4
+	}
+	value := dedent(literal[start : len(literal)-3])
+	if !raw {
+		value = unescape(value)
+	}
+	lex.pushStringValue(len(literal), value)
+}
+
+func (lex *lexer) pushStringValue(length int, value string) {
 	lex.push(Token{
-		Kind:  STRING,
-		Value: stringLiteral,
-		Position: Position{
-			StartPos: startPos,
-			EndPos:   lex.advanceN(len(stringWithQuotes)),
-		},
+		Kind:     STRING,
+		Value:    value,
+		Position: lex.consume(length),
 	})
 }
 
-func numberHandler(kind TokenKind) func(*lexer, *regexp.Regexp) {
-	return func(lex *lexer, regex *regexp.Regexp) {
-		startPos := lex.pos
-		match := regex.FindString(lex.remainder())
-		lex.push(Token{
-			Kind:  kind,
-			Value: match,
-			Position: Position{
-				StartPos: startPos,
-				EndPos:   lex.advanceN(len(match)),
-			},
-		})
+/*
+Записывает ошибку о незакрытой строке. Обычная строка пропускается до
+конца строки исходного кода, многострочная - до конца входа.
+*/
+func (lex *lexer) skipUnterminatedString(multiline bool) {
+	remainder := lex.remainder()
+	end := len(remainder)
+	if !multiline {
+		if newline := strings.IndexByte(remainder, '\n'); newline >= 0 {
+			end = newline
+		}
 	}
+	lex.error(fmt.Sprintf("unterminated string literal near \"%v\"", helpers.Ellipsis(remainder[:end], 20)), end)
+	lex.skip(end, SKIPPED)
 }
 
-func identifierHandler(lex *lexer, regex *regexp.Regexp) {
-	startPos := lex.pos
-	match := regex.FindString(lex.remainder())
+func unescape(s string) string {
+	return escapeRegex.ReplaceAllStringFunc(s, helpers.RemoveEscapeSigns)
+}
 
-	tokenKind := IDENTIFIER
-	if kind, found := keywords[match]; found {
-		tokenKind = kind
+func dedent(s string) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) > 1 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	indent := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lineIndent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			indent, first = lineIndent, false
+			continue
+		}
+		for !strings.HasPrefix(lineIndent, indent) {
+			indent = indent[:len(indent)-1]
+		}
 	}
 
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		} else {
+			lines[i] = line[len(indent):]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (lex *lexer) pushWord(kind TokenKind, value string) {
 	lex.push(Token{
-		Kind:  tokenKind,
-		Value: match,
-		Position: Position{
-			StartPos: startPos,
-			EndPos:   lex.advanceN(len(match)),
-		},
+		Kind:     kind,
+		Value:    value,
+		Position: lex.consume(len(value)),
 	})
 }
 
-func skipHandler(lex *lexer, regex *regexp.Regexp) {
-	match := regex.FindString(lex.remainder())
-	if match == "" {
-		return
+func (lex *lexer) pushIdentifier(value string) {
+	tokenKind := IDENTIFIER
+	if kind, found := keywords[value]; found {
+		tokenKind = kind
 	}
-	lex.advanceN(len(match))
+	lex.pushWord(tokenKind, value)
 }
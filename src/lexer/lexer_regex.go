@@ -0,0 +1,168 @@
+//go:build regexlexer
+
+/*
+Прежний лексер на регулярных выражениях. Собирается с тегом regexlexer
+и нужен для сравнения результатов с основным сканером.
+*/
+package lexer
+
+import "regexp"
+
+type regexHandler func(lex *lexer, regex *regexp.Regexp)
+type regexPattern struct {
+	regex   *regexp.Regexp
+	handler regexHandler
+}
+
+var patterns = []regexPattern{
+	{regexp.MustCompile(`\/\/.*|\/\*[\s\S]*?\*\/`), skipHandler},
+	{regexp.MustCompile(`\s+`), skipHandler},
+	{regexp.MustCompile(`^\s*$`), skipHandler},
+	{regexp.MustCompile(`r"""[\s\S]*?"""|r'''[\s\S]*?'''`), textBlockHandler(true)},
+	{regexp.MustCompile(`r"""|r'''`), unterminatedHandler(true)},
+	{regexp.MustCompile(`"""(?:\\[\s\S]|[^\\])*?"""|'''(?:\\[\s\S]|[^\\])*?'''`), textBlockHandler(false)},
+	{regexp.MustCompile(`"""|'''`), unterminatedHandler(true)},
+	{regexp.MustCompile(`r"[^"]*"|r'[^']*'`), rawStringHandler},
+	{regexp.MustCompile(`r["']`), unterminatedHandler(false)},
+	{regexp.MustCompile(`"(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*'`), stringHandler},
+	{regexp.MustCompile(`["']`), unterminatedHandler(false)},
+	{regexp.MustCompile(`0[xXoObB][0-9a-zA-Z_]*`), numberHandler(INT)},
+	{regexp.MustCompile(`[0-9][0-9_]*\.[0-9][0-9_]*(?:[eE][+-]?[0-9][0-9_]*)?|\.[0-9][0-9_]*(?:[eE][+-]?[0-9][0-9_]*)?|[0-9][0-9_]*[eE][+-]?[0-9][0-9_]*`), numberHandler(FLOAT)},
+	{regexp.MustCompile(`[0-9][0-9_]*`), numberHandler(INT)},
+	{regexp.MustCompile(`[\p{L}\p{Nl}_][\p{L}\p{Nl}\p{Mn}\p{Mc}\p{Nd}\p{Pc}]*`), identifierHandler},
+	{regexp.MustCompile("`"), templateHandler},
+	{regexp.MustCompile(`\[`), defaultHandler(OPEN_BRACKET, "[")},
+	{regexp.MustCompile(`\]`), defaultHandler(CLOSE_BRACKET, "]")},
+	{regexp.MustCompile(`\{`), defaultHandler(OPEN_CURLY, "{")},
+	{regexp.MustCompile(`\}`), defaultHandler(CLOSE_CURLY, "}")},
+	{regexp.MustCompile(`\(`), defaultHandler(OPEN_PAREN, "(")},
+	{regexp.MustCompile(`\)`), defaultHandler(CLOSE_PAREN, ")")},
+	{regexp.MustCompile(`==`), defaultHandler(EQUALS, "==")},
+	{regexp.MustCompile(`!=`), defaultHandler(NOT_EQUALS, "!=")},
+	{regexp.MustCompile(`=`), defaultHandler(ASSIGNMENT, "=")},
+	{regexp.MustCompile(`!`), defaultHandler(NOT, "!")},
+	{regexp.MustCompile(`<<=`), defaultHandler(SHIFT_LEFT_EQUALS, "<<=")},
+	{regexp.MustCompile(`<<`), defaultHandler(SHIFT_LEFT, "<<")},
+	{regexp.MustCompile(`<=`), defaultHandler(LESS_EQUALS, "<=")},
+	{regexp.MustCompile(`<`), defaultHandler(LESS, "<")},
+	{regexp.MustCompile(`>>=`), defaultHandler(SHIFT_RIGHT_EQUALS, ">>=")},
+	{regexp.MustCompile(`>>`), defaultHandler(SHIFT_RIGHT, ">>")},
+	{regexp.MustCompile(`>=`), defaultHandler(GREATER_EQUALS, ">=")},
+	{regexp.MustCompile(`>`), defaultHandler(GREATER, ">")},
+	{regexp.MustCompile(`\|\|`), defaultHandler(OR, "||")},
+	{regexp.MustCompile(`\|=`), defaultHandler(PIPE_EQUALS, "|=")},
+	{regexp.MustCompile(`\|>`), defaultHandler(PIPELINE, "|>")},
+	{regexp.MustCompile(`\|`), defaultHandler(PIPE, "|")},
+	{regexp.MustCompile(`&&`), defaultHandler(AND, "&&")},
+	{regexp.MustCompile(`&=`), defaultHandler(AMPERSAND_EQUALS, "&=")},
+	{regexp.MustCompile(`&`), defaultHandler(AMPERSAND, "&")},
+	{regexp.MustCompile(`\^=`), defaultHandler(CARET_EQUALS, "^=")},
+	{regexp.MustCompile(`\^`), defaultHandler(CARET, "^")},
+	{regexp.MustCompile(`~/=`), defaultHandler(TILDE_SLASH_EQUALS, "~/=")},
+	{regexp.MustCompile(`~/`), defaultHandler(TILDE_SLASH, "~/")},
+	{regexp.MustCompile(`~`), defaultHandler(TILDE, "~")},
+	{regexp.MustCompile(`\.\.`), defaultHandler(DOT_DOT, "..")},
+	{regexp.MustCompile(`\.`), defaultHandler(DOT, ".")},
+	{regexp.MustCompile(`;`), defaultHandler(SEMI_COLON, ";")},
+	{regexp.MustCompile(`:`), defaultHandler(COLON, ":")},
+	{regexp.MustCompile(`\?\?=`), defaultHandler(QUESTION_QUESTION_EQUALS, "??=")},
+	{regexp.MustCompile(`\?\?`), defaultHandler(QUESTION_QUESTION, "??")},
+	// a ?.5 : 1 - условный оператор с дробным числом, а не ?.
+	{regexp.MustCompile(`\?\.[0-9]`), defaultHandler(QUESTION, "?")},
+	{regexp.MustCompile(`\?\.`), defaultHandler(QUESTION_DOT, "?.")},
+	{regexp.MustCompile(`\?`), defaultHandler(QUESTION, "?")},
+	{regexp.MustCompile(`,`), defaultHandler(COMMA, ",")},
+	{regexp.MustCompile(`\+\+`), defaultHandler(PLUS_PLUS, "++")},
+	{regexp.MustCompile(`--`), defaultHandler(MINUS_MINUS, "--")},
+	{regexp.MustCompile(`\+=`), defaultHandler(PLUS_EQUALS, "+=")},
+	{regexp.MustCompile(`-=`), defaultHandler(MINUS_EQUALS, "-=")},
+	{regexp.MustCompile(`\*\*=`), defaultHandler(STAR_STAR_EQUALS, "**=")},
+	{regexp.MustCompile(`\*\*`), defaultHandler(STAR_STAR, "**")},
+	{regexp.MustCompile(`\*=`), defaultHandler(STAR_EQUALS, "*=")},
+	{regexp.MustCompile(`/=`), defaultHandler(SLASH_EQUALS, "/=")},
+	{regexp.MustCompile(`%=`), defaultHandler(PERCENT_EQUALS, "%=")},
+	{regexp.MustCompile(`\+`), defaultHandler(PLUS, "+")},
+	{regexp.MustCompile(`-`), defaultHandler(MINUS, "-")},
+	{regexp.MustCompile(`/`), defaultHandler(SLASH, "/")},
+	{regexp.MustCompile(`\*`), defaultHandler(STAR, "*")},
+	{regexp.MustCompile(`%`), defaultHandler(PERCENT, "%")},
+}
+
+func Tokenize(source string) ([]Token, []string) {
+	tokens, errs := createLexer(source).tokenize()
+	return tokens, Messages(errs)
+}
+
+/*
+Регулярные выражения не умеют сообщать, что совпадение могло бы
+продолжиться, поэтому потоковый режим сначала дочитывает весь вход.
+*/
+func (lex *lexer) scanToken() scanResult {
+	if !lex.final {
+		return incomplete
+	}
+	if lex.inTemplateText() {
+		return lex.scanTemplateText()
+	}
+	remainder := lex.remainder()
+	if c := remainder[0]; (c == '{' || c == '}') && lex.scanTemplateCurly(c) {
+		return scanned
+	}
+
+	for _, pattern := range patterns {
+		loc := pattern.regex.FindStringIndex(remainder)
+		if loc != nil && loc[0] == 0 {
+			pattern.handler(lex, pattern.regex)
+			return scanned
+		}
+	}
+	return unrecognized
+}
+
+func defaultHandler(kind TokenKind, value string) regexHandler {
+	return func(lex *lexer, _ *regexp.Regexp) {
+		lex.pushWord(kind, value)
+	}
+}
+
+func stringHandler(lex *lexer, regex *regexp.Regexp) {
+	lex.pushString(regex.FindString(lex.remainder()))
+}
+
+func rawStringHandler(lex *lexer, regex *regexp.Regexp) {
+	lex.pushRawString(regex.FindString(lex.remainder()))
+}
+
+func textBlockHandler(raw bool) regexHandler {
+	return func(lex *lexer, regex *regexp.Regexp) {
+		lex.pushTextBlock(regex.FindString(lex.remainder()), raw)
+	}
+}
+
+func unterminatedHandler(multiline bool) regexHandler {
+	return func(lex *lexer, _ *regexp.Regexp) {
+		lex.skipUnterminatedString(multiline)
+	}
+}
+
+func numberHandler(kind TokenKind) func(*lexer, *regexp.Regexp) {
+	return func(lex *lexer, regex *regexp.Regexp) {
+		lex.pushWord(kind, regex.FindString(lex.remainder()))
+	}
+}
+
+func identifierHandler(lex *lexer, regex *regexp.Regexp) {
+	lex.pushIdentifier(regex.FindString(lex.remainder()))
+}
+
+func templateHandler(lex *lexer, _ *regexp.Regexp) {
+	lex.openTemplate()
+}
+
+func skipHandler(lex *lexer, regex *regexp.Regexp) {
+	match := regex.FindString(lex.remainder())
+	if match == "" {
+		return
+	}
+	lex.skip(len(match), triviaKindOf(match))
+}
@@ -0,0 +1,20 @@
+//go:build regexlexer
+
+package lexer
+
+import "testing"
+
+/*
+Лексер на регулярных выражениях ищет каждый шаблон по всему остатку
+входа, и время у него растёт быстрее квадрата: 64KB разбираются
+десятки секунд. Поэтому и размеры меньше, чем у сканера.
+*/
+var benchmarkSizes = []int{4 << 10, 16 << 10}
+
+/*
+go test -tags regexlexer ./src/lexer: лексер на регулярных выражениях
+должен выдать те же токены, что записаны в golden файлах сканером.
+*/
+func TestRegexLexerGolden(t *testing.T) {
+	checkGolden(t)
+}
@@ -0,0 +1,180 @@
+package lexer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+/*
+Источники, которые отдают вход порциями разной длины: токены могут
+разрываться на границе любой порции.
+*/
+var chunkedReaders = []struct {
+	name   string
+	reader func(source string) io.Reader
+}{
+	{"whole", func(source string) io.Reader { return strings.NewReader(source) }},
+	{"one byte", func(source string) io.Reader { return iotest.OneByteReader(strings.NewReader(source)) }},
+	{"half", func(source string) io.Reader { return iotest.HalfReader(strings.NewReader(source)) }},
+	{"data with EOF", func(source string) io.Reader { return iotest.DataErrReader(strings.NewReader(source)) }},
+}
+
+func streamTokens(stream *Stream) ([]Token, []string) {
+	tokens := make([]Token, 0)
+	for token := range stream.All() {
+		tokens = append(tokens, token)
+	}
+	return tokens, stream.Errors()
+}
+
+/*
+Stream выдаёт те же токены и ошибки, что Tokenize и TokenizeTrivia,
+как бы ни был порезан вход.
+*/
+func TestStreamMatchesTokenize(t *testing.T) {
+	for _, input := range testInputs(t) {
+		source, err := os.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := dumpTokens(Tokenize(string(source)))
+		wantTrivia := dumpTokens(TokenizeTrivia(string(source)))
+
+		for _, r := range chunkedReaders {
+			t.Run(filepath.Base(input)+"/"+r.name, func(t *testing.T) {
+				got := dumpTokens(streamTokens(NewStream(r.reader(string(source)))))
+				if got != want {
+					t.Errorf("stream differs from Tokenize:\n%s", firstDifference(got, want))
+				}
+				got = dumpTokens(streamTokens(NewStream(r.reader(string(source))).KeepTrivia()))
+				if got != wantTrivia {
+					t.Errorf("stream differs from TokenizeTrivia:\n%s", firstDifference(got, wantTrivia))
+				}
+			})
+		}
+	}
+}
+
+/*
+С KeepSource Text возвращает текст любого токена и после разбора.
+*/
+func TestStreamKeepSource(t *testing.T) {
+	source := "var a = 1\nprintln(`x = ${a}`)\n" + strings.Repeat("a = a + 1 // next\n", 50)
+	spool, err := os.CreateTemp(t.TempDir(), "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	stream := NewStream(iotest.OneByteReader(strings.NewReader(source))).KeepSource(spool)
+	tokens, errs := streamTokens(stream)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	for _, token := range tokens {
+		pos := token.Position
+		if got := stream.Text(pos); got != source[pos.StartPos:pos.EndPos] {
+			t.Errorf("Text(%d-%d) = %q, want %q", pos.StartPos, pos.EndPos, got, source[pos.StartPos:pos.EndPos])
+		}
+	}
+	if got := stream.Text(Position{StartPos: len(source), EndPos: len(source) + 5}); got != "" {
+		t.Errorf("text past the end of input %q", got)
+	}
+}
+
+/*
+Входы из повторов testdata/tokens.fs размеров benchmarkSizes. Сравнить
+лексеры можно запуском с тегом regexlexer и без него, размер 16KB есть
+в обоих:
+
+	go test -bench . -run '^$' ./src/lexer
+	go test -tags regexlexer -bench . -run '^$' ./src/lexer
+*/
+func benchmarkSizeName(size int) string {
+	if size >= 1<<20 {
+		return fmt.Sprintf("%dMB", size>>20)
+	}
+	return fmt.Sprintf("%dKB", size>>10)
+}
+
+func benchmarkSource(b *testing.B, size int) string {
+	b.Helper()
+	sample, err := os.ReadFile("testdata/tokens.fs")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return strings.Repeat(string(sample), size/len(sample)+1)
+}
+
+func benchmarkBySize(b *testing.B, tokenize func(b *testing.B, source string)) {
+	for _, size := range benchmarkSizes {
+		b.Run(benchmarkSizeName(size), func(b *testing.B) {
+			source := benchmarkSource(b, size)
+			b.SetBytes(int64(len(source)))
+			b.ResetTimer()
+			for range b.N {
+				tokenize(b, source)
+			}
+		})
+	}
+}
+
+func BenchmarkTokenize(b *testing.B) {
+	benchmarkBySize(b, func(b *testing.B, source string) {
+		if _, errs := Tokenize(source); len(errs) > 0 {
+			b.Fatal(errs[0])
+		}
+	})
+}
+
+func BenchmarkTokenizeTrivia(b *testing.B) {
+	benchmarkBySize(b, func(b *testing.B, source string) {
+		if _, errs := TokenizeTrivia(source); len(errs) > 0 {
+			b.Fatal(errs[0])
+		}
+	})
+}
+
+func BenchmarkStream(b *testing.B) {
+	benchmarkBySize(b, func(b *testing.B, source string) {
+		stream := NewStream(strings.NewReader(source))
+		for range stream.All() {
+		}
+		if errs := stream.Errors(); len(errs) > 0 {
+			b.Fatal(errs[0])
+		}
+	})
+}
+
+/*
+Позиция без строки, например созданная не лексером, печатается
+смещением, чтобы его не приняли за строку и колонку.
+*/
+func TestPositionString(t *testing.T) {
+	tests := []struct {
+		pos  Position
+		want string
+	}{
+		{Position{StartPos: 4, EndPos: 9, Line: 2, Column: 3}, "2:3"},
+		{Position{StartPos: 4, EndPos: 9}, "offset 4"},
+		{Position{}, "offset 0"},
+	}
+	for _, tt := range tests {
+		if got := tt.pos.String(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.pos, got, tt.want)
+		}
+	}
+
+	tokens, errs := Tokenize("var ё = 1\n  ё")
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	if got := tokens[4].Position.String(); got != "2:3" {
+		t.Errorf("identifier on the second line at %s, want 2:3", got)
+	}
+}
@@ -0,0 +1,355 @@
+//go:build !regexlexer
+
+package lexer
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Операторы и символы в порядке проверки: более длинные идут раньше
+своих префиксов.
+*/
+var symbols = []struct {
+	value string
+	kind  TokenKind
+}{
+	{"[", OPEN_BRACKET},
+	{"]", CLOSE_BRACKET},
+	{"{", OPEN_CURLY},
+	{"}", CLOSE_CURLY},
+	{"(", OPEN_PAREN},
+	{")", CLOSE_PAREN},
+	{"==", EQUALS},
+	{"!=", NOT_EQUALS},
+	{"=", ASSIGNMENT},
+	{"!", NOT},
+	{"<<=", SHIFT_LEFT_EQUALS},
+	{"<<", SHIFT_LEFT},
+	{"<=", LESS_EQUALS},
+	{"<", LESS},
+	{">>=", SHIFT_RIGHT_EQUALS},
+	{">>", SHIFT_RIGHT},
+	{">=", GREATER_EQUALS},
+	{">", GREATER},
+	{"||", OR},
+	{"|=", PIPE_EQUALS},
+	{"|>", PIPELINE},
+	{"|", PIPE},
+	{"&&", AND},
+	{"&=", AMPERSAND_EQUALS},
+	{"&", AMPERSAND},
+	{"^=", CARET_EQUALS},
+	{"^", CARET},
+	{"~/=", TILDE_SLASH_EQUALS},
+	{"~/", TILDE_SLASH},
+	{"~", TILDE},
+	{"..", DOT_DOT},
+	{".", DOT},
+	{";", SEMI_COLON},
+	{":", COLON},
+	{"??=", QUESTION_QUESTION_EQUALS},
+	{"??", QUESTION_QUESTION},
+	{"?", QUESTION},
+	{",", COMMA},
+	{"++", PLUS_PLUS},
+	{"--", MINUS_MINUS},
+	{"+=", PLUS_EQUALS},
+	{"-=", MINUS_EQUALS},
+	{"**=", STAR_STAR_EQUALS},
+	{"**", STAR_STAR},
+	{"*=", STAR_EQUALS},
+	{"/=", SLASH_EQUALS},
+	{"%=", PERCENT_EQUALS},
+	{"+", PLUS},
+	{"-", MINUS},
+	{"/", SLASH},
+	{"*", STAR},
+	{"%", PERCENT},
+}
+
+/*
+Разбивает исходный код на токены за один проход.
+
+Результат совпадает с лексером на регулярных выражениях (тег сборки
+regexlexer), включая тексты ошибок.
+*/
+func Tokenize(source string) ([]Token, []string) {
+	tokens, errs := createLexer(source).tokenize()
+	return tokens, Messages(errs)
+}
+
+/*
+Разбирает один токен в начале остатка. Пока вход не прочитан до конца
+(final), токен, упирающийся в конец окна, может оказаться длиннее:
+тогда возвращается incomplete и окно нужно дополнить.
+*/
+func (lex *lexer) scanToken() scanResult {
+	if lex.inTemplateText() {
+		return lex.scanTemplateText()
+	}
+	remainder := lex.remainder()
+	c := remainder[0]
+	more := !lex.final
+
+	// Самые длинные операторы состоят из трёх символов
+	if more && len(remainder) < 3 {
+		return incomplete
+	}
+
+	switch {
+	case strings.HasPrefix(remainder, "//"):
+		end := strings.IndexByte(remainder, '\n')
+		if end < 0 {
+			if more {
+				return incomplete
+			}
+			end = len(remainder)
+		}
+		lex.skip(end, LINE_COMMENT)
+		return scanned
+	case strings.HasPrefix(remainder, "/*"):
+		// Незакрытый комментарий разбирается как / и *
+		if end := strings.Index(remainder[2:], "*/"); end >= 0 {
+			lex.skip(end+4, BLOCK_COMMENT)
+			return scanned
+		}
+		if more {
+			return incomplete
+		}
+	case c == '`':
+		lex.openTemplate()
+		return scanned
+	case (c == '{' || c == '}') && lex.scanTemplateCurly(c):
+		return scanned
+	case isSpace(c):
+		// Пробелы можно пропускать частями, на токены это не влияет
+		end := 1
+		for end < len(remainder) && isSpace(remainder[end]) {
+			end++
+		}
+		lex.skip(end, WHITESPACE)
+		return scanned
+	case c == '"' || c == '\'' || c == 'r' && len(remainder) > 1 && (remainder[1] == '"' || remainder[1] == '\''):
+		return lex.scanStringLiteral()
+	case isDigit(c) || c == '.' && len(remainder) > 1 && isDigit(remainder[1]):
+		end, kind := scanNumber(remainder)
+		// Дальше может идти дробная часть или экспонента со знаком
+		if more && end+3 > len(remainder) {
+			return incomplete
+		}
+		lex.pushWord(kind, remainder[:end])
+		return scanned
+	case isIdentStart(c) || c >= utf8.RuneSelf:
+		end, partial := scanIdentifier(remainder)
+		if more && (partial || end == len(remainder)) {
+			return incomplete
+		}
+		if end > 0 {
+			lex.pushIdentifier(remainder[:end])
+			return scanned
+		}
+	case strings.HasPrefix(remainder, "?."):
+		// a ?.5 : 1 - условный оператор с дробным числом, а не ?.
+		if len(remainder) < 3 || !isDigit(remainder[2]) {
+			lex.pushWord(QUESTION_DOT, "?.")
+			return scanned
+		}
+	}
+
+	for _, symbol := range symbols {
+		if strings.HasPrefix(remainder, symbol.value) {
+			lex.pushWord(symbol.kind, symbol.value)
+			return scanned
+		}
+	}
+	// Ошибка поглощает весь остаток входа, поэтому сначала дочитываем его
+	if more {
+		return incomplete
+	}
+	return unrecognized
+}
+
+/*
+Строковые литералы: "...", r"...", """...""" и r"""...""". Пока вход
+не дочитан, незакрытая строка может закрыться в следующей порции.
+*/
+func (lex *lexer) scanStringLiteral() scanResult {
+	remainder := lex.remainder()
+	more := !lex.final
+
+	raw := remainder[0] == 'r'
+	body := remainder
+	if raw {
+		body = remainder[1:]
+	}
+	// Тройную кавычку можно распознать только по трём символам
+	if more && len(body) < 3 {
+		return incomplete
+	}
+	quote := body[0]
+	triple := strings.HasPrefix(body, strings.Repeat(string(quote), 3))
+
+	end := 0
+	switch {
+	case triple && raw:
+		if close := strings.Index(body[3:], body[:3]); close >= 0 {
+			end = 1 + 3 + close + 3
+		}
+	case triple:
+		end = scanTextBlock(body)
+	case raw:
+		if close := strings.IndexByte(body[1:], quote); close >= 0 {
+			end = 1 + 1 + close + 1
+		}
+	default:
+		end = scanString(body)
+	}
+
+	if end == 0 {
+		if more {
+			return incomplete
+		}
+		lex.skipUnterminatedString(triple)
+		return scanned
+	}
+
+	switch {
+	case triple:
+		lex.pushTextBlock(remainder[:end], raw)
+	case raw:
+		lex.pushRawString(remainder[:end])
+	default:
+		lex.pushString(remainder[:end])
+	}
+	return scanned
+}
+
+/*
+Длина литерала в тройных кавычках в начале s или 0, если он не закрыт.
+*/
+func scanTextBlock(s string) int {
+	delimiter := s[:3]
+	for i := 3; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+		case strings.HasPrefix(s[i:], delimiter):
+			return i + 3
+		}
+	}
+	return 0
+}
+
+/*
+Длина строкового литерала в начале s вместе с кавычками или 0, если
+строка не закрыта. После обратной косой черты допустим любой символ,
+кроме перевода строки.
+*/
+func scanString(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return i + 1
+		case '\\':
+			if i+1 >= len(s) || s[i+1] == '\n' {
+				return 0
+			}
+			i++
+		}
+	}
+	return 0
+}
+
+/*
+Длина числового литерала в начале s и его вид. Литерал разбирается
+с запасом: 0x, 0o и 0b забирают все буквы и цифры, разделители _
+допустимы везде, а проверку значения делает парсер.
+*/
+func scanNumber(s string) (int, TokenKind) {
+	if len(s) > 1 && s[0] == '0' && strings.IndexByte("xXoObB", s[1]) >= 0 {
+		end := 2
+		for end < len(s) && (isIdentStart(s[end]) || isDigit(s[end])) {
+			end++
+		}
+		return end, INT
+	}
+
+	kind := INT
+	end := scanDigits(s, 0)
+	if end+1 < len(s) && s[end] == '.' && isDigit(s[end+1]) {
+		kind = FLOAT
+		end = scanDigits(s, end+1)
+	}
+	if end+1 < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		exp := end + 1
+		if s[exp] == '+' || s[exp] == '-' {
+			exp++
+		}
+		if exp < len(s) && isDigit(s[exp]) {
+			kind = FLOAT
+			end = scanDigits(s, exp)
+		}
+	}
+	return end, kind
+}
+
+/*
+Цифры и разделители _, начиная с start.
+*/
+func scanDigits(s string, start int) int {
+	end := start
+	for end < len(s) && (isDigit(s[end]) || end > start && s[end] == '_') {
+		end++
+	}
+	return end
+}
+
+/*
+Длина идентификатора в начале s по UAX #31: первый символ - буква,
+буквенное число или _, дальше также цифры, комбинирующие знаки и
+соединители. partial означает, что s обрывается посреди символа UTF-8.
+*/
+func scanIdentifier(s string) (end int, partial bool) {
+	for end < len(s) {
+		c := s[end]
+		if c < utf8.RuneSelf {
+			if !isIdentStart(c) && (end == 0 || !isDigit(c)) {
+				return end, false
+			}
+			end++
+			continue
+		}
+		if !utf8.FullRuneInString(s[end:]) {
+			return end, true
+		}
+		r, size := utf8.DecodeRuneInString(s[end:])
+		if !isIdentRune(r, end == 0) {
+			return end, false
+		}
+		end += size
+	}
+	return end, false
+}
+
+func isIdentRune(r rune, first bool) bool {
+	if unicode.IsLetter(r) || unicode.Is(unicode.Nl, r) {
+		return true
+	}
+	return !first && unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
@@ -0,0 +1,31 @@
+//go:build !regexlexer
+
+package lexer
+
+import (
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var benchmarkSizes = []int{16 << 10, 1 << 20, 4 << 20}
+
+func TestScannerGolden(t *testing.T) {
+	checkGolden(t)
+}
+
+/*
+Без KeepSource окно потока не хранит весь вход: текст ранних токенов
+к концу разбора уже отброшен. Лексер на регулярных выражениях сначала
+дочитывает весь вход, поэтому проверка только для сканера.
+*/
+func TestStreamWindow(t *testing.T) {
+	source := "var first = 1\n" + strings.Repeat("first = first + 1\n", 50)
+	stream := NewStream(iotest.OneByteReader(strings.NewReader(source)))
+	first := stream.Next()
+	for range stream.All() {
+	}
+	if text := stream.Text(first.Position); text != "" {
+		t.Errorf("first token %q is still in the window", text)
+	}
+}
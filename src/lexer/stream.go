@@ -0,0 +1,186 @@
+package lexer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+)
+
+const streamChunkSize = 64 * 1024
+
+/*
+Потоковый лексер: читает исходный код из io.Reader порциями и отдаёт
+токены по одному. В памяти хранится только окно с ещё не разобранным
+текстом и последним выданным токеном.
+
+Токены и ошибки совпадают с Tokenize над тем же текстом.
+*/
+type Stream struct {
+	lex    *lexer
+	reader io.Reader
+	chunk  []byte
+	eof    *Token
+	// Начало последнего выданного токена во всём входе
+	last int
+	// Куда копируется прочитанный текст, если задан KeepSource
+	spool Spool
+}
+
+/*
+Хранилище прочитанного текста, например временный файл.
+*/
+type Spool interface {
+	io.Writer
+	io.ReaderAt
+}
+
+func NewStream(reader io.Reader) *Stream {
+	return &Stream{
+		lex: &lexer{
+			errors:    make([]Error, 0),
+			Tokens:    make([]Token, 0, 1),
+			line:      1,
+			column:    1,
+			tokenLine: 1,
+		},
+		reader: reader,
+		chunk:  make([]byte, streamChunkSize),
+	}
+}
+
+/*
+Включает режим без потерь, как у TokenizeTrivia. Вызывается до
+первого Next.
+*/
+func (s *Stream) KeepTrivia() *Stream {
+	s.lex.trivia = true
+	return s
+}
+
+/*
+Копирует прочитанный текст в spool, чтобы Text работал для любой
+позиции и после разбора, например для фрагментов кода в ошибках
+resolver. Окно в памяти не растёт: текст, который из него уже ушёл,
+Text читает из spool. Вызывается до первого Next.
+*/
+func (s *Stream) KeepSource(spool Spool) *Stream {
+	s.spool = spool
+	return s
+}
+
+/*
+Следующий токен. После конца входа каждый вызов возвращает EOF.
+*/
+func (s *Stream) Next() Token {
+	if s.eof != nil {
+		return *s.eof
+	}
+
+	lex := s.lex
+	for len(lex.Tokens) == 0 {
+		if lex.at_eof() {
+			if lex.final {
+				lex.pushEOF()
+				break
+			}
+			s.fill()
+			continue
+		}
+
+		switch lex.scanToken() {
+		case incomplete:
+			s.fill()
+		case unrecognized:
+			lex.skipUnrecognized()
+		}
+	}
+
+	// Один шаг сканера может дать несколько токенов, отдаём по одному
+	token := lex.Tokens[0]
+	if len(lex.Tokens) == 1 {
+		lex.Tokens = lex.Tokens[:0]
+	} else {
+		lex.Tokens = lex.Tokens[1:]
+	}
+	s.last = token.Position.StartPos
+	if token.Kind == EOF {
+		s.eof = &token
+	}
+	return token
+}
+
+/*
+Все оставшиеся токены, включая завершающий EOF.
+*/
+func (s *Stream) All() iter.Seq[Token] {
+	return func(yield func(Token) bool) {
+		for {
+			token := s.Next()
+			if !yield(token) || token.Kind == EOF {
+				return
+			}
+		}
+	}
+}
+
+/*
+Ошибки лексера, накопленные к этому моменту.
+*/
+func (s *Stream) Errors() []string {
+	return Messages(s.lex.errors)
+}
+
+/*
+Текст входа в заданных границах. Без KeepSource - только если он ещё
+в окне, иначе пустая строка. Нужен для фрагментов кода в сообщениях
+парсера.
+*/
+func (s *Stream) Text(pos Position) string {
+	start, end := pos.StartPos-s.lex.offset, pos.EndPos-s.lex.offset
+	if start >= 0 && end <= len(s.lex.source) && start <= end {
+		return s.lex.source[start:end]
+	}
+	if s.spool == nil || pos.StartPos < 0 || pos.StartPos > pos.EndPos || pos.EndPos > s.lex.offset+len(s.lex.source) {
+		return ""
+	}
+	text := make([]byte, pos.EndPos-pos.StartPos)
+	if _, err := s.spool.ReadAt(text, int64(pos.StartPos)); err != nil {
+		return ""
+	}
+	return string(text)
+}
+
+/*
+Отбрасывает разобранную часть окна и дочитывает следующую порцию.
+Текст последнего выданного токена остаётся в окне для Text.
+*/
+func (s *Stream) fill() {
+	lex := s.lex
+	cut := min(lex.pos, s.last-lex.offset)
+	lex.source = lex.source[cut:]
+	lex.offset += cut
+	lex.pos -= cut
+
+	// Длинный токен дочитывается порциями не меньше окна, чтобы
+	// копирование окна оставалось линейным
+	if len(lex.source) > len(s.chunk) {
+		s.chunk = make([]byte, len(lex.source))
+	}
+	n, err := s.reader.Read(s.chunk)
+	lex.source += string(s.chunk[:n])
+	if s.spool != nil && n > 0 {
+		if _, spoolErr := s.spool.Write(s.chunk[:n]); spoolErr != nil {
+			message := fmt.Sprintf("failed to keep source: %v", spoolErr)
+			lex.errors = append(lex.errors, Error{Message: message, Text: message})
+			s.spool = nil
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			message := fmt.Sprintf("failed to read source: %v", err)
+			lex.errors = append(lex.errors, Error{Message: message, Text: message})
+		}
+		lex.final = true
+	}
+}
@@ -0,0 +1,105 @@
+package lexer
+
+import (
+	"finescript/src/helpers"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var templateEscapeRegex = regexp.MustCompile("\\\\(?:[nrt\\\\'\"`$]|x[0-9a-fA-F]{2}|u[0-9a-fA-F]{4}|U[0-9a-fA-F]{8})")
+
+/*
+Шаблонная строка разбирается на токены:
+
+	`a ${x} b` → TEMPLATE_START, TEMPLATE_TEXT("a "), INTERP_START,
+	             IDENTIFIER(x), INTERP_END, TEMPLATE_TEXT(" b"), TEMPLATE_END
+
+Выражения внутри ${...} разбираются обычным лексером, поэтому их токены
+получают настоящие позиции в исходном коде.
+*/
+func (lex *lexer) openTemplate() {
+	lex.pushWord(TEMPLATE_START, "`")
+	lex.templates = append(lex.templates, templateText)
+}
+
+/*
+Текст шаблона до ${ или закрывающей кавычки.
+*/
+func (lex *lexer) scanTemplateText() scanResult {
+	remainder := lex.remainder()
+
+	end := 0
+	for end < len(remainder) {
+		switch {
+		case remainder[end] == '\\' && end+1 < len(remainder):
+			end += 2
+			continue
+		case remainder[end] == '`':
+			lex.pushTemplateText(remainder[:end])
+			lex.pushWord(TEMPLATE_END, "`")
+			lex.templates = lex.templates[:len(lex.templates)-1]
+			return scanned
+		case strings.HasPrefix(remainder[end:], "${"):
+			lex.pushTemplateText(remainder[:end])
+			lex.pushWord(INTERP_START, "${")
+			lex.templates[len(lex.templates)-1] = 0
+			return scanned
+		}
+		end++
+	}
+
+	if !lex.final {
+		return incomplete
+	}
+	lex.error(fmt.Sprintf("unterminated template string near \"%v\"", helpers.Ellipsis(remainder, 20)), 0)
+	lex.skip(len(remainder), SKIPPED)
+	lex.templates = lex.templates[:len(lex.templates)-1]
+	return scanned
+}
+
+func (lex *lexer) pushTemplateText(text string) {
+	if text == "" {
+		return
+	}
+	value := templateEscapeRegex.ReplaceAllStringFunc(text, func(seq string) string {
+		switch seq {
+		case "\\`", "\\$":
+			return seq[1:]
+		default:
+			return helpers.RemoveEscapeSigns(seq)
+		}
+	})
+
+	lex.push(Token{
+		Kind:     TEMPLATE_TEXT,
+		Value:    value,
+		Position: lex.consume(len(text)),
+	})
+}
+
+/*
+Фигурные скобки внутри ${...}: вложенные считаются, а закрывающая
+на нулевой глубине завершает вставку. Возвращает false, если скобка
+обычная и её нужно разобрать как символ.
+*/
+func (lex *lexer) scanTemplateCurly(c byte) bool {
+	if len(lex.templates) == 0 {
+		return false
+	}
+	top := &lex.templates[len(lex.templates)-1]
+
+	switch c {
+	case '{':
+		*top++
+		return false
+	case '}':
+		if *top == 0 {
+			lex.pushWord(INTERP_END, "}")
+			*top = templateText
+			return true
+		}
+		*top--
+	}
+	return false
+}
@@ -0,0 +1,3 @@
+var a = 1 @ 2
+var b = "unterminated
+var c = `open ${a
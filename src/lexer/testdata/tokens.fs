@@ -0,0 +1,23 @@
+// Комментарий в начале
+/* блочный
+   комментарий */
+let a = 10
+const b = 0x1F + 0b101 - 0o17 * 3.14e-2 / 2.
+var имя_переменной = "строка с \"кавычками\"\n"
+var ǅx = a % 3 ** 2
+
+fun add(x: int, y: int): int { x + y } // хвост
+type Point = struct { x: int, y: int }
+
+if a >= b && !(a <= 1) || a != b {
+  a += 1; a -= 2; a *= 3; a /= 4
+  a++
+  --a
+} else if a == b { a = ~a << 2 >> 1 & 3 | 4 ^ 5 }
+
+var s = `Привет, ${имя_переменной}! ${ `вложенная ${a + 1}` } конец`
+var t = a ?? b
+var u = add?.(1, 2)
+var v = a > 1 ? "да" : "нет"
+a |> add(2, _) |> println
+null; undefined; true; false
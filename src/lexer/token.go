@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"fmt"
+	"sort"
 	// "slices"
 )
 
@@ -21,6 +22,13 @@ const (
 	STRING
 	IDENTIFIER
 
+	// Шаблонная строка `text ${expr} text`
+	TEMPLATE_START
+	TEMPLATE_TEXT
+	INTERP_START
+	INTERP_END
+	TEMPLATE_END
+
 	// Скобки
 	OPEN_BRACKET
 	CLOSE_BRACKET
@@ -44,6 +52,12 @@ const (
 	OR
 	AND
 
+	// Работа с null и undefined
+	QUESTION_QUESTION // ??
+	QUESTION_DOT      // ?.
+
+	PIPELINE // |>
+
 	// Символы
 	DOT
 	DOT_DOT
@@ -60,9 +74,17 @@ const (
 	ASSIGNMENT
 	PLUS_EQUALS
 	MINUS_EQUALS
-	// STAR_EQUALS
-	// SLASH_EQUALS
-	// PERCENT_EQUALS
+	STAR_EQUALS
+	SLASH_EQUALS
+	PERCENT_EQUALS
+	STAR_STAR_EQUALS
+	TILDE_SLASH_EQUALS
+	AMPERSAND_EQUALS
+	PIPE_EQUALS
+	CARET_EQUALS
+	SHIFT_LEFT_EQUALS
+	SHIFT_RIGHT_EQUALS
+	QUESTION_QUESTION_EQUALS
 
 	// Математика
 	PLUS
@@ -70,6 +92,16 @@ const (
 	SLASH
 	STAR
 	PERCENT
+	STAR_STAR   // **
+	TILDE_SLASH // ~/ - целочисленное деление
+
+	// Битовые
+	AMPERSAND
+	PIPE
+	CARET
+	TILDE
+	SHIFT_LEFT
+	SHIFT_RIGHT
 
 	// Ключевые слова
 	LET
@@ -128,19 +160,61 @@ var keywords = map[string]TokenKind{
 	"void":   VOID_TYPE,
 }
 
+/*
+Ключевые слова языка в алфавитном порядке.
+*/
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
+}
+
+/*
+StartPos и EndPos - байтовые смещения в исходном коде для вырезания
+фрагментов. Line и Column указывают на начало и считаются с единицы,
+колонка - в символах, а не в байтах.
+*/
 type Position struct {
 	StartPos int
 	EndPos   int
+	Line     int
+	Column   int
 }
 
+/*
+Строка и колонка начала. У позиций, созданных не лексером, их нет,
+тогда - байтовое смещение начала, чтобы его не спутать со строкой
+и колонкой.
+*/
 func (pos Position) String() string {
-	return fmt.Sprintf("%d:%d", pos.StartPos, pos.EndPos)
+	if pos.Line == 0 {
+		return fmt.Sprintf("offset %d", pos.StartPos)
+	}
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+/*
+Позиция от начала start до байтового смещения endPos.
+*/
+func Span(start Position, endPos int) Position {
+	start.EndPos = endPos
+	return start
 }
 
 type Token struct {
 	Kind     TokenKind
 	Value    string
 	Position Position
+	// Между предыдущим токеном и этим есть перевод строки. По нему
+	// парсер решает, где заканчивается инструкция без ';'
+	NewlineBefore bool
+	// Заполняются только в режиме без потерь (TokenizeTrivia):
+	// исходный текст токена и пробелы с комментариями перед ним
+	Raw     string
+	Leading []Trivia
 }
 
 // func (tk Token) IsOneOf(kinds ...TokenKind) bool {
@@ -167,6 +241,12 @@ var tokenKindString = map[TokenKind]string{
 	FALSE:      "false",
 	IDENTIFIER: "identifier",
 
+	TEMPLATE_START: "template_start",
+	TEMPLATE_TEXT:  "template_text",
+	INTERP_START:   "interp_start",
+	INTERP_END:     "interp_end",
+	TEMPLATE_END:   "template_end",
+
 	OPEN_BRACKET:  "open_bracket",
 	CLOSE_BRACKET: "close_bracket",
 	OPEN_CURLY:    "open_curly",
@@ -186,6 +266,11 @@ var tokenKindString = map[TokenKind]string{
 	OR:  "or",
 	AND: "and",
 
+	QUESTION_QUESTION: "question_question",
+	QUESTION_DOT:      "question_dot",
+
+	PIPELINE: "pipeline",
+
 	DOT:        "dot",
 	DOT_DOT:    "dot_dot",
 	SEMI_COLON: "semi_colon",
@@ -196,15 +281,36 @@ var tokenKindString = map[TokenKind]string{
 	PLUS_PLUS:   "plus_plus",
 	MINUS_MINUS: "minus_minus",
 
-	ASSIGNMENT:   "assignment",
-	PLUS_EQUALS:  "plus_equals",
-	MINUS_EQUALS: "minus_equals",
-
-	PLUS:    "plus",
-	MINUS:   "minus",
-	SLASH:   "slash",
-	STAR:    "star",
-	PERCENT: "percent",
+	ASSIGNMENT:         "assignment",
+	PLUS_EQUALS:        "plus_equals",
+	MINUS_EQUALS:       "minus_equals",
+	STAR_EQUALS:        "star_equals",
+	SLASH_EQUALS:       "slash_equals",
+	PERCENT_EQUALS:     "percent_equals",
+	STAR_STAR_EQUALS:   "star_star_equals",
+	TILDE_SLASH_EQUALS: "tilde_slash_equals",
+	AMPERSAND_EQUALS:   "ampersand_equals",
+	PIPE_EQUALS:        "pipe_equals",
+	CARET_EQUALS:       "caret_equals",
+	SHIFT_LEFT_EQUALS:  "shift_left_equals",
+	SHIFT_RIGHT_EQUALS: "shift_right_equals",
+
+	QUESTION_QUESTION_EQUALS: "question_question_equals",
+
+	PLUS:        "plus",
+	MINUS:       "minus",
+	SLASH:       "slash",
+	STAR:        "star",
+	PERCENT:     "percent",
+	STAR_STAR:   "star_star",
+	TILDE_SLASH: "tilde_slash",
+
+	AMPERSAND:   "ampersand",
+	PIPE:        "pipe",
+	CARET:       "caret",
+	TILDE:       "tilde",
+	SHIFT_LEFT:  "shift_left",
+	SHIFT_RIGHT: "shift_right",
 
 	LET:   "let",
 	VAR:   "var",
@@ -0,0 +1,115 @@
+package lexer
+
+import "strings"
+
+type TriviaKind int
+
+const (
+	WHITESPACE TriviaKind = iota
+	LINE_COMMENT
+	BLOCK_COMMENT
+	// Текст, пропущенный из-за ошибки лексера
+	SKIPPED
+)
+
+var triviaKindNames = map[TriviaKind]string{
+	WHITESPACE:    "whitespace",
+	LINE_COMMENT:  "line_comment",
+	BLOCK_COMMENT: "block_comment",
+	SKIPPED:       "skipped",
+}
+
+func TriviaKindString(kind TriviaKind) string {
+	return triviaKindNames[kind]
+}
+
+/*
+Всё, что лежит между токенами: пробелы, комментарии и текст, который
+лексер пропустил после ошибки.
+*/
+type Trivia struct {
+	Kind     TriviaKind
+	Text     string
+	Position Position
+}
+
+func (t Trivia) IsComment() bool {
+	return t.Kind == LINE_COMMENT || t.Kind == BLOCK_COMMENT
+}
+
+/*
+Разбивает исходный код на токены без потерь: каждый токен хранит
+свой исходный текст в Raw и предшествующие ему пробелы и комментарии
+в Leading. Хвост файла после последнего токена достаётся EOF.
+*/
+func TokenizeTrivia(source string) ([]Token, []string) {
+	tokens, errs := TokenizeTriviaErrors(source)
+	return tokens, Messages(errs)
+}
+
+/*
+Как TokenizeTrivia, но ошибки с позициями, например для подсветки
+в редакторе.
+*/
+func TokenizeTriviaErrors(source string) ([]Token, []Error) {
+	lex := createLexer(source)
+	lex.trivia = true
+	return lex.tokenize()
+}
+
+/*
+Исходный код, собранный обратно из токенов TokenizeTrivia. Совпадает
+с исходным байт в байт, в том числе для файлов с ошибками.
+*/
+func Source(tokens []Token) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		for _, trivia := range token.Leading {
+			sb.WriteString(trivia.Text)
+		}
+		sb.WriteString(token.Raw)
+	}
+	return sb.String()
+}
+
+/*
+Пропускает n байт, не образующих токен. В режиме trivia текст
+запоминается и достанется следующему токену. Пробелы, которые
+потоковый лексер пропускает частями, склеиваются.
+*/
+func (lex *lexer) skip(n int, kind TriviaKind) {
+	if !lex.trivia {
+		lex.advanceN(n)
+		return
+	}
+	if n == 0 {
+		return
+	}
+
+	text := lex.remainder()[:n]
+	pos := lex.consume(n)
+	if last := len(lex.pending) - 1; last >= 0 && kind == WHITESPACE && lex.pending[last].Kind == WHITESPACE {
+		lex.pending[last].Text += text
+		lex.pending[last].Position.EndPos = pos.EndPos
+		return
+	}
+	lex.pending = append(lex.pending, Trivia{
+		Kind:     kind,
+		Text:     text,
+		Position: pos,
+	})
+}
+
+/*
+Пробелы или комментарий в начале s.
+*/
+func triviaKindOf(s string) TriviaKind {
+	switch {
+	case strings.HasPrefix(s, "//"):
+		return LINE_COMMENT
+	case strings.HasPrefix(s, "/*"):
+		return BLOCK_COMMENT
+	default:
+		return WHITESPACE
+	}
+}
@@ -0,0 +1,326 @@
+package lint
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"strings"
+)
+
+type declKind int
+
+const (
+	variableDecl declKind = iota // let и var
+	constantDecl
+	functionDecl
+	paramDecl
+	typeDecl
+	builtinDecl
+)
+
+type declaration struct {
+	name     string
+	kind     declKind
+	position lexer.Position
+	// Значение задано явно, а не подставлено парсером
+	initialized bool
+	used        bool
+	assigned    bool
+	// Объявление с тем же именем во внешней области
+	shadows *declaration
+}
+
+/*
+Присваивание имени: =, составное присваивание, ++ или --. decl пуст,
+если имя не объявлено.
+*/
+type assignment struct {
+	target ast.Identifier
+	decl   *declaration
+}
+
+/*
+Факты о программе, которые проверяют правила. Области видимости
+повторяют resolver: программа, блок, ветка if и тело функции вместе
+с параметрами.
+*/
+type analysis struct {
+	source       string
+	declarations []*declaration
+	assignments  []assignment
+	// Первая инструкция блока после вызова exit()
+	unreachable []ast.Stmt
+	comparisons []ast.BinaryExpr
+	// Имена, которые не объявлены ни в программе, ни среди встроенных
+	undeclared []ast.Identifier
+	// Инструкции с -, ++ или -- в начале строки, отделённые от
+	// выражения на предыдущей строке
+	splitLines []ast.ExprStmt
+}
+
+type scope struct {
+	names  map[string]*declaration
+	parent *scope
+}
+
+func (s *scope) lookup(name string) *declaration {
+	for ; s != nil; s = s.parent {
+		if decl, exists := s.names[name]; exists {
+			return decl
+		}
+	}
+	return nil
+}
+
+func enter(parent *scope) *scope {
+	return &scope{
+		names:  make(map[string]*declaration),
+		parent: parent,
+	}
+}
+
+func analyze(program ast.Program, source string, globals []string) *analysis {
+	a := &analysis{source: source}
+
+	// Встроенные функции лежат уровнем выше программы, чтобы
+	// объявление с тем же именем считалось перекрытием
+	builtins := enter(nil)
+	for _, name := range globals {
+		builtins.names[name] = &declaration{
+			name: name,
+			kind: builtinDecl,
+		}
+	}
+	a.body(program.Body, enter(builtins))
+
+	return a
+}
+
+func (a *analysis) declare(s *scope, decl *declaration) {
+	decl.shadows = s.parent.lookup(decl.name)
+	s.names[decl.name] = decl
+	a.declarations = append(a.declarations, decl)
+}
+
+/*
+Как и resolver, регистрирует объявления блока заранее: функции могут
+ссылаться на имена, объявленные после них.
+*/
+func (a *analysis) hoist(body []ast.Stmt, s *scope) {
+	for _, node := range body {
+		switch stmt := node.(type) {
+		case ast.VarDeclStmt:
+			kind := variableDecl
+			if stmt.IsConstant {
+				kind = constantDecl
+			}
+			a.declare(s, &declaration{
+				name:        stmt.Name,
+				kind:        kind,
+				position:    stmt.Position,
+				initialized: !a.implicitUndefined(stmt.Value),
+			})
+		case ast.FunDeclStmt:
+			a.declare(s, &declaration{
+				name:     stmt.Name,
+				kind:     functionDecl,
+				position: stmt.Position,
+			})
+		case ast.TypeAliasDecl:
+			a.declare(s, &declaration{
+				name:     stmt.Name,
+				kind:     typeDecl,
+				position: stmt.Position,
+			})
+		}
+	}
+}
+
+/*
+Парсер подставляет undefined в объявление без значения, указывая на
+имя переменной.
+*/
+func (a *analysis) implicitUndefined(value ast.Expr) bool {
+	if _, ok := value.(ast.UndefinedLiteral); !ok {
+		return false
+	}
+	pos := value.Pos()
+	return a.source[pos.StartPos:pos.EndPos] != "undefined"
+}
+
+/*
+Обходит тело в области s. Возвращает true, если тело всегда
+завершается вызовом exit().
+*/
+func (a *analysis) body(body []ast.Stmt, s *scope) bool {
+	a.hoist(body, s)
+
+	exits, reported := false, false
+	for i, stmt := range body {
+		// Недостижимый код всё равно обходится: имена в нём используются
+		if exits && !reported {
+			a.unreachable = append(a.unreachable, stmt)
+			reported = true
+		}
+		if i > 0 {
+			if split, ok := a.splitLine(body[i-1], stmt); ok {
+				a.splitLines = append(a.splitLines, split)
+			}
+		}
+		if a.stmt(stmt, s) {
+			exits = true
+		}
+	}
+	return exits
+}
+
+/*
+Перевод строки завершает выражение, если следующая строка может
+начать новое. Поэтому a\n- 1 - это a и отдельное -1, а не a - 1,
+хотя a\n+ 1 продолжает выражение.
+*/
+func (a *analysis) splitLine(prev ast.Stmt, node ast.Stmt) (ast.ExprStmt, bool) {
+	stmt, ok := node.(ast.ExprStmt)
+	if !ok {
+		return stmt, false
+	}
+	switch prev := prev.(type) {
+	case ast.ExprStmt:
+	case ast.VarDeclStmt:
+		if a.implicitUndefined(prev.Value) {
+			return stmt, false
+		}
+	default:
+		return stmt, false
+	}
+
+	end, start := prev.Pos().EndPos, stmt.Position.StartPos
+	text := a.source[start:stmt.Position.EndPos]
+	if !strings.HasPrefix(text, "-") && !strings.HasPrefix(text, "++") {
+		return stmt, false
+	}
+	if strings.HasSuffix(a.source[:end], ";") {
+		return stmt, false
+	}
+	// Между инструкциями только пробелы и комментарии, без ';'
+	tokens, errs := lexer.Tokenize(a.source[end:start])
+	if len(errs) > 0 {
+		return stmt, false
+	}
+	for _, token := range tokens {
+		if token.Kind != lexer.EOF {
+			return stmt, false
+		}
+	}
+	return stmt, true
+}
+
+func (a *analysis) stmt(node ast.Stmt, s *scope) bool {
+	switch stmt := node.(type) {
+	case ast.BlockStmt:
+		return a.body(stmt.Body, enter(s))
+	case ast.VarDeclStmt:
+		a.expr(stmt.Value, s, true)
+	case ast.FunDeclStmt:
+		fs := enter(s)
+		for _, param := range stmt.Params {
+			a.declare(fs, &declaration{
+				name:     param.Name,
+				kind:     paramDecl,
+				position: stmt.Position,
+			})
+		}
+		a.body(stmt.Body, fs)
+	case ast.IfStmt:
+		a.expr(stmt.Condition, s, true)
+		consequent := a.body(stmt.Consequent, enter(s))
+		if stmt.Alternate == nil {
+			return false
+		}
+		alternate := a.body(stmt.Alternate, enter(s))
+		return consequent && alternate
+	case ast.ExprStmt:
+		a.expr(stmt.Expr, s, false)
+		return a.exits(stmt.Expr, s)
+	}
+	return false
+}
+
+/*
+Вызов встроенной exit, которую не перекрыло объявление программы.
+*/
+func (a *analysis) exits(expr ast.Expr, s *scope) bool {
+	call, ok := expr.(ast.CallExpr)
+	if !ok || call.Optional {
+		return false
+	}
+	caller, ok := call.Caller.(ast.Identifier)
+	if !ok || caller.Name != "exit" {
+		return false
+	}
+	decl := s.lookup(caller.Name)
+	return decl != nil && decl.kind == builtinDecl
+}
+
+/*
+Обходит выражение. used - значение выражения кому-то нужно: x = 1 как
+инструкция не читает x, а print(x = 1) читает.
+*/
+func (a *analysis) expr(node ast.Expr, s *scope, used bool) {
+	switch expr := node.(type) {
+	case ast.Identifier:
+		if decl := s.lookup(expr.Name); decl != nil {
+			decl.used = true
+		} else {
+			a.undeclared = append(a.undeclared, expr)
+		}
+	case ast.AssignExpr:
+		a.assign(expr.Assigne, s, used)
+		a.expr(expr.Expr, s, true)
+	case ast.UnaryExpr:
+		if expr.Op.Kind == lexer.PLUS_PLUS || expr.Op.Kind == lexer.MINUS_MINUS {
+			a.assign(expr.Expr, s, used)
+			return
+		}
+		a.expr(expr.Expr, s, true)
+	case ast.BinaryExpr:
+		switch expr.Op.Kind {
+		case lexer.EQUALS, lexer.NOT_EQUALS, lexer.LESS, lexer.LESS_EQUALS, lexer.GREATER, lexer.GREATER_EQUALS:
+			a.comparisons = append(a.comparisons, expr)
+		}
+		a.expr(expr.Left, s, true)
+		a.expr(expr.Right, s, true)
+	case ast.CallExpr:
+		a.expr(expr.Caller, s, true)
+		for _, arg := range expr.Args {
+			a.expr(arg, s, true)
+		}
+	case ast.ConditionalExpr:
+		a.expr(expr.Condition, s, true)
+		a.expr(expr.Consequent, s, used)
+		a.expr(expr.Alternate, s, used)
+	case ast.TemplateExpr:
+		for _, part := range expr.Parts {
+			a.expr(part, s, true)
+		}
+	}
+}
+
+func (a *analysis) assign(target ast.Expr, s *scope, used bool) {
+	ident, ok := target.(ast.Identifier)
+	if !ok {
+		a.expr(target, s, true)
+		return
+	}
+
+	decl := s.lookup(ident.Name)
+	a.assignments = append(a.assignments, assignment{
+		target: ident,
+		decl:   decl,
+	})
+	if decl == nil {
+		a.undeclared = append(a.undeclared, ident)
+		return
+	}
+	decl.assigned = true
+	decl.used = decl.used || used
+}
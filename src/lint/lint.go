@@ -0,0 +1,246 @@
+package lint
+
+import (
+	"encoding/json"
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"finescript/src/resolver"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Off Severity = iota
+	Info
+	Warning
+	Error
+)
+
+var severityNames = map[Severity]string{
+	Off:     "off",
+	Info:    "info",
+	Warning: "warning",
+	Error:   "error",
+}
+
+func (s Severity) String() string {
+	return severityNames[s]
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func ParseSeverity(name string) (Severity, error) {
+	for severity, severityName := range severityNames {
+		if severityName == name {
+			return severity, nil
+		}
+	}
+	return Off, fmt.Errorf("unknown severity %q, expected off, info, warning or error", name)
+}
+
+/*
+Правило линтера. check получает результат анализа программы и
+сообщает о найденных проблемах через report.
+*/
+type Rule struct {
+	Name string
+	Doc  string
+	// Уровень по умолчанию, его можно переопределить в Config
+	Severity Severity
+	check    func(a *analysis, report func(pos lexer.Position, message string))
+}
+
+type Diagnostic struct {
+	Rule     string
+	Severity Severity
+	Message  string
+	Position lexer.Position
+}
+
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Rule     string   `json:"rule"`
+		Severity Severity `json:"severity"`
+		Message  string   `json:"message"`
+		Line     int      `json:"line"`
+		Column   int      `json:"column"`
+	}{d.Rule, d.Severity, d.Message, d.Position.Line, d.Position.Column})
+}
+
+type Config struct {
+	// Уровни правил, отличные от уровней по умолчанию
+	Severities map[string]Severity
+	// Имена глобального окружения, например встроенные функции
+	Globals []string
+}
+
+/*
+Разбирает настройку правила вида name=severity.
+*/
+func (c *Config) Set(setting string) error {
+	name, level, ok := strings.Cut(setting, "=")
+	if !ok {
+		return fmt.Errorf("expected rule=severity but got %q", setting)
+	}
+	if _, exists := findRule(name); !exists {
+		return fmt.Errorf("unknown rule %q", name)
+	}
+	severity, err := ParseSeverity(level)
+	if err != nil {
+		return err
+	}
+	if c.Severities == nil {
+		c.Severities = make(map[string]Severity)
+	}
+	c.Severities[name] = severity
+	return nil
+}
+
+func findRule(name string) (Rule, bool) {
+	for _, rule := range Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+/*
+Проверяет исходный код всеми включёнными правилами. Код с ошибками
+лексера, парсера или resolver не проверяется: возвращаются ошибки.
+
+Диагностики можно подавить комментарием:
+
+	x = 1 // lint:ignore const-assign
+	// lint:ignore unused-variable, shadowing
+	let y = 2
+	// lint:file-ignore prefer-const
+
+Первые два вида действуют на свою строку или на следующую, если стоят
+отдельно, последний - на весь файл. Вместо имени правила можно указать
+all.
+*/
+func Lint(source string, config Config) ([]Diagnostic, []lexer.Error) {
+	tokens, errs := lexer.TokenizeTriviaErrors(source)
+	if len(errs) > 0 {
+		return []Diagnostic{}, errs
+	}
+	program, errs := parser.ParseErrors(tokens, source)
+	if len(errs) > 0 {
+		return []Diagnostic{}, errs
+	}
+	if _, errs := resolver.ResolveErrors(program, source, config.Globals); len(errs) > 0 {
+		return []Diagnostic{}, errs
+	}
+
+	a := analyze(program, source, config.Globals)
+	suppressed := suppressions(program.Comments)
+
+	diagnostics := make([]Diagnostic, 0)
+	for _, rule := range Rules {
+		severity := rule.Severity
+		if configured, exists := config.Severities[rule.Name]; exists {
+			severity = configured
+		}
+		if severity == Off {
+			continue
+		}
+		rule.check(a, func(pos lexer.Position, message string) {
+			if suppressed.has(rule.Name, pos.Line) {
+				return
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				Rule:     rule.Name,
+				Severity: severity,
+				Message:  message,
+				Position: pos,
+			})
+		})
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Position.StartPos < diagnostics[j].Position.StartPos
+	})
+	return diagnostics, nil
+}
+
+/*
+Подавленные правила по строкам. Строка 0 - весь файл.
+*/
+type suppressionSet map[int]map[string]bool
+
+func (s suppressionSet) has(rule string, line int) bool {
+	for _, l := range []int{0, line} {
+		if s[l][rule] || s[l]["all"] {
+			return true
+		}
+	}
+	return false
+}
+
+func suppressions(comments []ast.Comment) suppressionSet {
+	set := suppressionSet{}
+	for _, comment := range comments {
+		text := strings.TrimPrefix(comment.Text, "//")
+		if comment.IsBlock() {
+			text = strings.TrimSuffix(strings.TrimPrefix(comment.Text, "/*"), "*/")
+		}
+		directive, rules, _ := strings.Cut(strings.TrimSpace(text), " ")
+
+		line := comment.EndLine() + 1
+		switch {
+		case directive == "lint:file-ignore":
+			line = 0
+		case directive != "lint:ignore":
+			continue
+		case comment.Trailing:
+			line = comment.Position.Line
+		}
+
+		if set[line] == nil {
+			set[line] = make(map[string]bool)
+		}
+		for _, rule := range strings.Split(rules, ",") {
+			if rule = strings.TrimSpace(rule); rule != "" {
+				set[line][rule] = true
+			}
+		}
+	}
+	return set
+}
+
+/*
+Диагностики одного файла или ошибки, из-за которых он не проверялся.
+*/
+type Report struct {
+	File        string       `json:"file"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	Errors      []string     `json:"errors,omitempty"`
+}
+
+/*
+Одна строка на диагностику: file:line:column: severity: message (rule).
+*/
+func WriteText(w io.Writer, reports []Report) {
+	for _, report := range reports {
+		for _, err := range report.Errors {
+			fmt.Fprintf(w, "%s: %s\n", report.File, err)
+		}
+		for _, d := range report.Diagnostics {
+			fmt.Fprintf(w, "%s:%s: %s: %s (%s)\n", report.File, d.Position.String(), d.Severity, d.Message, d.Rule)
+		}
+	}
+}
+
+func WriteJSON(w io.Writer, reports []Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(reports)
+}
@@ -0,0 +1,76 @@
+package lint
+
+import (
+	"finescript/src/lexer"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func diagnostics(t *testing.T, source string) []string {
+	t.Helper()
+	found, errs := Lint(source, Config{Globals: []string{"println", "eval"}})
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(lexer.Messages(errs), "\n"))
+	}
+	printed := make([]string, len(found))
+	for i, d := range found {
+		printed[i] = fmt.Sprintf("%d:%d %s: %s", d.Position.Line, d.Position.Column, d.Rule, d.Message)
+	}
+	return printed
+}
+
+func TestUndeclaredName(t *testing.T) {
+	got := diagnostics(t, "eval(\"var k = 1\")\nprintln(k)\nmissing = 2")
+	want := []string{
+		`2:9 undeclared-name: "k" is not declared`,
+		`3:1 undeclared-name: "missing" is not declared`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestConstantComparison(t *testing.T) {
+	got := diagnostics(t, "println(1 == 6)\nprintln(\"x\" != \"x\")\nprintln(1 == 1.0)")
+	want := []string{
+		`1:9 constant-comparison: Comparison is always false`,
+		`2:9 constant-comparison: Comparison is always false`,
+		`3:9 constant-comparison: Comparison is always true`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+/*
+Диагностики одного правила.
+*/
+func ruleDiagnostics(t *testing.T, rule string, source string) []string {
+	t.Helper()
+	found := make([]string, 0)
+	for _, d := range diagnostics(t, source) {
+		if strings.Contains(d, " "+rule+": ") {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+func TestSplitLine(t *testing.T) {
+	got := ruleDiagnostics(t, "split-line", "var a = 1\nvar b = a\n- 1\nb = a\n  ++b\nprintln(a); -1\nprintln(a)\n// c\n-b")
+	want := []string{
+		`3:1 split-line: Line starting with - is a separate statement, not a continuation of the previous line; move - to the end of the previous line or wrap the expression in parentheses`,
+		`5:3 split-line: Line starting with ++ is a separate statement, not a continuation of the previous line; move ++ to the end of the previous line or wrap the expression in parentheses`,
+		`9:1 split-line: Line starting with - is a separate statement, not a continuation of the previous line; move - to the end of the previous line or wrap the expression in parentheses`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, source := range []string{"var a = 1\nvar b = a -\n  1", "var a = 1\nvar b = a\n+ 1", "var a\n-1", "if true {}\n-1", "var a = 1;\n-a"} {
+		if got := ruleDiagnostics(t, "split-line", source); len(got) > 0 {
+			t.Errorf("%q: unexpected %q", source, got)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package lint
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/runtime"
+	"fmt"
+	"strings"
+)
+
+var Rules = []Rule{
+	{
+		Name:     "unused-variable",
+		Doc:      "let, var or const whose value is never read",
+		Severity: Warning,
+		check:    checkUnusedVariable,
+	},
+	{
+		Name:     "undeclared-name",
+		Doc:      "name that is neither declared nor a builtin in a scope that calls eval; it fails at runtime unless eval declares it first",
+		Severity: Warning,
+		check:    checkUndeclaredName,
+	},
+	{
+		Name:     "shadowing",
+		Doc:      "declaration that hides a name from an enclosing scope or a builtin",
+		Severity: Warning,
+		check:    checkShadowing,
+	},
+	{
+		Name:     "const-assign",
+		Doc:      "assignment to a const, function, type or builtin, which fails at runtime",
+		Severity: Error,
+		check:    checkConstAssign,
+	},
+	{
+		Name:     "unreachable-code",
+		Doc:      "statements after a call to exit()",
+		Severity: Warning,
+		check:    checkUnreachableCode,
+	},
+	{
+		Name:     "constant-comparison",
+		Doc:      "comparison of literals or of a name with itself, which always has the same result",
+		Severity: Warning,
+		check:    checkConstantComparison,
+	},
+	{
+		Name:     "split-line",
+		Doc:      "line starting with -, ++ or -- that begins a new statement instead of continuing the expression on the previous line",
+		Severity: Warning,
+		check:    checkSplitLine,
+	},
+	{
+		Name:     "prefer-const",
+		Doc:      "let or var that is never reassigned and could be const",
+		Severity: Info,
+		check:    checkPreferConst,
+	},
+}
+
+var declKindNames = map[declKind]string{
+	variableDecl: "variable",
+	constantDecl: "constant",
+	functionDecl: "function",
+	paramDecl:    "parameter",
+	typeDecl:     "type",
+	builtinDecl:  "builtin",
+}
+
+func checkUnusedVariable(a *analysis, report func(pos lexer.Position, message string)) {
+	for _, decl := range a.declarations {
+		if decl.kind != variableDecl && decl.kind != constantDecl {
+			continue
+		}
+		// Имена с _ в начале не используются намеренно
+		if !decl.used && !strings.HasPrefix(decl.name, "_") {
+			report(decl.position, fmt.Sprintf("Unused %s \"%s\"", declKindNames[decl.kind], decl.name))
+		}
+	}
+}
+
+func checkShadowing(a *analysis, report func(pos lexer.Position, message string)) {
+	for _, decl := range a.declarations {
+		switch {
+		case decl.shadows == nil:
+		case decl.shadows.kind == builtinDecl:
+			report(decl.position, fmt.Sprintf("Declaration of \"%s\" shadows a builtin function", decl.name))
+		default:
+			report(decl.position, fmt.Sprintf("Declaration of \"%s\" shadows the %s declared at %s", decl.name, declKindNames[decl.shadows.kind], decl.shadows.position.String()))
+		}
+	}
+}
+
+func checkUndeclaredName(a *analysis, report func(pos lexer.Position, message string)) {
+	for _, ident := range a.undeclared {
+		report(ident.Position, fmt.Sprintf("\"%s\" is not declared", ident.Name))
+	}
+}
+
+func checkConstAssign(a *analysis, report func(pos lexer.Position, message string)) {
+	for _, assignment := range a.assignments {
+		if assignment.decl == nil {
+			continue
+		}
+		switch assignment.decl.kind {
+		case constantDecl, functionDecl, typeDecl, builtinDecl:
+			report(assignment.target.Position, fmt.Sprintf("Cannot assign to %s \"%s\"", declKindNames[assignment.decl.kind], assignment.target.Name))
+		}
+	}
+}
+
+func checkUnreachableCode(a *analysis, report func(pos lexer.Position, message string)) {
+	for _, stmt := range a.unreachable {
+		report(stmt.Pos(), "Unreachable code after exit()")
+	}
+}
+
+func checkSplitLine(a *analysis, report func(pos lexer.Position, message string)) {
+	for _, stmt := range a.splitLines {
+		op := "-"
+		for _, prefix := range []string{"++", "--"} {
+			if strings.HasPrefix(a.source[stmt.Position.StartPos:], prefix) {
+				op = prefix
+			}
+		}
+		report(stmt.Position, fmt.Sprintf("Line starting with %s is a separate statement, not a continuation of the previous line; move %s to the end of the previous line or wrap the expression in parentheses", op, op))
+	}
+}
+
+func checkConstantComparison(a *analysis, report func(pos lexer.Position, message string)) {
+	for _, comparison := range a.comparisons {
+		if result, ok := constantComparison(comparison); ok {
+			report(comparison.Position, fmt.Sprintf("Comparison is always %v", result))
+		}
+	}
+}
+
+/*
+Результат сравнения, если он не зависит от значений переменных:
+оба операнда литералы или одно и то же имя.
+*/
+func constantComparison(expr ast.BinaryExpr) (result bool, ok bool) {
+	left, leftIdent := expr.Left.(ast.Identifier)
+	right, rightIdent := expr.Right.(ast.Identifier)
+	if leftIdent && rightIdent && left.Name == right.Name {
+		switch expr.Op.Kind {
+		case lexer.EQUALS, lexer.LESS_EQUALS, lexer.GREATER_EQUALS:
+			return true, true
+		default:
+			return false, true
+		}
+	}
+
+	leftVal, leftLiteral := literalValue(expr.Left)
+	rightVal, rightLiteral := literalValue(expr.Right)
+	if !leftLiteral || !rightLiteral {
+		return false, false
+	}
+	// Несравнимые литералы - ошибка выполнения, а не постоянный результат
+	defer func() {
+		if r := recover(); r != nil {
+			result, ok = false, false
+		}
+	}()
+	value, isBool := runtime.BinaryOp(leftVal, rightVal, expr.Op).(runtime.BoolVal)
+	return value.Value, isBool
+}
+
+func literalValue(expr ast.Expr) (runtime.RuntimeVal, bool) {
+	switch lit := expr.(type) {
+	case ast.IntLiteral:
+		return runtime.IntVal{Value: lit.Value}, true
+	case ast.FloatLiteral:
+		return runtime.FloatVal{Value: lit.Value}, true
+	case ast.StringLiteral:
+		return runtime.StringVal{Value: lit.Value}, true
+	case ast.BoolLiteral:
+		return runtime.BoolVal{Value: lit.Value}, true
+	case ast.NullLiteral:
+		return runtime.NullVal{}, true
+	case ast.UndefinedLiteral:
+		return runtime.UndefinedVal{}, true
+	default:
+		return nil, false
+	}
+}
+
+func checkPreferConst(a *analysis, report func(pos lexer.Position, message string)) {
+	for _, decl := range a.declarations {
+		// Неиспользуемую переменную уже отметило unused-variable
+		if decl.kind == variableDecl && decl.initialized && decl.used && !decl.assigned {
+			report(decl.position, fmt.Sprintf("Variable \"%s\" is never reassigned, declare it with const", decl.name))
+		}
+	}
+}
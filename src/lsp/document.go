@@ -0,0 +1,163 @@
+package lsp
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/lint"
+	"finescript/src/parser"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Открытый в редакторе документ и результат его разбора.
+*/
+type document struct {
+	uri     string
+	version int
+	text    string
+	// Смещения начал строк
+	lines   []int
+	program ast.Program
+	// Пуст, если в документе ошибки лексера
+	index       *index
+	diagnostics []Diagnostic
+}
+
+func newDocument(uri string, version int, text string, config lint.Config) *document {
+	d := &document{
+		uri:     uri,
+		version: version,
+		text:    text,
+		lines:   []int{0},
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+
+	// Парсер восстанавливается после ошибок, поэтому навигация работает
+	// и по частично разобранному документу
+	if tokens, errs := lexer.TokenizeTrivia(text); len(errs) == 0 {
+		d.program, _ = parser.Parse(tokens, text)
+		d.index = buildIndex(d.program, tokens, config.Globals)
+	}
+	d.diagnostics = d.check(config)
+
+	return d
+}
+
+/*
+Ошибки лексера, парсера и resolver, а если их нет - замечания линтера.
+*/
+func (d *document) check(config lint.Config) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0)
+	found, errs := lint.Lint(d.text, config)
+	for _, err := range errs {
+		diagnostics = append(diagnostics, d.errorDiagnostic(err))
+	}
+	for _, diagnostic := range found {
+		pos := diagnostic.Position
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.rangeOf(pos.StartPos, pos.EndPos),
+			Severity: lintSeverities[diagnostic.Severity],
+			Code:     diagnostic.Rule,
+			Source:   "finescript-lint",
+			Message:  diagnostic.Message,
+		})
+	}
+	return diagnostics
+}
+
+var lintSeverities = map[lint.Severity]int{
+	lint.Info:    SeverityInformation,
+	lint.Warning: SeverityWarning,
+	lint.Error:   SeverityError,
+}
+
+/*
+Ошибка без позиции, например чтения входа, относится к началу
+документа. Ошибка без длины подсвечивается до конца строки.
+*/
+func (d *document) errorDiagnostic(err lexer.Error) Diagnostic {
+	diagnostic := Diagnostic{
+		Severity: SeverityError,
+		Source:   "finescript",
+		Message:  strings.TrimSpace(err.Message),
+	}
+	if err.Position.Line == 0 {
+		return diagnostic
+	}
+
+	start := min(err.Position.StartPos, len(d.text))
+	end := min(err.Position.EndPos, len(d.text))
+	if end <= start {
+		end = d.lineEnd(start)
+	}
+	diagnostic.Range = d.rangeOf(start, end)
+	return diagnostic
+}
+
+func (d *document) lineEnd(offset int) int {
+	if end := strings.IndexByte(d.text[offset:], '\n'); end >= 0 {
+		return offset + end
+	}
+	return len(d.text)
+}
+
+func (d *document) position(offset int) Position {
+	line := sort.Search(len(d.lines), func(i int) bool {
+		return d.lines[i] > offset
+	}) - 1
+	return Position{
+		Line:      line,
+		Character: utf16Len(d.text[d.lines[line]:offset]),
+	}
+}
+
+/*
+Смещение позиции редактора. Позиция за концом строки указывает на её
+конец.
+*/
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset := d.lines[pos.Line]
+	for units := 0; offset < len(d.text) && d.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		units += utf16RuneLen(r)
+		if units > pos.Character {
+			break
+		}
+		offset += size
+	}
+	return offset
+}
+
+func (d *document) rangeOf(start int, end int) Range {
+	return Range{
+		Start: d.position(start),
+		End:   d.position(end),
+	}
+}
+
+func utf16Len(s string) int {
+	length := 0
+	for _, r := range s {
+		length += utf16RuneLen(r)
+	}
+	return length
+}
+
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
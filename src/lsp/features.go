@@ -0,0 +1,200 @@
+package lsp
+
+import (
+	"finescript/src/ast"
+	"finescript/src/formatter"
+	"strings"
+)
+
+/*
+Объявление имени под курсором в виде кода. Для переменной показывается
+выведенный тип.
+*/
+func (d *document) hover(pos Position) *Hover {
+	if d.index == nil {
+		return nil
+	}
+	ref, ok := d.index.referenceAt(d.offset(pos))
+	if !ok {
+		return nil
+	}
+
+	return &Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: "```finescript\n" + d.signature(ref.symbol) + "\n```",
+		},
+		Range: d.rangeOf(ref.start, ref.end),
+	}
+}
+
+func (d *document) signature(sym *symbol) string {
+	switch sym.kind {
+	case functionSymbol:
+		fun := sym.decl.(ast.FunDeclStmt)
+		return "fun " + sym.name + funSignature(fun.Params, fun.ReturnType)
+	case paramSymbol:
+		return "(parameter) " + sym.name + ": " + formatter.Type(sym.typ)
+	case typeSymbol:
+		return "type " + sym.name + " = " + formatter.Type(sym.typ)
+	case builtinSymbol:
+		return "(builtin) fun " + sym.name
+	}
+
+	keyword := "const"
+	if sym.kind == variableSymbol {
+		keyword = "let"
+		if strings.HasPrefix(d.text[sym.decl.Pos().StartPos:], "var") {
+			keyword = "var"
+		}
+	}
+	return keyword + " " + sym.name + ": " + formatter.Type(symbolType(sym))
+}
+
+/*
+Параметры и тип результата так, как они записываются в объявлении
+функции. Тип void не печатается.
+*/
+func funSignature(params []ast.Param, returnType ast.Type) string {
+	printed := make([]string, len(params))
+	for i, param := range params {
+		printed[i] = param.Name + ": " + formatter.Type(param.Type)
+	}
+	signature := "(" + strings.Join(printed, ", ") + ")"
+	if _, ok := returnType.(ast.VoidKeyword); !ok && returnType != nil {
+		signature += ": " + formatter.Type(returnType)
+	}
+	return signature
+}
+
+/*
+Место объявления имени под курсором. У встроенных функций его нет.
+*/
+func (d *document) definition(pos Position) *Location {
+	if d.index == nil {
+		return nil
+	}
+	ref, ok := d.index.referenceAt(d.offset(pos))
+	if !ok || ref.symbol.start < 0 {
+		return nil
+	}
+	return &Location{
+		URI:   d.uri,
+		Range: d.rangeOf(ref.symbol.start, ref.symbol.end),
+	}
+}
+
+func (d *document) references(pos Position, includeDeclaration bool) []Location {
+	locations := make([]Location, 0)
+	if d.index == nil {
+		return locations
+	}
+	ref, ok := d.index.referenceAt(d.offset(pos))
+	if !ok {
+		return locations
+	}
+
+	for _, found := range d.index.referencesTo(ref.symbol) {
+		if !includeDeclaration && found.start == ref.symbol.start {
+			continue
+		}
+		locations = append(locations, Location{
+			URI:   d.uri,
+			Range: d.rangeOf(found.start, found.end),
+		})
+	}
+	return locations
+}
+
+/*
+Функции и псевдонимы типов. Объявления внутри функции вложены в неё.
+*/
+func (d *document) symbols() []DocumentSymbol {
+	if d.index == nil {
+		return []DocumentSymbol{}
+	}
+	return d.declSymbols(d.program.Body)
+}
+
+func (d *document) declSymbols(body []ast.Stmt) []DocumentSymbol {
+	symbols := make([]DocumentSymbol, 0)
+	for _, node := range body {
+		switch stmt := node.(type) {
+		case ast.FunDeclStmt:
+			symbol := d.documentSymbol(stmt.Name, stmt.Position.StartPos, stmt.Position.EndPos)
+			symbol.Kind = SymbolKindFunction
+			symbol.Detail = funSignature(stmt.Params, stmt.ReturnType)
+			symbol.Children = d.declSymbols(stmt.Body)
+			symbols = append(symbols, symbol)
+		case ast.TypeAliasDecl:
+			symbol := d.documentSymbol(stmt.Name, stmt.Position.StartPos, stmt.Position.EndPos)
+			symbol.Kind = SymbolKindTypeParameter
+			symbol.Detail = formatter.Type(stmt.Type)
+			if _, ok := stmt.Type.(ast.Struct); ok {
+				symbol.Detail = "struct"
+			}
+			symbols = append(symbols, symbol)
+		case ast.BlockStmt:
+			symbols = append(symbols, d.declSymbols(stmt.Body)...)
+		case ast.IfStmt:
+			symbols = append(symbols, d.declSymbols(stmt.Consequent)...)
+			symbols = append(symbols, d.declSymbols(stmt.Alternate)...)
+		}
+	}
+	return symbols
+}
+
+func (d *document) documentSymbol(name string, start int, end int) DocumentSymbol {
+	nameStart, nameEnd := d.index.nameAfter(name, start)
+	if nameStart < 0 {
+		nameStart, nameEnd = start, start
+	}
+	return DocumentSymbol{
+		Name:           name,
+		Range:          d.rangeOf(start, end),
+		SelectionRange: d.rangeOf(nameStart, nameEnd),
+	}
+}
+
+var completionKinds = map[symbolKind]int{
+	variableSymbol: CompletionItemKindVariable,
+	constantSymbol: CompletionItemKindConstant,
+	functionSymbol: CompletionItemKindFunction,
+	paramSymbol:    CompletionItemKindVariable,
+	typeSymbol:     CompletionItemKindTypeParameter,
+	builtinSymbol:  CompletionItemKindFunction,
+}
+
+/*
+Имена, видимые под курсором: объявления документа и встроенные
+функции глобального окружения.
+*/
+func (d *document) completion(pos Position) []CompletionItem {
+	items := make([]CompletionItem, 0)
+	if d.index == nil {
+		return items
+	}
+	for _, sym := range d.index.visible(d.offset(pos)) {
+		items = append(items, CompletionItem{
+			Label:  sym.name,
+			Kind:   completionKinds[sym.kind],
+			Detail: d.signature(sym),
+		})
+	}
+	return items
+}
+
+/*
+Весь документ заменяется отформатированным текстом. Документ с ошибками
+не форматируется.
+*/
+func (d *document) format() []TextEdit {
+	formatted, errs := formatter.Format(d.text)
+	if len(errs) > 0 || formatted == d.text {
+		return []TextEdit{}
+	}
+	return []TextEdit{{
+		Range:   d.rangeOf(0, len(d.text)),
+		NewText: formatted,
+	}}
+}
@@ -0,0 +1,364 @@
+package lsp
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"sort"
+)
+
+type symbolKind int
+
+const (
+	variableSymbol symbolKind = iota // let и var
+	constantSymbol
+	functionSymbol
+	paramSymbol
+	typeSymbol
+	builtinSymbol
+)
+
+/*
+Объявленное имя. У встроенных функций нет места в документе: start
+и end равны -1.
+*/
+type symbol struct {
+	name       string
+	kind       symbolKind
+	start, end int
+	// Объявление: VarDeclStmt, FunDeclStmt или TypeAliasDecl. Для
+	// параметра - функция, которой он принадлежит
+	decl ast.Stmt
+	// Тип параметра или выведенный тип переменной
+	typ ast.Type
+}
+
+/*
+Упоминание имени в документе, включая само объявление.
+*/
+type reference struct {
+	start, end int
+	symbol     *symbol
+}
+
+/*
+Область видимости и объявленные в ней имена. Диапазон нужен
+автодополнению, чтобы знать, какие имена видны в точке документа.
+*/
+type scope struct {
+	start, end int
+	names      map[string]*symbol
+	parent     *scope
+}
+
+func (s *scope) lookup(name string) *symbol {
+	for ; s != nil; s = s.parent {
+		if sym, exists := s.names[name]; exists {
+			return sym
+		}
+	}
+	return nil
+}
+
+/*
+Имена документа и их упоминания. Области видимости повторяют resolver:
+программа, блок, ветка if и тело функции вместе с параметрами.
+*/
+type index struct {
+	tokens     []lexer.Token
+	symbols    []*symbol
+	references []reference
+	scopes     []*scope
+}
+
+func buildIndex(program ast.Program, tokens []lexer.Token, globals []string) *index {
+	ix := &index{tokens: tokens}
+
+	builtins := &scope{
+		start: -1,
+		end:   -1,
+		names: make(map[string]*symbol),
+	}
+	for _, name := range globals {
+		builtins.names[name] = &symbol{
+			name:  name,
+			kind:  builtinSymbol,
+			start: -1,
+			end:   -1,
+		}
+	}
+
+	end := 0
+	if len(tokens) > 0 {
+		end = tokens[len(tokens)-1].Position.EndPos
+	}
+	ix.body(program.Body, ix.enter(builtins, 0, end))
+
+	sort.SliceStable(ix.references, func(i, j int) bool {
+		return ix.references[i].start < ix.references[j].start
+	})
+	return ix
+}
+
+func (ix *index) enter(parent *scope, start int, end int) *scope {
+	s := &scope{
+		start:  start,
+		end:    end,
+		names:  make(map[string]*symbol),
+		parent: parent,
+	}
+	ix.scopes = append(ix.scopes, s)
+	return s
+}
+
+/*
+Регистрирует объявление и его имя как первое упоминание. from -
+смещение, с которого в токенах ищется имя.
+*/
+func (ix *index) declare(s *scope, sym *symbol, from int) {
+	sym.start, sym.end = ix.nameAfter(sym.name, from)
+	s.names[sym.name] = sym
+	ix.symbols = append(ix.symbols, sym)
+	if sym.start >= 0 {
+		ix.references = append(ix.references, reference{
+			start:  sym.start,
+			end:    sym.end,
+			symbol: sym,
+		})
+	}
+}
+
+/*
+Границы первого идентификатора name не раньше from. Позиции в AST
+указывают на начало объявления, а не на имя.
+*/
+func (ix *index) nameAfter(name string, from int) (int, int) {
+	i := sort.Search(len(ix.tokens), func(i int) bool {
+		return ix.tokens[i].Position.StartPos >= from
+	})
+	for ; i < len(ix.tokens); i++ {
+		token := ix.tokens[i]
+		if token.Kind == lexer.IDENTIFIER && token.Value == name {
+			return token.Position.StartPos, token.Position.EndPos
+		}
+	}
+	return -1, -1
+}
+
+func (ix *index) use(s *scope, name string, pos lexer.Position) {
+	if sym := s.lookup(name); sym != nil {
+		ix.references = append(ix.references, reference{
+			start:  pos.StartPos,
+			end:    pos.EndPos,
+			symbol: sym,
+		})
+	}
+}
+
+/*
+Как и resolver, регистрирует объявления заранее: функции могут
+ссылаться на имена, объявленные после них.
+*/
+func (ix *index) hoist(body []ast.Stmt, s *scope) {
+	for _, node := range body {
+		switch stmt := node.(type) {
+		case ast.VarDeclStmt:
+			kind := variableSymbol
+			if stmt.IsConstant {
+				kind = constantSymbol
+			}
+			ix.declare(s, &symbol{
+				name: stmt.Name,
+				kind: kind,
+				decl: stmt,
+			}, stmt.Position.StartPos)
+		case ast.FunDeclStmt:
+			ix.declare(s, &symbol{
+				name: stmt.Name,
+				kind: functionSymbol,
+				decl: stmt,
+			}, stmt.Position.StartPos)
+		case ast.TypeAliasDecl:
+			ix.declare(s, &symbol{
+				name: stmt.Name,
+				kind: typeSymbol,
+				decl: stmt,
+				typ:  stmt.Type,
+			}, stmt.Position.StartPos)
+		}
+	}
+}
+
+func (ix *index) body(body []ast.Stmt, s *scope) {
+	ix.hoist(body, s)
+	for _, stmt := range body {
+		ix.stmt(stmt, s)
+	}
+}
+
+func (ix *index) stmt(node ast.Stmt, s *scope) {
+	switch stmt := node.(type) {
+	case ast.BlockStmt:
+		ix.body(stmt.Body, ix.enter(s, stmt.Position.StartPos, stmt.Position.EndPos))
+	case ast.VarDeclStmt:
+		ix.expr(stmt.Value, s)
+		// Тип выводится после обхода значения, когда его имена уже
+		// привязаны
+		if sym := s.names[stmt.Name]; sym != nil && sym.decl.Pos() == stmt.Position {
+			sym.typ = ix.infer(stmt.Value, s)
+		}
+	case ast.FunDeclStmt:
+		fs := ix.enter(s, stmt.Position.StartPos, stmt.Position.EndPos)
+		// Имена параметров ищутся по порядку после имени функции
+		from := stmt.Position.StartPos
+		if sym := s.names[stmt.Name]; sym != nil && sym.end >= 0 {
+			from = sym.end
+		}
+		for _, param := range stmt.Params {
+			sym := &symbol{
+				name: param.Name,
+				kind: paramSymbol,
+				decl: stmt,
+				typ:  param.Type,
+			}
+			ix.declare(fs, sym, from)
+			if sym.end >= 0 {
+				from = sym.end
+			}
+			ix.typ(param.Type, s)
+		}
+		ix.typ(stmt.ReturnType, s)
+		ix.body(stmt.Body, fs)
+	case ast.TypeAliasDecl:
+		ix.typ(stmt.Type, s)
+	case ast.IfStmt:
+		ix.expr(stmt.Condition, s)
+		end := stmt.Position.EndPos
+		if len(stmt.Alternate) > 0 {
+			end = stmt.Alternate[0].Pos().StartPos
+		}
+		ix.body(stmt.Consequent, ix.enter(s, stmt.Condition.Pos().EndPos, end))
+		ix.body(stmt.Alternate, ix.enter(s, end, stmt.Position.EndPos))
+	case ast.ExprStmt:
+		ix.expr(stmt.Expr, s)
+	}
+}
+
+func (ix *index) expr(node ast.Expr, s *scope) {
+	switch expr := node.(type) {
+	case ast.Identifier:
+		ix.use(s, expr.Name, expr.Position)
+	case ast.UnaryExpr:
+		ix.expr(expr.Expr, s)
+	case ast.BinaryExpr:
+		ix.expr(expr.Left, s)
+		ix.expr(expr.Right, s)
+	case ast.AssignExpr:
+		ix.expr(expr.Assigne, s)
+		ix.expr(expr.Expr, s)
+	case ast.CallExpr:
+		ix.expr(expr.Caller, s)
+		for _, arg := range expr.Args {
+			ix.expr(arg, s)
+		}
+	case ast.ConditionalExpr:
+		ix.expr(expr.Condition, s)
+		ix.expr(expr.Consequent, s)
+		ix.expr(expr.Alternate, s)
+	case ast.TemplateExpr:
+		for _, part := range expr.Parts {
+			ix.expr(part, s)
+		}
+	}
+}
+
+/*
+Упоминания псевдонимов типов. Типы не проходят через resolver, но
+ищутся по тем же областям видимости.
+*/
+func (ix *index) typ(node ast.Type, s *scope) {
+	switch t := node.(type) {
+	case ast.TypeAlias:
+		ix.use(s, t.Name, t.Position)
+	case ast.ArrayType:
+		ix.typ(t.ElementType, s)
+	case ast.UnionType:
+		for _, inner := range t.Types {
+			ix.typ(inner, s)
+		}
+	case ast.IntersectionType:
+		for _, inner := range t.Types {
+			ix.typ(inner, s)
+		}
+	case ast.FunType:
+		for _, param := range t.Params {
+			ix.typ(param.Type, s)
+		}
+		ix.typ(t.ReturnType, s)
+	case ast.Struct:
+		for _, member := range t.Members {
+			switch m := member.(type) {
+			case ast.PropertySignature:
+				ix.typ(m.Type, s)
+			case ast.MethodSignature:
+				for _, param := range m.Params {
+					ix.typ(param.Type, s)
+				}
+				ix.typ(m.Type, s)
+			}
+		}
+	}
+}
+
+/*
+Упоминание, на котором стоит курсор. Курсор сразу за именем тоже
+считается стоящим на нём.
+*/
+func (ix *index) referenceAt(offset int) (reference, bool) {
+	i := sort.Search(len(ix.references), func(i int) bool {
+		return ix.references[i].end >= offset
+	})
+	if i < len(ix.references) && ix.references[i].start <= offset {
+		return ix.references[i], true
+	}
+	return reference{}, false
+}
+
+func (ix *index) referencesTo(sym *symbol) []reference {
+	found := make([]reference, 0)
+	for _, ref := range ix.references {
+		if ref.symbol == sym {
+			found = append(found, ref)
+		}
+	}
+	return found
+}
+
+/*
+Имена, видимые в точке offset, от ближней области к дальней. Имя,
+перекрытое во внутренней области, не повторяется.
+*/
+func (ix *index) visible(offset int) []*symbol {
+	var innermost *scope
+	for _, s := range ix.scopes {
+		if s.start <= offset && offset <= s.end && (innermost == nil || s.start >= innermost.start) {
+			innermost = s
+		}
+	}
+
+	seen := make(map[string]bool)
+	found := make([]*symbol, 0)
+	for s := innermost; s != nil; s = s.parent {
+		names := make([]string, 0, len(s.names))
+		for name := range s.names {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				found = append(found, s.names[name])
+			}
+		}
+	}
+	return found
+}
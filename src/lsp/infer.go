@@ -0,0 +1,178 @@
+package lsp
+
+import (
+	"finescript/src/ast"
+	"finescript/src/formatter"
+	"finescript/src/lexer"
+	"finescript/src/runtime"
+)
+
+/*
+Выводит тип выражения без выполнения программы. Результат операторов
+над простыми типами берётся у самого интерпретатора: оператор
+применяется к образцам значений этих типов. Если тип не удаётся
+вывести, результат - any.
+*/
+func (ix *index) infer(node ast.Expr, s *scope) ast.Type {
+	switch expr := node.(type) {
+	case ast.IntLiteral:
+		return ast.IntKeyword{}
+	case ast.FloatLiteral:
+		return ast.FloatKeyword{}
+	case ast.StringLiteral, ast.TemplateExpr:
+		return ast.StringKeyword{}
+	case ast.BoolLiteral:
+		return ast.BoolKeyword{}
+	case ast.NullLiteral:
+		return ast.NullKeyword{}
+	case ast.UndefinedLiteral:
+		return ast.UndefinedKeyword{}
+	case ast.Identifier:
+		if sym := s.lookup(expr.Name); sym != nil {
+			return symbolType(sym)
+		}
+	case ast.AssignExpr:
+		return ix.infer(expr.Expr, s)
+	case ast.UnaryExpr:
+		return operatorType(func(samples []runtime.RuntimeVal) runtime.RuntimeVal {
+			return runtime.UnaryOp(samples[0], expr.Op)
+		}, ix.infer(expr.Expr, s))
+	case ast.BinaryExpr:
+		left, right := ix.infer(expr.Left, s), ix.infer(expr.Right, s)
+		if expr.Op.Kind == lexer.QUESTION_QUESTION {
+			switch left.(type) {
+			case ast.NullKeyword, ast.UndefinedKeyword:
+				return right
+			}
+			return union(left, right)
+		}
+		return operatorType(func(samples []runtime.RuntimeVal) runtime.RuntimeVal {
+			return runtime.BinaryOp(samples[0], samples[1], expr.Op)
+		}, left, right)
+	case ast.ConditionalExpr:
+		return union(ix.infer(expr.Consequent, s), ix.infer(expr.Alternate, s))
+	case ast.CallExpr:
+		if fun, ok := ix.infer(expr.Caller, s).(ast.FunType); ok {
+			if expr.Optional {
+				return union(fun.ReturnType, ast.UndefinedKeyword{})
+			}
+			return fun.ReturnType
+		}
+	}
+	return ast.AnyKeyword{}
+}
+
+func symbolType(sym *symbol) ast.Type {
+	switch sym.kind {
+	case functionSymbol:
+		fun := sym.decl.(ast.FunDeclStmt)
+		return ast.FunType{
+			Params:     fun.Params,
+			ReturnType: fun.ReturnType,
+		}
+	case builtinSymbol:
+		return ast.FunKeyword{}
+	case typeSymbol:
+		return ast.TypeAlias{Name: sym.name}
+	}
+	if sym.typ == nil {
+		return ast.AnyKeyword{}
+	}
+	return sym.typ
+}
+
+/*
+Тип результата оператора над значениями типов operands. Оператор,
+который интерпретатор отклоняет для этих типов, даёт any.
+*/
+func operatorType(apply func(samples []runtime.RuntimeVal) runtime.RuntimeVal, operands ...ast.Type) (result ast.Type) {
+	samples := make([]runtime.RuntimeVal, len(operands))
+	for i, operand := range operands {
+		sample, ok := sampleOf(operand)
+		if !ok {
+			return ast.AnyKeyword{}
+		}
+		samples[i] = sample
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = ast.AnyKeyword{}
+		}
+	}()
+	return typeOf(apply(samples))
+}
+
+/*
+Значение простого типа. Числа ненулевые, чтобы деление было допустимо.
+*/
+func sampleOf(t ast.Type) (runtime.RuntimeVal, bool) {
+	switch t.(type) {
+	case ast.IntKeyword:
+		return runtime.IntVal{Value: 1}, true
+	case ast.FloatKeyword:
+		return runtime.FloatVal{Value: 1}, true
+	case ast.StringKeyword:
+		return runtime.StringVal{Value: "s"}, true
+	case ast.BoolKeyword:
+		return runtime.BoolVal{Value: true}, true
+	case ast.NullKeyword:
+		return runtime.NullVal{}, true
+	case ast.UndefinedKeyword:
+		return runtime.UndefinedVal{}, true
+	}
+	return nil, false
+}
+
+func typeOf(val runtime.RuntimeVal) ast.Type {
+	switch val.(type) {
+	case runtime.IntVal:
+		return ast.IntKeyword{}
+	case runtime.FloatVal:
+		return ast.FloatKeyword{}
+	case runtime.StringVal:
+		return ast.StringKeyword{}
+	case runtime.BoolVal:
+		return ast.BoolKeyword{}
+	case runtime.NullVal:
+		return ast.NullKeyword{}
+	case runtime.UndefinedVal:
+		return ast.UndefinedKeyword{}
+	}
+	return ast.AnyKeyword{}
+}
+
+/*
+Объединение двух типов. Одинаковые типы не повторяются, any
+поглощает остальные.
+*/
+func union(a ast.Type, b ast.Type) ast.Type {
+	types := make([]ast.Type, 0, 2)
+	for _, t := range []ast.Type{a, b} {
+		if _, ok := t.(ast.AnyKeyword); ok {
+			return t
+		}
+		inner := []ast.Type{t}
+		if u, ok := t.(ast.UnionType); ok {
+			inner = u.Types
+		}
+		for _, candidate := range inner {
+			if !containsType(types, candidate) {
+				types = append(types, candidate)
+			}
+		}
+	}
+	if len(types) == 1 {
+		return types[0]
+	}
+	return ast.UnionType{Types: types}
+}
+
+func containsType(types []ast.Type, t ast.Type) bool {
+	for _, existing := range types {
+		if formatter.Type(existing) == formatter.Type(t) {
+			return true
+		}
+	}
+	return false
+}
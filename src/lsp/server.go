@@ -0,0 +1,250 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"finescript/src/jsonrpc"
+	"finescript/src/lint"
+	"fmt"
+	"io"
+)
+
+/*
+Сервер Language Server Protocol. Запросы обрабатываются по одному в
+порядке поступления, документы синхронизируются целиком.
+*/
+type Server struct {
+	reader    *bufio.Reader
+	writer    io.Writer
+	config    lint.Config
+	documents map[string]*document
+	shutdown  bool
+}
+
+/*
+globals - имена глобального окружения, которые видит программа,
+например встроенные функции.
+*/
+func NewServer(in io.Reader, out io.Writer, globals []string) *Server {
+	return &Server{
+		reader:    bufio.NewReader(in),
+		writer:    out,
+		config:    lint.Config{Globals: globals},
+		documents: make(map[string]*document),
+	}
+}
+
+/*
+Обрабатывает сообщения, пока клиент не пришлёт exit или не закроет
+поток. Ошибка - выход без предшествующего shutdown или сбой ввода-вывода.
+*/
+func (s *Server) Serve() error {
+	for {
+		body, err := jsonrpc.ReadMessage(s.reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit notification received before shutdown")
+			}
+			return nil
+		}
+
+		result, err := s.handle(msg)
+		// На уведомления не отвечают
+		if msg.ID == nil {
+			continue
+		}
+		var replyErr *responseError
+		if err != nil && !errors.As(err, &replyErr) {
+			replyErr = &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		if err := s.reply(msg.ID, result, replyErr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(id *json.RawMessage, result any, replyErr *responseError) error {
+	resp := response{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   replyErr,
+	}
+	if replyErr == nil {
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resp.Result = encoded
+	}
+	return jsonrpc.WriteMessage(s.writer, resp)
+}
+
+func (s *Server) notify(method string, params any) error {
+	return jsonrpc.WriteMessage(s.writer, notification{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+}
+
+func (s *Server) handle(msg message) (any, error) {
+	switch msg.Method {
+	case "initialize":
+		return s.initialize(), nil
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		item := params.TextDocument
+		return nil, s.open(item.URI, item.Version, item.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		// При полной синхронизации последнее изменение содержит весь текст
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, s.open(params.TextDocument.URI, params.TextDocument.Version, text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		// Диагностики закрытого документа больше не показываются
+		return nil, s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		})
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return d.hover(params.Position), nil
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return d.definition(params.Position), nil
+	case "textDocument/references":
+		var params ReferenceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return d.references(params.Position, params.Context.IncludeDeclaration), nil
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return d.symbols(), nil
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return d.completion(params.Position), nil
+	case "textDocument/formatting":
+		var params DocumentFormattingParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		d, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return d.format(), nil
+	default:
+		return nil, &responseError{
+			Code:    codeMethodNotFound,
+			Message: fmt.Sprintf("method %q is not supported", msg.Method),
+		}
+	}
+}
+
+func (s *Server) initialize() InitializeResult {
+	capabilities := ServerCapabilities{
+		TextDocumentSync:           TextDocumentSyncFull,
+		HoverProvider:              true,
+		DefinitionProvider:         true,
+		ReferencesProvider:         true,
+		DocumentSymbolProvider:     true,
+		DocumentFormattingProvider: true,
+	}
+	capabilities.CompletionProvider.TriggerCharacters = []string{}
+	return InitializeResult{
+		Capabilities: capabilities,
+		ServerInfo:   ServerInfo{Name: "finescript"},
+	}
+}
+
+/*
+Разбирает новый текст документа и публикует его диагностики.
+*/
+func (s *Server) open(uri string, version int, text string) error {
+	d := newDocument(uri, version, text, s.config)
+	s.documents[uri] = d
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     version,
+		Diagnostics: d.diagnostics,
+	})
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	d, exists := s.documents[uri]
+	if !exists {
+		return nil, &responseError{
+			Code:    codeInvalidParams,
+			Message: fmt.Sprintf("document %q is not open", uri),
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,357 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"finescript/src/jsonrpc"
+	"finescript/src/lint"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Клиент JSON-RPC, подключённый к серверу через io.Pipe. Сообщения
+сервера читаются в отдельной горутине: сервер пишет синхронно и иначе
+ждал бы, пока клиент дочитает уведомление.
+*/
+type client struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	messages chan json.RawMessage
+	done     chan error
+	id       int
+}
+
+type serverMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{
+		t:        t,
+		in:       clientOut,
+		messages: make(chan json.RawMessage, 16),
+		done:     make(chan error, 1),
+	}
+
+	server := NewServer(serverIn, serverOut, []string{"println", "print"})
+	go func() {
+		c.done <- server.Serve()
+		serverOut.Close()
+	}()
+	go func() {
+		reader := bufio.NewReader(clientIn)
+		for {
+			body, err := jsonrpc.ReadMessage(reader)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			c.messages <- body
+		}
+	}()
+	t.Cleanup(func() {
+		clientOut.Close()
+	})
+	return c
+}
+
+func (c *client) send(id *int, method string, params any) {
+	c.t.Helper()
+	msg := map[string]any{"jsonrpc": "2.0", "method": method}
+	if id != nil {
+		msg["id"] = *id
+	}
+	if params != nil {
+		msg["params"] = params
+	}
+	if err := jsonrpc.WriteMessage(c.in, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) next() serverMessage {
+	c.t.Helper()
+	select {
+	case body, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("server closed the connection")
+		}
+		var msg serverMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			c.t.Fatal(err)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("no message from server")
+	}
+	return serverMessage{}
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	c.send(nil, method, params)
+}
+
+/*
+Отправляет запрос и разбирает результат ответа в result.
+*/
+func (c *client) request(method string, params any, result any) {
+	c.t.Helper()
+	c.id++
+	id := c.id
+	c.send(&id, method, params)
+
+	msg := c.next()
+	if msg.ID == nil || *msg.ID != id {
+		c.t.Fatalf("%s: expected response %d, got %+v", method, id, msg)
+	}
+	if msg.Error != nil {
+		c.t.Fatalf("%s: %s", method, msg.Error.Message)
+	}
+	if result != nil {
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	c.t.Helper()
+	msg := c.next()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %+v", msg)
+	}
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	return params
+}
+
+func (c *client) open(uri string, text string) PublishDiagnosticsParams {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "finescript", Version: 1, Text: text},
+	})
+	return c.diagnostics()
+}
+
+func (c *client) shutdown() {
+	c.t.Helper()
+	c.request("shutdown", nil, nil)
+	c.notify("exit", nil)
+	select {
+	case err := <-c.done:
+		if err != nil {
+			c.t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("server did not exit")
+	}
+}
+
+const (
+	testURI    = "file:///test.fs"
+	testSource = "fun add(a: int, b: int): int {\n  a + b\n}\nconst total = add(1, 2)\nprintln(total)\nprintln(missing)\n"
+)
+
+func at(line int, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+func TestInitialize(t *testing.T) {
+	c := newClient(t)
+	var result InitializeResult
+	c.request("initialize", map[string]any{"capabilities": map[string]any{}}, &result)
+	c.notify("initialized", map[string]any{})
+
+	capabilities := result.Capabilities
+	if result.ServerInfo.Name != "finescript" || capabilities.TextDocumentSync != TextDocumentSyncFull ||
+		!capabilities.HoverProvider || !capabilities.DefinitionProvider || !capabilities.DocumentFormattingProvider {
+		t.Errorf("unexpected initialize result %+v", result)
+	}
+	c.shutdown()
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{}, nil)
+
+	published := c.open(testURI, testSource)
+	if published.URI != testURI || published.Version != 1 {
+		t.Errorf("diagnostics for %s version %d", published.URI, published.Version)
+	}
+	if len(published.Diagnostics) != 1 {
+		t.Fatalf("diagnostics %+v, want one", published.Diagnostics)
+	}
+	d := published.Diagnostics[0]
+	if d.Severity != SeverityError || d.Message != "Cannot resolve \"missing\" as it does not exist." || d.Range.Start != (Position{Line: 5, Character: 8}) {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: testURI, Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "var x = (1 +\n"}},
+	})
+	published = c.diagnostics()
+	if published.Version != 2 || len(published.Diagnostics) != 1 || published.Diagnostics[0].Severity != SeverityError {
+		t.Errorf("unexpected diagnostics after change %+v", published)
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: testURI}})
+	if published = c.diagnostics(); len(published.Diagnostics) != 0 {
+		t.Errorf("closed document still has diagnostics %+v", published.Diagnostics)
+	}
+	c.shutdown()
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{}, nil)
+	c.open(testURI, testSource)
+
+	var hover Hover
+	c.request("textDocument/hover", at(3, 15), &hover)
+	if !strings.Contains(hover.Contents.Value, "fun add(a: int, b: int): int") {
+		t.Errorf("hover %q", hover.Contents.Value)
+	}
+	if hover.Range != (Range{Start: Position{Line: 3, Character: 14}, End: Position{Line: 3, Character: 17}}) {
+		t.Errorf("hover range %+v", hover.Range)
+	}
+
+	var empty *Hover
+	c.request("textDocument/hover", at(2, 0), &empty)
+	if empty != nil {
+		t.Errorf("hover over '}' = %+v, want null", empty)
+	}
+	c.shutdown()
+}
+
+func TestDefinition(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{}, nil)
+	c.open(testURI, testSource)
+
+	var location Location
+	c.request("textDocument/definition", at(4, 10), &location)
+	want := Location{URI: testURI, Range: Range{Start: Position{Line: 3, Character: 6}, End: Position{Line: 3, Character: 11}}}
+	if location != want {
+		t.Errorf("definition %+v, want %+v", location, want)
+	}
+
+	c.request("textDocument/definition", at(1, 2), &location)
+	want.Range = Range{Start: Position{Line: 0, Character: 8}, End: Position{Line: 0, Character: 9}}
+	if location != want {
+		t.Errorf("parameter definition %+v, want %+v", location, want)
+	}
+	c.shutdown()
+}
+
+func TestCompletion(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{}, nil)
+	c.open(testURI, testSource)
+
+	var items []CompletionItem
+	c.request("textDocument/completion", at(4, 8), &items)
+	labels := make(map[string]bool)
+	for _, item := range items {
+		labels[item.Label] = true
+	}
+	for _, label := range []string{"add", "total", "println"} {
+		if !labels[label] {
+			t.Errorf("completion has no %q: %+v", label, items)
+		}
+	}
+	if labels["a"] {
+		t.Errorf("parameter of add is visible outside it: %+v", items)
+	}
+	c.shutdown()
+}
+
+func TestFormatting(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{}, nil)
+	c.open(testURI, "var   x = 1;\nif x>0 {println(x)}\n")
+
+	params := DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: testURI}}
+	var edits []TextEdit
+	c.request("textDocument/formatting", params, &edits)
+	if len(edits) != 1 {
+		t.Fatalf("edits %+v, want one", edits)
+	}
+	if want := "var x = 1\nif x > 0 {\n  println(x)\n}\n"; edits[0].NewText != want {
+		t.Errorf("formatted %q, want %q", edits[0].NewText, want)
+	}
+	if edits[0].Range != (Range{Start: Position{}, End: Position{Line: 2, Character: 0}}) {
+		t.Errorf("edit range %+v", edits[0].Range)
+	}
+
+	c.open(testURI, edits[0].NewText)
+	c.request("textDocument/formatting", params, &edits)
+	if len(edits) != 0 {
+		t.Errorf("formatted document got edits %+v", edits)
+	}
+	c.shutdown()
+}
+
+func TestErrors(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{}, nil)
+
+	c.id++
+	id := c.id
+	c.send(&id, "textDocument/hover", at(0, 0))
+	if msg := c.next(); msg.Error == nil || msg.Error.Code != codeInvalidParams {
+		t.Errorf("hover on a closed document: %+v", msg)
+	}
+
+	c.id++
+	id = c.id
+	c.send(&id, "workspace/symbol", map[string]any{})
+	if msg := c.next(); msg.Error == nil || msg.Error.Code != codeMethodNotFound {
+		t.Errorf("unsupported method: %+v", msg)
+	}
+	c.shutdown()
+}
+
+/*
+Диагностики ошибок берут позицию из самой ошибки, колонки - в единицах
+UTF-16, как ждёт редактор.
+*/
+func TestErrorRanges(t *testing.T) {
+	tests := []struct {
+		text    string
+		message string
+		want    Range
+	}{
+		{"var s = \"ab\nvar t = 1", "unterminated string literal near \"\"ab\"", Range{Position{0, 8}, Position{0, 11}}},
+		{"var ж = 1\nvar 𝔵 = ж @ 2", "unrecognized token near \"@ 2\"", Range{Position{1, 11}, Position{1, 12}}},
+		{"var ж = 1\nж + жж", "Cannot resolve \"жж\" as it does not exist.", Range{Position{1, 4}, Position{1, 6}}},
+		{"var ж = 1 2", "Expected ';' or a new line after statement but got int", Range{Position{0, 10}, Position{0, 11}}},
+	}
+	for _, tt := range tests {
+		d := newDocument(testURI, 1, tt.text, lint.Config{})
+		if len(d.diagnostics) != 1 {
+			t.Errorf("%q: diagnostics %+v, want one", tt.text, d.diagnostics)
+			continue
+		}
+		got := d.diagnostics[0]
+		if got.Severity != SeverityError || got.Message != tt.message || got.Range != tt.want {
+			t.Errorf("%q: got %q at %+v, want %q at %+v", tt.text, got.Message, got.Range, tt.message, tt.want)
+		}
+	}
+}
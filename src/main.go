@@ -2,11 +2,24 @@ package main
 
 import (
 	"bufio"
+	"finescript/src/ast"
+	"finescript/src/compiler"
+	"finescript/src/dap"
+	"finescript/src/formatter"
 	"finescript/src/lexer"
+	"finescript/src/lint"
+	"finescript/src/lsp"
+	"finescript/src/optimizer"
 	"finescript/src/parser"
+	"finescript/src/repl"
+	"finescript/src/resolver"
 	"finescript/src/runtime"
+	"finescript/src/tracer"
+	"finescript/src/vm"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -18,73 +31,176 @@ var (
 	showTokens,
 	showAST,
 	showResult,
-	showTime bool
+	showTime,
+	noOpt,
+	writeFormatted,
+	checkFormatted,
+	traceExecution bool
+	allow,
+	engine,
+	lintFormat,
+	historyFile string
+	lintRules,
+	traceFunctions []string
 )
 
+func capabilities() runtime.Capability {
+	caps, err := runtime.ParseCapabilities(allow)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return caps
+}
+
+/*
+История REPL по умолчанию хранится в домашнем каталоге.
+*/
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".finescript_history")
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "finescript",
 	Short: "A simple programming language.",
 	Long:  "He is fine!",
 	Run: func(cmd *cobra.Command, args []string) {
-		reader := bufio.NewReader(os.Stdin)
-		env := runtime.GlobalEnv()
+		session := repl.New(os.Stdin, os.Stderr, capabilities(), !noOpt, historyFile)
+		os.Exit(session.Run())
+	},
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run program from file.",
+	Long:  "Specify the path to your software file and enjoy! Use - to read the program from stdin.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var source string
+		var tokens []lexer.Token
+		var ast ast.Program
+		var errs []string
+		var durationReadFile, durationLexer, durationParser time.Duration
+		var stream *lexer.Stream
+		var spool *os.File
 
-		for {
-			print("> ")
-			text, err := reader.ReadString('\n')
+		if args[0] == "-" {
+			// Текст копируется во временный файл, а не в память: из него
+			// берутся фрагменты кода для ошибок resolver
+			var err error
+			spool, err = os.CreateTemp("", "finescript-stdin-*.fs")
 			if err != nil {
-				panic(err)
+				fmt.Printf("Error creating temporary file: %v\n", err)
+				os.Exit(1)
 			}
+			defer removeSpool(spool)
 
-			source := strings.TrimSpace(text)
-			tokens, errs := lexer.Tokenize(source)
+			// Лексер и парсер читают stdin потоком, токены не сохраняются,
+			// поэтому их время входит в Duration Parser
+			startParser := time.Now()
+			stream = lexer.NewStream(bufio.NewReader(os.Stdin)).KeepSource(spool)
+			ast, errs = parser.ParseStream(stream)
+			if lexErrs := stream.Errors(); len(lexErrs) > 0 {
+				panic(strings.Join(lexErrs, "\n"))
+			}
 			if len(errs) > 0 {
 				panic(strings.Join(errs, "\n"))
 			}
-			ast, errs := parser.Parse(tokens, source)
+			durationParser = time.Since(startParser)
+		} else {
+			startReadFile := time.Now()
+			sourceBytes, err := os.ReadFile(args[0])
+			if err != nil {
+				fmt.Printf("Error reading file: %v\n", err)
+				os.Exit(1)
+			}
+			source = string(sourceBytes)
+			durationReadFile = time.Since(startReadFile)
+
+			startLexer := time.Now()
+			tokens, errs = lexer.Tokenize(source)
 			if len(errs) > 0 {
 				panic(strings.Join(errs, "\n"))
 			}
-			result := runtime.EvaluateStmt(ast, env)
-			println(fmt.Sprintf("\n%s", runtime.Format(result)))
+			durationLexer = time.Since(startLexer)
+
+			startParser := time.Now()
+			ast, errs = parser.Parse(tokens, source)
+			if len(errs) > 0 {
+				panic(strings.Join(errs, "\n"))
+			}
+			durationParser = time.Since(startParser)
 		}
-	},
-}
 
-var runCmd = &cobra.Command{
-	Use:   "run",
-	Short: "Run program from file.",
-	Long:  "Specify the path to your software file and enjoy!",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		startReadFile := time.Now()
-		sourceBytes, err := os.ReadFile(args[0])
-		if err != nil {
-			fmt.Printf("Error reading file: %v\n", err)
-			os.Exit(1)
+		env := runtime.NewGlobalEnv(capabilities())
+
+		tracing := traceExecution || len(traceFunctions) > 0
+
+		// Трассировка, как и отладчик, показывает программу такой, как
+		// она написана: встроенные функции и убранные ветки пропали бы
+		startOptimizer := time.Now()
+		if !noOpt && !tracing {
+			ast = optimizer.Optimize(ast)
 		}
-		source := string(sourceBytes)
-		durationReadFile := time.Since(startReadFile)
+		durationOptimizer := time.Since(startOptimizer)
 
-		startLexer := time.Now()
-		tokens, errs := lexer.Tokenize(source)
+		startResolver := time.Now()
+		if stream != nil {
+			ast, errs = resolver.ResolveStream(ast, stream, env.Names())
+		} else {
+			ast, errs = resolver.Resolve(ast, source, env.Names())
+		}
 		if len(errs) > 0 {
 			panic(strings.Join(errs, "\n"))
 		}
-		durationLexer := time.Since(startLexer)
+		durationResolver := time.Since(startResolver)
+		var durationCompiler time.Duration
+		var bytecode *compiler.Bytecode
+		switch engine {
+		case "tree":
+		case "vm":
+			startCompiler := time.Now()
+			if stream != nil {
+				bytecode, errs = compiler.CompileStream(ast, stream)
+			} else {
+				bytecode, errs = compiler.Compile(ast, source)
+			}
+			if len(errs) > 0 {
+				panic(strings.Join(errs, "\n"))
+			}
+			durationCompiler = time.Since(startCompiler)
+		default:
+			fmt.Printf("Unknown engine %q, expected \"tree\" or \"vm\"\n", engine)
+			os.Exit(1)
+		}
 
-		startParser := time.Now()
-		ast, errs := parser.Parse(tokens, source)
-		if len(errs) > 0 {
-			panic(strings.Join(errs, "\n"))
+		if tracing {
+			if bytecode != nil {
+				fmt.Println("Tracing requires the tree engine")
+				os.Exit(1)
+			}
+			if stream != nil {
+				env.SetTracer(tracer.NewStreamLogger(os.Stderr, stream, traceFunctions))
+			} else {
+				env.SetTracer(tracer.NewLogger(os.Stderr, source, traceFunctions))
+			}
 		}
-		durationParser := time.Since(startParser)
 
 		startInterpreter := time.Now()
 		if showTokens || showAST || showResult || showTime {
 			println("RUNTIME:===============================")
 		}
-		result := runtime.EvaluateStmt(ast, runtime.GlobalEnv())
+		var result runtime.RuntimeVal
+		var exit *runtime.ExitSignal
+		if bytecode != nil {
+			result, exit = vm.New(bytecode, env).Run()
+		} else {
+			result, exit = runtime.Run(ast, env)
+		}
 		println()
 		durationInterpreter := time.Since(startInterpreter)
 
@@ -102,18 +218,196 @@ var runCmd = &cobra.Command{
 		}
 		if showTime {
 			println("\nTIME:=================================")
-			fmt.Printf("Duration Read File: %s\nDuration Lexer: %s\nDuration Parser: %s\nDuration Interpreter: %s\n",
-				durationReadFile, durationLexer, durationParser, durationInterpreter)
+			fmt.Printf("Duration Read File: %s\nDuration Lexer: %s\nDuration Parser: %s\nDuration Optimizer: %s\nDuration Resolver: %s\n",
+				durationReadFile, durationLexer, durationParser, durationOptimizer, durationResolver)
+			if bytecode != nil {
+				fmt.Printf("Duration Compiler: %s\n", durationCompiler)
+			}
+			fmt.Printf("Duration Interpreter: %s\n", durationInterpreter)
+		}
+
+		if exit != nil {
+			// os.Exit не выполняет отложенные вызовы
+			if spool != nil {
+				removeSpool(spool)
+			}
+			os.Exit(exit.Code)
+		}
+	},
+}
+
+func removeSpool(spool *os.File) {
+	spool.Close()
+	os.Remove(spool.Name())
+}
+
+var fmtCmd = &cobra.Command{
+	Use:   "fmt",
+	Short: "Format program files.",
+	Long:  "Prints the formatted program to stdout. With -w the files are rewritten in place, with --check only the names of unformatted files are printed. Use - to read the program from stdin.",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
+		for _, path := range args {
+			var sourceBytes []byte
+			var err error
+			if path == "-" {
+				sourceBytes, err = io.ReadAll(os.Stdin)
+			} else {
+				sourceBytes, err = os.ReadFile(path)
+			}
+			if err != nil {
+				fmt.Printf("Error reading file: %v\n", err)
+				os.Exit(1)
+			}
+			source := string(sourceBytes)
+
+			formatted, errs := formatter.Format(source)
+			if len(errs) > 0 {
+				fmt.Printf("%s:\n%s\n", path, strings.Join(errs, "\n"))
+				failed = true
+				continue
+			}
+
+			switch {
+			case checkFormatted:
+				if formatted != source {
+					fmt.Println(path)
+					failed = true
+				}
+			case writeFormatted && path != "-":
+				if formatted == source {
+					continue
+				}
+				if err := os.WriteFile(path, []byte(formatted), 0644); err != nil {
+					fmt.Printf("Error writing file: %v\n", err)
+					os.Exit(1)
+				}
+			default:
+				fmt.Print(formatted)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check program files for likely mistakes.",
+	Long:  "Reports unused variables, shadowed names, assignments to constants, unreachable code, constant comparisons and variables that could be const. Exits with status 1 if a file cannot be parsed or a rule with severity error fails. Use - to read the program from stdin.",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		env := runtime.NewGlobalEnv(capabilities())
+		config := lint.Config{
+			Globals: env.Names(),
+		}
+		for _, setting := range lintRules {
+			if err := config.Set(setting); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		failed := false
+		reports := make([]lint.Report, 0, len(args))
+		for _, path := range args {
+			var sourceBytes []byte
+			var err error
+			if path == "-" {
+				sourceBytes, err = io.ReadAll(os.Stdin)
+			} else {
+				sourceBytes, err = os.ReadFile(path)
+			}
+			if err != nil {
+				fmt.Printf("Error reading file: %v\n", err)
+				os.Exit(1)
+			}
+
+			diagnostics, errs := lint.Lint(string(sourceBytes), config)
+			if len(errs) > 0 {
+				failed = true
+			}
+			for _, d := range diagnostics {
+				if d.Severity == lint.Error {
+					failed = true
+				}
+			}
+			reports = append(reports, lint.Report{
+				File:        path,
+				Diagnostics: diagnostics,
+				Errors:      lexer.Messages(errs),
+			})
+		}
+
+		switch lintFormat {
+		case "text":
+			lint.WriteText(os.Stdout, reports)
+		case "json":
+			if err := lint.WriteJSON(os.Stdout, reports); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Printf("Unknown format %q, expected \"text\" or \"json\"\n", lintFormat)
+			os.Exit(1)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var lspCmd = &cobra.Command{
+	Use:   "lsp",
+	Short: "Start a Language Server Protocol server over stdio.",
+	Long:  "Serves diagnostics, hover, go to definition, references, document symbols, completion and formatting to an editor over stdin and stdout.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		env := runtime.NewGlobalEnv(capabilities())
+		server := lsp.NewServer(os.Stdin, os.Stdout, env.Names())
+		if err := server.Serve(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var dapCmd = &cobra.Command{
+	Use:   "dap",
+	Short: "Start a Debug Adapter Protocol server over stdio.",
+	Long:  "Runs a program under the debugger for an editor over stdin and stdout: line and conditional breakpoints, stepping, call stack and variables. Program output is sent to the editor as output events; input() is not available.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		server := dap.NewServer(os.Stdin, os.Stdout, capabilities())
+		if err := server.Serve(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
 	},
 }
 
 func main() {
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(fmtCmd)
+	rootCmd.AddCommand(lintCmd)
+	rootCmd.AddCommand(lspCmd)
+	rootCmd.AddCommand(dapCmd)
+	rootCmd.PersistentFlags().StringVar(&allow, "allow", "all", "Comma-separated builtin groups available to the program (io, eval, process, fs, time, random)")
+	rootCmd.Flags().StringVar(&historyFile, "history", defaultHistoryFile(), "File the REPL keeps input history in, empty disables history")
+	rootCmd.PersistentFlags().BoolVar(&noOpt, "no-opt", false, "Disables AST optimizations (constant folding, dead branch elimination, inlining)")
+	runCmd.PersistentFlags().StringVar(&engine, "engine", "tree", "Execution engine: tree (AST interpreter) or vm (bytecode virtual machine)")
 	runCmd.PersistentFlags().BoolVarP(&showTokens, "show-tokens", "t", false, "Enables program tokens visibility")
 	runCmd.PersistentFlags().BoolVarP(&showAST, "show-ast", "a", false, "Enables program AST visibility")
 	runCmd.PersistentFlags().BoolVarP(&showResult, "show-result", "r", false, "Enables program result visibility")
 	runCmd.PersistentFlags().BoolVarP(&showTime, "show-time", "s", false, "Enables program execute time visibility")
+	runCmd.Flags().BoolVar(&traceExecution, "trace", false, "Prints an indented log of executed statements, calls and assignments to stderr, disables optimizations")
+	runCmd.Flags().StringArrayVar(&traceFunctions, "trace-fun", nil, "Limits the trace to calls of the function and everything they execute, can be repeated, implies --trace")
+	fmtCmd.Flags().BoolVarP(&writeFormatted, "write", "w", false, "Writes the formatted program back to the file")
+	fmtCmd.Flags().BoolVar(&checkFormatted, "check", false, "Lists files that are not formatted and exits with status 1 if there are any")
+	lintCmd.Flags().StringVar(&lintFormat, "format", "text", "Output format: text or json")
+	lintCmd.Flags().StringArrayVar(&lintRules, "rule", nil, "Sets rule severity as name=off|info|warning|error, can be repeated")
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
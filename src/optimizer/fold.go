@@ -0,0 +1,145 @@
+package optimizer
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/runtime"
+)
+
+func isLiteral(expr ast.Expr) bool {
+	switch expr.(type) {
+	case ast.IntLiteral, ast.FloatLiteral, ast.StringLiteral, ast.BoolLiteral:
+		return true
+	default:
+		return false
+	}
+}
+
+func literalValue(expr ast.Expr) runtime.RuntimeVal {
+	switch lit := expr.(type) {
+	case ast.IntLiteral:
+		return runtime.IntVal{Value: lit.Value}
+	case ast.FloatLiteral:
+		return runtime.FloatVal{Value: lit.Value}
+	case ast.StringLiteral:
+		return runtime.StringVal{Value: lit.Value}
+	case ast.BoolLiteral:
+		return runtime.BoolVal{Value: lit.Value}
+	default:
+		panic("Expected literal")
+	}
+}
+
+func valueLiteral(val runtime.RuntimeVal, pos lexer.Position) (ast.Expr, bool) {
+	switch v := val.(type) {
+	case runtime.IntVal:
+		return ast.IntLiteral{Value: v.Value, Position: pos}, true
+	case runtime.FloatVal:
+		return ast.FloatLiteral{Value: v.Value, Position: pos}, true
+	case runtime.StringVal:
+		return ast.StringLiteral{Value: v.Value, Position: pos}, true
+	case runtime.BoolVal:
+		return ast.BoolLiteral{Value: v.Value, Position: pos}, true
+	default:
+		return nil, false
+	}
+}
+
+/*
+Переносит литерал на новую позицию, чтобы ошибки указывали на место
+использования, а не объявления.
+*/
+func moveLiteral(expr ast.Expr, pos lexer.Position) ast.Expr {
+	lit, _ := valueLiteral(literalValue(expr), pos)
+	return lit
+}
+
+/*
+Вычисляет выражение над литералами через операции runtime. Если
+вычисление падает, выражение не сворачивается, и ошибка возникнет
+во время выполнения, как и без оптимизации.
+*/
+func fold(pos lexer.Position, eval func() runtime.RuntimeVal) (folded ast.Expr, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			folded, ok = nil, false
+		}
+	}()
+
+	return valueLiteral(eval(), pos)
+}
+
+func foldBinaryExpr(expr ast.BinaryExpr) ast.Expr {
+	// У ?? достаточно знать левую часть: литерал всегда есть, а null
+	// и undefined всегда отсутствуют
+	if expr.Op.Kind == lexer.QUESTION_QUESTION {
+		switch expr.Left.(type) {
+		case ast.NullLiteral, ast.UndefinedLiteral:
+			return expr.Right
+		}
+		if isLiteral(expr.Left) {
+			return expr.Left
+		}
+		return expr
+	}
+	if !isLiteral(expr.Left) || !isLiteral(expr.Right) {
+		return expr
+	}
+	folded, ok := fold(expr.Position, func() runtime.RuntimeVal {
+		return runtime.BinaryOp(literalValue(expr.Left), literalValue(expr.Right), expr.Op)
+	})
+	if !ok {
+		return expr
+	}
+	return folded
+}
+
+func foldUnaryExpr(expr ast.UnaryExpr) ast.Expr {
+	switch expr.Op.Kind {
+	case lexer.MINUS, lexer.NOT, lexer.TILDE:
+	default:
+		return expr
+	}
+	if !isLiteral(expr.Expr) {
+		return expr
+	}
+	folded, ok := fold(expr.Position, func() runtime.RuntimeVal {
+		return runtime.UnaryOp(literalValue(expr.Expr), expr.Op)
+	})
+	if !ok {
+		return expr
+	}
+	return folded
+}
+
+/*
+Шаблон, все части которого литералы, становится обычной строкой.
+*/
+func foldTemplateExpr(expr ast.TemplateExpr) ast.Expr {
+	parts := make([]runtime.RuntimeVal, 0, len(expr.Parts))
+	for _, part := range expr.Parts {
+		if !isLiteral(part) {
+			return expr
+		}
+		parts = append(parts, literalValue(part))
+	}
+	return ast.StringLiteral{
+		Value:    runtime.Template(parts).Value,
+		Position: expr.Position,
+	}
+}
+
+/*
+Значение условия, если оно известно при компиляции.
+*/
+func staticCondition(expr ast.Expr) (value bool, ok bool) {
+	if !isLiteral(expr) {
+		return false, false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			value, ok = false, false
+		}
+	}()
+	return runtime.ToBool(literalValue(expr)).Value, true
+}
@@ -0,0 +1,163 @@
+package optimizer
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+)
+
+const maxInlineNodes = 16
+
+/*
+Функция, тело которой - одно чистое выражение. free запоминает,
+к каким объявлениям относились свободные имена тела, чтобы не встроить
+вызов туда, где эти имена перекрыты.
+*/
+type inlineFun struct {
+	params []ast.Param
+	body   ast.Expr
+	free   map[string]*entry
+}
+
+func (o *optimizer) inlineCandidate(stmt ast.FunDeclStmt) *inlineFun {
+	if len(stmt.Body) != 1 {
+		return nil
+	}
+	exprStmt, ok := stmt.Body[0].(ast.ExprStmt)
+	if !ok {
+		return nil
+	}
+
+	params := make(map[string]bool)
+	for _, param := range stmt.Params {
+		params[param.Name] = true
+	}
+
+	fn := &inlineFun{
+		params: stmt.Params,
+		body:   exprStmt.Expr,
+		free:   make(map[string]*entry),
+	}
+	nodes := 0
+	pure := walkPure(exprStmt.Expr, func(ident ast.Identifier) bool {
+		nodes++
+		if ident.Name == stmt.Name {
+			return false
+		}
+		if !params[ident.Name] {
+			fn.free[ident.Name] = o.lookup(ident.Name)
+		}
+		return true
+	}, &nodes)
+
+	if !pure || nodes > maxInlineNodes {
+		return nil
+	}
+	for _, decl := range fn.free {
+		if decl == unknown {
+			return nil
+		}
+	}
+	return fn
+}
+
+/*
+Обходит выражение без побочных эффектов и вызывает visit для каждого
+идентификатора. Возвращает false, если выражение нельзя встроить.
+*/
+func walkPure(node ast.Expr, visit func(ast.Identifier) bool, nodes *int) bool {
+	switch expr := node.(type) {
+	case ast.Identifier:
+		return visit(expr)
+	case ast.IntLiteral, ast.FloatLiteral, ast.StringLiteral, ast.BoolLiteral, ast.NullLiteral, ast.UndefinedLiteral:
+		*nodes++
+		return true
+	case ast.UnaryExpr:
+		*nodes++
+		if expr.Op.Kind != lexer.MINUS && expr.Op.Kind != lexer.NOT && expr.Op.Kind != lexer.TILDE {
+			return false
+		}
+		return walkPure(expr.Expr, visit, nodes)
+	case ast.BinaryExpr:
+		*nodes++
+		return walkPure(expr.Left, visit, nodes) && walkPure(expr.Right, visit, nodes)
+	case ast.ConditionalExpr:
+		*nodes++
+		return walkPure(expr.Condition, visit, nodes) &&
+			walkPure(expr.Consequent, visit, nodes) &&
+			walkPure(expr.Alternate, visit, nodes)
+	default:
+		return false
+	}
+}
+
+/*
+Подставляет тело функции вместо вызова. Аргументы должны быть
+литералами или именами: их можно вычислить несколько раз без
+изменения поведения.
+*/
+func (o *optimizer) inlineCall(call ast.CallExpr) (ast.Expr, bool) {
+	caller, ok := call.Caller.(ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+	e := o.lookup(caller.Name)
+	if e == nil || e.inline == nil || len(call.Args) != len(e.inline.params) {
+		return nil, false
+	}
+
+	// Имена тела должны означать у вызова то же, что и в объявлении
+	for name, decl := range e.inline.free {
+		if o.lookup(name) != decl {
+			return nil, false
+		}
+	}
+
+	args := make(map[string]ast.Expr)
+	for i, arg := range call.Args {
+		switch arg.(type) {
+		case ast.Identifier:
+		default:
+			if !isLiteral(arg) {
+				return nil, false
+			}
+		}
+		args[e.inline.params[i].Name] = arg
+	}
+
+	return substitute(e.inline.body, args, call.Position), true
+}
+
+func substitute(node ast.Expr, args map[string]ast.Expr, pos lexer.Position) ast.Expr {
+	switch expr := node.(type) {
+	case ast.Identifier:
+		if arg, exists := args[expr.Name]; exists {
+			return arg
+		}
+		expr.Position = pos
+		return expr
+	case ast.UnaryExpr:
+		expr.Expr = substitute(expr.Expr, args, pos)
+		expr.Position = pos
+		return expr
+	case ast.BinaryExpr:
+		expr.Left = substitute(expr.Left, args, pos)
+		expr.Right = substitute(expr.Right, args, pos)
+		expr.Position = pos
+		return expr
+	case ast.ConditionalExpr:
+		expr.Condition = substitute(expr.Condition, args, pos)
+		expr.Consequent = substitute(expr.Consequent, args, pos)
+		expr.Alternate = substitute(expr.Alternate, args, pos)
+		expr.Position = pos
+		return expr
+	default:
+		return moveLiteralOrKeep(node, pos)
+	}
+}
+
+func moveLiteralOrKeep(node ast.Expr, pos lexer.Position) ast.Expr {
+	if isLiteral(node) {
+		return moveLiteral(node, pos)
+	}
+	return node
+}
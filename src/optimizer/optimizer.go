@@ -0,0 +1,236 @@
+package optimizer
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+)
+
+/*
+Сведения об объявленном имени: значение константы-литерала или
+функция, которую можно встроить в место вызова.
+*/
+type entry struct {
+	value  ast.Expr
+	inline *inlineFun
+}
+
+type scope struct {
+	names  map[string]*entry
+	parent *scope
+	// Область вызывает eval, evalIn или currentEnv, и во время
+	// выполнения в ней могут появиться новые имена
+	dynamic bool
+}
+
+/*
+Имя, которое может перекрыть объявление из eval: о нём ничего не
+известно, поэтому его не подставляют и не встраивают.
+*/
+var unknown = &entry{}
+
+type optimizer struct {
+	scope *scope
+}
+
+/*
+Упрощает программу без изменения её поведения: сворачивает выражения
+над литералами, убирает ветки if с известным условием, подставляет
+значения констант и встраивает маленькие нерекурсивные функции.
+
+Оптимизация выполняется до resolver, потому что меняет области видимости.
+*/
+func Optimize(program ast.Program) ast.Program {
+	o := &optimizer{}
+	program.Body = o.optimizeScope(program.Body)
+	return program
+}
+
+func (o *optimizer) enterScope(body []ast.Stmt) {
+	o.scope = &scope{
+		names:   make(map[string]*entry),
+		parent:  o.scope,
+		dynamic: ast.CallsEval(body),
+	}
+	for _, node := range body {
+		switch stmt := node.(type) {
+		case ast.VarDeclStmt:
+			o.scope.names[stmt.Name] = &entry{}
+		case ast.FunDeclStmt:
+			o.scope.names[stmt.Name] = &entry{}
+		case ast.TypeAliasDecl:
+			o.scope.names[stmt.Name] = &entry{}
+		}
+	}
+}
+
+func (o *optimizer) leaveScope() {
+	o.scope = o.scope.parent
+}
+
+/*
+Объявление имени. Если по пути к нему встретилась область с eval,
+возвращается unknown: eval мог объявить в ней то же имя.
+*/
+func (o *optimizer) lookup(name string) *entry {
+	for s := o.scope; s != nil; s = s.parent {
+		if e, exists := s.names[name]; exists {
+			return e
+		}
+		if s.dynamic {
+			return unknown
+		}
+	}
+	return nil
+}
+
+func (o *optimizer) optimizeScope(body []ast.Stmt) []ast.Stmt {
+	o.enterScope(body)
+	defer o.leaveScope()
+
+	return o.optimizeStmts(body)
+}
+
+/*
+Оптимизирует тело, значение которого - значение последней инструкции.
+Выброшенная ветка if может оставить вместо себя несколько инструкций.
+*/
+func (o *optimizer) optimizeStmts(body []ast.Stmt) []ast.Stmt {
+	result := make([]ast.Stmt, 0, len(body))
+	for i, stmt := range body {
+		ifStmt, ok := stmt.(ast.IfStmt)
+		if !ok {
+			result = append(result, o.optimizeStmt(stmt))
+			continue
+		}
+
+		ifStmt.Condition = o.optimizeExpr(ifStmt.Condition)
+		condition, static := staticCondition(ifStmt.Condition)
+		if !static {
+			ifStmt.Consequent = o.optimizeScope(ifStmt.Consequent)
+			ifStmt.Alternate = o.optimizeScope(ifStmt.Alternate)
+			result = append(result, ifStmt)
+			continue
+		}
+
+		branch := ifStmt.Alternate
+		if condition {
+			branch = ifStmt.Consequent
+		}
+		result = append(result, o.liveBranch(branch, ifStmt.Position)...)
+
+		// if возвращает null, это важно только для последней инструкции
+		if i == len(body)-1 {
+			result = append(result, ast.ExprStmt{
+				Expr:     ast.NullLiteral{Position: ifStmt.Position},
+				Position: ifStmt.Position,
+			})
+		}
+	}
+	return result
+}
+
+/*
+Оставшаяся ветка if. Если в ней нет объявлений, её инструкции
+встраиваются в охватывающее тело, иначе она остаётся блоком со своей
+областью видимости.
+*/
+func (o *optimizer) liveBranch(branch []ast.Stmt, pos lexer.Position) []ast.Stmt {
+	branch = o.optimizeScope(branch)
+	for _, stmt := range branch {
+		switch stmt.(type) {
+		case ast.VarDeclStmt, ast.FunDeclStmt, ast.TypeAliasDecl:
+			return []ast.Stmt{ast.BlockStmt{
+				Body:     branch,
+				Position: pos,
+			}}
+		}
+	}
+	return branch
+}
+
+func (o *optimizer) optimizeStmt(node ast.Stmt) ast.Stmt {
+	switch stmt := node.(type) {
+	case ast.BlockStmt:
+		stmt.Body = o.optimizeScope(stmt.Body)
+		return stmt
+	case ast.VarDeclStmt:
+		stmt.Value = o.optimizeExpr(stmt.Value)
+		if stmt.IsConstant && isLiteral(stmt.Value) {
+			o.scope.names[stmt.Name].value = stmt.Value
+		}
+		return stmt
+	case ast.FunDeclStmt:
+		return o.optimizeFunction(stmt)
+	case ast.ExprStmt:
+		stmt.Expr = o.optimizeExpr(stmt.Expr)
+		return stmt
+	default:
+		return node
+	}
+}
+
+func (o *optimizer) optimizeFunction(stmt ast.FunDeclStmt) ast.FunDeclStmt {
+	o.enterScope(stmt.Body)
+	for _, param := range stmt.Params {
+		o.scope.names[param.Name] = &entry{}
+	}
+	stmt.Body = o.optimizeStmts(stmt.Body)
+	o.leaveScope()
+
+	o.scope.names[stmt.Name].inline = o.inlineCandidate(stmt)
+	return stmt
+}
+
+func (o *optimizer) optimizeExpr(node ast.Expr) ast.Expr {
+	switch expr := node.(type) {
+	case ast.Identifier:
+		if e := o.lookup(expr.Name); e != nil && e.value != nil {
+			return moveLiteral(e.value, expr.Position)
+		}
+		return expr
+	case ast.UnaryExpr:
+		// Операнд ++ и -- - это присваивание, константу туда не подставляем
+		if _, ok := expr.Expr.(ast.Identifier); !ok {
+			expr.Expr = o.optimizeExpr(expr.Expr)
+		}
+		return foldUnaryExpr(expr)
+	case ast.BinaryExpr:
+		expr.Left = o.optimizeExpr(expr.Left)
+		expr.Right = o.optimizeExpr(expr.Right)
+		return foldBinaryExpr(expr)
+	case ast.AssignExpr:
+		expr.Expr = o.optimizeExpr(expr.Expr)
+		return expr
+	case ast.CallExpr:
+		args := make([]ast.Expr, 0, len(expr.Args))
+		for _, arg := range expr.Args {
+			args = append(args, o.optimizeExpr(arg))
+		}
+		expr.Args = args
+		if inlined, ok := o.inlineCall(expr); ok {
+			return o.optimizeExpr(inlined)
+		}
+		expr.Caller = o.optimizeExpr(expr.Caller)
+		return expr
+	case ast.ConditionalExpr:
+		expr.Condition = o.optimizeExpr(expr.Condition)
+		if condition, static := staticCondition(expr.Condition); static {
+			if condition {
+				return o.optimizeExpr(expr.Consequent)
+			}
+			return o.optimizeExpr(expr.Alternate)
+		}
+		expr.Consequent = o.optimizeExpr(expr.Consequent)
+		expr.Alternate = o.optimizeExpr(expr.Alternate)
+		return expr
+	case ast.TemplateExpr:
+		parts := make([]ast.Expr, 0, len(expr.Parts))
+		for _, part := range expr.Parts {
+			parts = append(parts, o.optimizeExpr(part))
+		}
+		expr.Parts = parts
+		return foldTemplateExpr(expr)
+	default:
+		return node
+	}
+}
@@ -0,0 +1,136 @@
+package optimizer
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"finescript/src/resolver"
+	"finescript/src/runtime"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, source string) ast.Program {
+	t.Helper()
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	program, errs := parser.Parse(tokens, source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	return program
+}
+
+func run(t *testing.T, program ast.Program, source string) (result string) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			result = fmt.Sprint("panic: ", r)
+		}
+	}()
+	env := runtime.NewGlobalEnv(runtime.CapAll)
+	program, errs := resolver.Resolve(program, source, env.Names())
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	value, _ := runtime.Run(program, env)
+	return runtime.Format(value)
+}
+
+/*
+Оптимизированная программа должна вести себя так же, как исходная.
+*/
+func assertSameResult(t *testing.T, source string, want string) {
+	t.Helper()
+	program := parse(t, source)
+	optimized := Optimize(parse(t, source))
+	plain := run(t, program, source)
+	fast := run(t, optimized, source)
+	if plain != want || fast != want {
+		t.Errorf("unoptimized = %s, optimized = %s, want %s", plain, fast, want)
+	}
+}
+
+func TestInlining(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"simple", "fun sq(x: int) { x * x }\nsq(7)", "49"},
+		{"nested", "fun sq(x: int) { x * x }\nfun quad(x: int) { sq(sq(x)) }\nquad(3)", "81"},
+		{"argument evaluated once", "var calls = 0\nfun next() {\n  calls = calls + 1\n  calls\n}\nfun twice(x: int) { x + x }\nsprintf(twice(next()), calls)", "21"},
+		{"free name not shadowed", "var k = 10\nfun addK(x: int) { x + k }\n{\n  var k = 1\n  addK(k)\n}", "11"},
+		{"parameter named like caller variable", "fun inc(x: int) { x + 1 }\nvar x = 5\ninc(x * 2)", "11"},
+		{"conditional body", "fun abs(x: int) { x < 0 ? -x : x }\nsprintf(abs(-4), abs(3))", "43"},
+		{"redefined by eval", "fun sq(x: int) { x * x }\nfun g() {\n  eval(\"fun sq(x: int) { 0 }\")\n  sq(3)\n}\ng()", "0"},
+		{"free name declared by eval", "var k = 10\nfun addK(x: int) { x + k }\nfun g() {\n  eval(\"var k = 1\")\n  addK(1)\n}\ng()", "11"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSameResult(t, tt.source, tt.want)
+		})
+	}
+}
+
+func TestDeadBranches(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"equality", "var x = 0\nif 1 == 6 { x = 1 } else { x = 2 }\nx", "2"},
+		{"int equals float", "var x = 0\nif 1 == 1.0 { x = 1 } else { x = 2 }\nx", "1"},
+		{"strings", "var x = 0\nif \"a\" != \"a\" { x = 1 }\nx", "0"},
+		{"null and undefined", "var x = 0\nif null == undefined { x = 1 } else { x = 2 }\nx", "2"},
+		{"kept branch keeps its scope", "var y = 1\nif true {\n  var y = 2\n}\ny", "1"},
+		{"else if", "var x = 0\nif false { x = 1 } else if 2 > 1 { x = 2 } else { x = 3 }\nx", "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSameResult(t, tt.source, tt.want)
+		})
+	}
+}
+
+func TestConstantPropagation(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"chain", "const a = 2\nconst b = a * 3\nb + 1", "7"},
+		{"equality", "const a = 1\nconst b = 6\nsprintf(a == b, a != b, a == 1.0)", "falsetruetrue"},
+		{"shadowed in block", "const c = 5\nvar r = \"\"\n{\n  const c = \"s\"\n  r = c\n}\nsprintf(c + 1, r)", "6s"},
+		{"inside function", "const c = 5\nfun f() { c * 2 }\nf()", "10"},
+		{"var is not propagated", "const c = 1\nvar v = 2\nv = 5\nv * 2 + c", "11"},
+		{"shadowed by eval", "const k = 1\nfun f() {\n  eval(\"var k = 2\")\n  k\n}\nf()", "2"},
+		{"shadowed by eval in outer block", "const k = 1\nvar r = 0\n{\n  evalIn(currentEnv(), \"var k = 2\")\n  if true { r = k }\n}\nr", "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSameResult(t, tt.source, tt.want)
+		})
+	}
+}
+
+/*
+Без изменений в программе сравнение выше ничего бы не проверяло.
+*/
+func TestOptimizerChangesProgram(t *testing.T) {
+	sources := []string{
+		"fun sq(x: int) { x * x }\nsq(7)",
+		"var x = 0\nif 1 == 6 { x = 1 } else { x = 2 }\nx",
+		"const a = 2\nconst b = a * 3\nb + 1",
+		"const a = 1\nconst b = 6\nsprintf(a == b, a != b, a == 1.0)",
+	}
+	for _, source := range sources {
+		if reflect.DeepEqual(parse(t, source), Optimize(parse(t, source))) {
+			t.Errorf("%q: optimizer left the program unchanged", source)
+		}
+	}
+}
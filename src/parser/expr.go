@@ -1,10 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"finescript/src/ast"
 	"finescript/src/lexer"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 func parseExpr(p *parser, bp bindingPower) ast.Expr {
@@ -12,7 +14,7 @@ func parseExpr(p *parser, bp bindingPower) ast.Expr {
 	nudFn, exists := nudLU[token.Kind]
 
 	if !exists {
-		p.errors = append(p.errors, fmt.Sprintf("NUD Handler expected for token %s at %s\n", lexer.TokenKindString(token.Kind), token.Position.String()))
+		p.report(located(fmt.Sprintf("NUD Handler expected for token %s at %s\n", lexer.TokenKindString(token.Kind), token.Position.String()), token.Position))
 		return ast.Error{
 			Position: &token.Position,
 		}
@@ -20,27 +22,31 @@ func parseExpr(p *parser, bp bindingPower) ast.Expr {
 
 	left := nudFn(p)
 
-	for bpLU[p.currentTokenKind()] > bp {
+	for bpLU[p.currentTokenKind()] > bp && !p.atLineBreak(startsExpr) {
 		token = p.currentToken()
 		ledFn, exists := ledLU[token.Kind]
 
+		// Токен не продолжает выражение: ошибку сообщит тот, кто ждёт
+		// после выражения ')' или конец инструкции
 		if !exists {
-			p.errors = append(p.errors, fmt.Sprintf("LED Handler expected for token %s at %s\n", lexer.TokenKindString(token.Kind), token.Position.String()))
-			return ast.Error{
-				Position: &token.Position,
-			}
+			break
 		}
 
 		left = ledFn(p, left, bp)
-	}
-
-	if p.currentTokenKind() == lexer.SEMI_COLON {
-		p.advance()
+		// Обработчики не сдвигаются с места после ошибки слева
+		if _, ok := left.(ast.Error); ok {
+			break
+		}
 	}
 
 	return left
 }
 
+func startsExpr(kind lexer.TokenKind) bool {
+	_, exists := nudLU[kind]
+	return exists
+}
+
 func parsePrimaryExpr(p *parser) ast.Expr {
 	token := p.currentToken()
 	switch token.Kind {
@@ -48,7 +54,7 @@ func parsePrimaryExpr(p *parser) ast.Expr {
 		token := p.advance()
 		number, err := strconv.ParseInt(token.Value, 0, 64)
 		if err != nil {
-			panic(fmt.Sprintf("invalid integer literal: %s", token.Value))
+			return numberLiteralError(p, token, err, "integer")
 		}
 		return ast.IntLiteral{
 			Value:    number,
@@ -58,7 +64,7 @@ func parsePrimaryExpr(p *parser) ast.Expr {
 		token := p.advance()
 		number, err := strconv.ParseFloat(token.Value, 64)
 		if err != nil {
-			panic(fmt.Sprintf("invalid float literal: %s", token.Value))
+			return numberLiteralError(p, token, err, "float")
 		}
 		return ast.FloatLiteral{
 			Value:    number,
@@ -95,13 +101,28 @@ func parsePrimaryExpr(p *parser) ast.Expr {
 			Position: p.advance().Position,
 		}
 	default:
-		p.errors = append(p.errors, fmt.Sprintf("Cannot create primary_expr from %s at %s", lexer.TokenKindString(token.Kind), token.Position.String()))
+		p.report(located(fmt.Sprintf("Cannot create primary_expr from %s at %s", lexer.TokenKindString(token.Kind), token.Position.String()), token.Position))
 		return ast.Error{
 			Position: &token.Position,
 		}
 	}
 }
 
+/*
+Литерал, который лексер разобрал с запасом, но strconv не принял:
+неверные цифры для основания, лишние _ или выход за пределы типа.
+*/
+func numberLiteralError(p *parser, token lexer.Token, err error, kind string) ast.Expr {
+	message := fmt.Sprintf("Invalid %s literal %s", kind, token.Value)
+	if errors.Is(err, strconv.ErrRange) {
+		message = fmt.Sprintf("%s literal %s is out of range", strings.ToUpper(kind[:1])+kind[1:], token.Value)
+	}
+	p.report(p.error(message, &token.Position))
+	return ast.Error{
+		Position: &token.Position,
+	}
+}
+
 func parseUnaryExpr(p *parser) ast.Expr {
 	operatorToken := p.advance()
 	expr := parseExpr(p, unary)
@@ -110,12 +131,9 @@ func parseUnaryExpr(p *parser) ast.Expr {
 	}
 
 	return ast.UnaryExpr{
-		Op:   operatorToken,
-		Expr: expr,
-		Position: lexer.Position{
-			StartPos: operatorToken.Position.StartPos,
-			EndPos:   expr.Pos().EndPos,
-		},
+		Op:       operatorToken,
+		Expr:     expr,
+		Position: lexer.Span(operatorToken.Position, expr.Pos().EndPos),
 	}
 }
 
@@ -126,12 +144,9 @@ func parseLedUnaryExpr(p *parser, left ast.Expr, bp bindingPower) ast.Expr {
 	operatorToken := p.advance()
 
 	return ast.UnaryExpr{
-		Op:   operatorToken,
-		Expr: left,
-		Position: lexer.Position{
-			StartPos: operatorToken.Position.StartPos,
-			EndPos:   left.Pos().EndPos,
-		},
+		Op:       operatorToken,
+		Expr:     left,
+		Position: lexer.Span(left.Pos(), operatorToken.Position.EndPos),
 	}
 }
 
@@ -146,13 +161,10 @@ func parseAssignExpr(p *parser, left ast.Expr, bp bindingPower) ast.Expr {
 	}
 
 	return ast.AssignExpr{
-		Assigne: left,
-		Op:      op,
-		Expr:    expr,
-		Position: lexer.Position{
-			StartPos: left.Pos().StartPos,
-			EndPos:   expr.Pos().EndPos,
-		},
+		Assigne:  left,
+		Op:       op,
+		Expr:     expr,
+		Position: lexer.Span(left.Pos(), expr.Pos().EndPos),
 	}
 }
 
@@ -161,33 +173,109 @@ func parseBinaryExpr(p *parser, left ast.Expr, bp bindingPower) ast.Expr {
 		return err
 	}
 	operatorToken := p.advance()
-	right := parseExpr(p, defaultBP)
+	// Правый операнд забирает только операторы сильнее текущего, поэтому
+	// цепочки левоассоциативны. ** правоассоциативен: 2 ** 3 ** 2 = 2 ** 9
+	rightBP := bpLU[operatorToken.Kind]
+	if operatorToken.Kind == lexer.STAR_STAR {
+		rightBP--
+	}
+	right := parseExpr(p, rightBP)
 	if err, ok := right.(ast.Error); ok {
 		return err
 	}
 
 	return ast.BinaryExpr{
-		Left:  left,
-		Op:    operatorToken,
-		Right: right,
-		Position: lexer.Position{
-			StartPos: left.Pos().StartPos,
-			EndPos:   right.Pos().EndPos,
-		},
+		Left:     left,
+		Op:       operatorToken,
+		Right:    right,
+		Position: lexer.Span(left.Pos(), right.Pos().EndPos),
 	}
 }
 
+/*
+Имя, которое в аргументах этапа конвейера заменяется на значение
+слева: x |> f(a, _) - это f(a, x).
+*/
+const pipelinePlaceholder = "_"
+
+/*
+_ нельзя объявить: внутри этапа конвейера оно всегда означает значение
+слева, и переменная с таким именем там была бы не видна.
+*/
+func placeholderName(p *parser, name lexer.Token) bool {
+	if name.Value != pipelinePlaceholder {
+		return false
+	}
+	p.report(p.error(fmt.Sprintf("%s cannot be used as a name, it is reserved for the pipeline placeholder", pipelinePlaceholder), &name.Position))
+	return true
+}
+
+/*
+x |> f(a) превращается в вызов f(x, a), а x |> f - в f(x). Если среди
+аргументов этапа есть _, значение подставляется на его место. Вызов
+сохраняет позицию этапа, поэтому ошибки указывают на него.
+*/
+func parsePipelineExpr(p *parser, left ast.Expr, bp bindingPower) ast.Expr {
+	if err, ok := left.(ast.Error); ok {
+		return err
+	}
+	p.advance()
+	// Тот же приоритет справа делает цепочку левоассоциативной
+	stage := parseExpr(p, pipeline)
+	if err, ok := stage.(ast.Error); ok {
+		return err
+	}
+
+	call, ok := stage.(ast.CallExpr)
+	if !ok {
+		return ast.CallExpr{
+			Caller:   stage,
+			Args:     []ast.Expr{left},
+			Pipe:     &ast.Pipe{Bare: true},
+			Position: stage.Pos(),
+		}
+	}
+
+	pipe := &ast.Pipe{}
+	placeholders := 0
+	args := make([]ast.Expr, 0, len(call.Args)+1)
+	for i, arg := range call.Args {
+		if ident, ok := arg.(ast.Identifier); ok && ident.Name == pipelinePlaceholder {
+			placeholders++
+			pipe.Arg = i
+			pipe.Placeholder = true
+			args = append(args, left)
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	switch placeholders {
+	case 0:
+		args = append([]ast.Expr{left}, args...)
+	case 1:
+	default:
+		p.report(p.error(fmt.Sprintf("Pipeline placeholder %s can be used only once per stage", pipelinePlaceholder), &call.Position))
+		return ast.Error{
+			Position: &call.Position,
+		}
+	}
+
+	call.Args = args
+	call.Pipe = pipe
+	return call
+}
+
 func parseGroupingExpr(p *parser) ast.Expr {
 	p.advance()
+	p.nesting++
+	defer func() { p.nesting-- }()
+
 	expr := parseExpr(p, defaultBP)
 	if err, ok := expr.(ast.Error); ok {
 		return err
 	}
 
-	if p.currentTokenKind() == lexer.SEMI_COLON {
-		p.advance()
-	}
-
 	expected := p.expect(lexer.CLOSE_PAREN)
 	if expected.Kind == lexer.ERROR {
 		return ast.Error{
@@ -201,9 +289,23 @@ func parseCallExpr(p *parser, left ast.Expr, bp bindingPower) ast.Expr {
 	if err, ok := left.(ast.Error); ok {
 		return err
 	}
-	startPos := p.advance().Position.StartPos
-	arguments := make([]ast.Expr, 0)
+	opening := p.advance()
+	// f?.(x): членов у значений пока нет, поэтому после ?. может идти
+	// только вызов
+	optional := false
+	if opening.Kind == lexer.QUESTION_DOT {
+		optional = true
+		expected := p.expectError(lexer.OPEN_PAREN, fmt.Sprintf("Expected '(' after '?.' at %s", p.currentToken().Position.String()))
+		if expected.Kind == lexer.ERROR {
+			return ast.Error{
+				Position: &expected.Position,
+			}
+		}
+	}
+	p.nesting++
+	defer func() { p.nesting-- }()
 
+	arguments := make([]ast.Expr, 0)
 	for p.hasTokens() && p.currentTokenKind() != lexer.CLOSE_PAREN {
 		expr := parseExpr(p, assignment)
 		if err, ok := expr.(ast.Error); ok {
@@ -228,12 +330,59 @@ func parseCallExpr(p *parser, left ast.Expr, bp bindingPower) ast.Expr {
 		}
 	}
 	return ast.CallExpr{
-		Caller: left,
-		Args:   arguments,
-		Position: lexer.Position{
-			StartPos: startPos,
-			EndPos:   expected.Position.EndPos,
-		},
+		Caller:   left,
+		Args:     arguments,
+		Optional: optional,
+		Position: lexer.Span(left.Pos(), expected.Position.EndPos),
+	}
+}
+
+func parseTemplateExpr(p *parser) ast.Expr {
+	start := p.advance().Position
+	p.nesting++
+	defer func() { p.nesting-- }()
+
+	parts := make([]ast.Expr, 0)
+
+	for p.hasTokens() && p.currentTokenKind() != lexer.TEMPLATE_END {
+		if p.currentTokenKind() == lexer.TEMPLATE_TEXT {
+			token := p.advance()
+			parts = append(parts, ast.StringLiteral{
+				Value:    token.Value,
+				Position: token.Position,
+			})
+			continue
+		}
+
+		expected := p.expect(lexer.INTERP_START)
+		if expected.Kind == lexer.ERROR {
+			return ast.Error{
+				Position: &expected.Position,
+			}
+		}
+		expr := parseExpr(p, defaultBP)
+		if err, ok := expr.(ast.Error); ok {
+			return err
+		}
+		parts = append(parts, expr)
+
+		expected = p.expectError(lexer.INTERP_END, "Expected '}' after template expression")
+		if expected.Kind == lexer.ERROR {
+			return ast.Error{
+				Position: &expected.Position,
+			}
+		}
+	}
+
+	expected := p.expect(lexer.TEMPLATE_END)
+	if expected.Kind == lexer.ERROR {
+		return ast.Error{
+			Position: &expected.Position,
+		}
+	}
+	return ast.TemplateExpr{
+		Parts:    parts,
+		Position: lexer.Span(start, expected.Position.EndPos),
 	}
 }
 
@@ -248,7 +397,6 @@ func parseConditionalExpr(p *parser, left ast.Expr, bp bindingPower) ast.Expr {
 		return err
 	}
 
-	
 	expected := p.expect(lexer.COLON)
 	if expected.Kind == lexer.ERROR {
 		return ast.Error{
@@ -264,9 +412,6 @@ func parseConditionalExpr(p *parser, left ast.Expr, bp bindingPower) ast.Expr {
 		Condition:  left,
 		Consequent: consequent,
 		Alternate:  alternate,
-		Position: lexer.Position{
-			StartPos: left.Pos().StartPos,
-			EndPos:   alternate.Pos().EndPos,
-		},
+		Position:   lexer.Span(left.Pos(), alternate.Pos().EndPos),
 	}
 }
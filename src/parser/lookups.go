@@ -10,16 +10,42 @@ type bindingPower int
 const (
 	defaultBP bindingPower = iota
 	assignment
+	pipeline
+	nullish
 	logical
+	logicalAnd
 	relational
+	bitwiseOr
+	bitwiseXor
+	bitwiseAnd
+	shift
 	additive
 	multiplicative
 	unary
+	exponent
 	call
 	member
 	primary
 )
 
+/*
+Приоритеты для печати дерева обратно в код: операнд, приоритет которого
+ниже нужного, берётся в скобки. Приоритет бинарного оператора
+возвращает Precedence.
+*/
+const (
+	PrecAssignment = int(assignment)
+	PrecPipeline   = int(pipeline)
+	PrecLogical    = int(logical)
+	PrecUnary      = int(unary)
+	PrecCall       = int(call)
+	PrecPrimary    = int(primary)
+)
+
+func Precedence(kind lexer.TokenKind) int {
+	return int(bpLU[kind])
+}
+
 type stmtHandler func(p *parser) ast.Stmt
 type NUDHandler func(p *parser) ast.Expr
 type LEDHandler func(p *parser, left ast.Expr, bp bindingPower) ast.Expr
@@ -40,7 +66,10 @@ func stmt(kind lexer.TokenKind, stmt_fn stmtHandler) {
 }
 
 func NUD(kind lexer.TokenKind, nudFn NUDHandler) {
-	bpLU[kind] = primary
+	// Не перетираем силу связывания инфиксной формы того же токена (-)
+	if _, exists := bpLU[kind]; !exists {
+		bpLU[kind] = primary
+	}
 	nudLU[kind] = nudFn
 }
 
@@ -54,9 +83,26 @@ func createTokenLookups() {
 	LED(lexer.ASSIGNMENT, assignment, parseAssignExpr)
 	LED(lexer.PLUS_EQUALS, assignment, parseAssignExpr)
 	LED(lexer.MINUS_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.STAR_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.SLASH_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.PERCENT_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.STAR_STAR_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.TILDE_SLASH_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.AMPERSAND_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.PIPE_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.CARET_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.SHIFT_LEFT_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.SHIFT_RIGHT_EQUALS, assignment, parseAssignExpr)
+	LED(lexer.QUESTION_QUESTION_EQUALS, assignment, parseAssignExpr)
+
+	// Pipeline: x |> f(a) разбирается сразу как f(x, a)
+	LED(lexer.PIPELINE, pipeline, parsePipelineExpr)
+
+	// Nullish
+	LED(lexer.QUESTION_QUESTION, nullish, parseBinaryExpr)
 
 	// Logical
-	LED(lexer.AND, logical, parseBinaryExpr)
+	LED(lexer.AND, logicalAnd, parseBinaryExpr)
 	LED(lexer.OR, logical, parseBinaryExpr)
 
 	// Relational
@@ -67,12 +113,21 @@ func createTokenLookups() {
 	LED(lexer.EQUALS, relational, parseBinaryExpr)
 	LED(lexer.NOT_EQUALS, relational, parseBinaryExpr)
 
+	// Bitwise
+	LED(lexer.PIPE, bitwiseOr, parseBinaryExpr)
+	LED(lexer.CARET, bitwiseXor, parseBinaryExpr)
+	LED(lexer.AMPERSAND, bitwiseAnd, parseBinaryExpr)
+	LED(lexer.SHIFT_LEFT, shift, parseBinaryExpr)
+	LED(lexer.SHIFT_RIGHT, shift, parseBinaryExpr)
+
 	// Additive & Multiplicitave
 	LED(lexer.PLUS, additive, parseBinaryExpr)
 	LED(lexer.MINUS, additive, parseBinaryExpr)
 	LED(lexer.SLASH, multiplicative, parseBinaryExpr)
 	LED(lexer.STAR, multiplicative, parseBinaryExpr)
 	LED(lexer.PERCENT, multiplicative, parseBinaryExpr)
+	LED(lexer.TILDE_SLASH, multiplicative, parseBinaryExpr)
+	LED(lexer.STAR_STAR, exponent, parseBinaryExpr)
 
 	// Literals & Symbols
 	NUD(lexer.INT, parsePrimaryExpr)
@@ -81,10 +136,14 @@ func createTokenLookups() {
 	NUD(lexer.IDENTIFIER, parsePrimaryExpr)
 	NUD(lexer.TRUE, parsePrimaryExpr)
 	NUD(lexer.FALSE, parsePrimaryExpr)
+	NUD(lexer.NULL, parsePrimaryExpr)
+	NUD(lexer.UNDEFINED, parsePrimaryExpr)
+	NUD(lexer.TEMPLATE_START, parseTemplateExpr)
 
 	// Unary/Prefix
 	NUD(lexer.MINUS, parseUnaryExpr)
 	NUD(lexer.NOT, parseUnaryExpr)
+	NUD(lexer.TILDE, parseUnaryExpr)
 	NUD(lexer.PLUS_PLUS, parseUnaryExpr)
 	NUD(lexer.MINUS_MINUS, parseUnaryExpr)
 	LED(lexer.PLUS_PLUS, unary, parseLedUnaryExpr)
@@ -95,7 +154,8 @@ func createTokenLookups() {
 	// LED(lexer.DOT, member, parseMemberExpr)
 	// LED(lexer.OPEN_BRACKET, member, parseMemberExpr)
 	LED(lexer.OPEN_PAREN, call, parseCallExpr)
-	LED(lexer.OPEN_PAREN, logical, parseConditionalExpr)
+	LED(lexer.QUESTION_DOT, call, parseCallExpr)
+	LED(lexer.QUESTION, logical, parseConditionalExpr)
 
 	// Grouping Expr
 	NUD(lexer.OPEN_PAREN, parseGroupingExpr)
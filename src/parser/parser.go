@@ -2,61 +2,156 @@ package parser
 
 import (
 	"finescript/src/ast"
+	"finescript/src/helpers"
 	"finescript/src/lexer"
 	"fmt"
+	"strings"
 )
 
+/*
+Источник токенов: срез из Tokenize или потоковый лексер. После
+последнего токена возвращает EOF.
+*/
+type tokenSource interface {
+	Next() lexer.Token
+}
+
 type parser struct {
-	tokens        []lexer.Token
-	pos           int
-	initialSource string
-	errors        []string
+	source  tokenSource
+	current lexer.Token
+	// Конец последнего разобранного токена: у узлов вроде int своей
+	// позиции нет
+	end int
+	// Текст исходного кода для фрагментов в сообщениях об ошибках
+	text   func(pos lexer.Position) string
+	errors []lexer.Error
+	// После ошибки разбор идёт до ближайшей границы инструкции, и
+	// вызванные первой ошибкой новые не сообщаются
+	panicking bool
+	// Глубина вложенности в скобки (), где переводы строк не завершают
+	// выражение
+	nesting int
+	// Сколько скобок () и шаблонных строк было открыто в месте первой
+	// ошибки. synchronize сначала пропускает их до закрытия
+	unclosed int
+	// Комментарии из trivia токенов, если лексер их сохранил
+	comments []ast.Comment
+}
+
+type sliceSource struct {
+	tokens []lexer.Token
+	pos    int
+}
+
+func (s *sliceSource) Next() lexer.Token {
+	if s.pos >= len(s.tokens) {
+		end := 0
+		if len(s.tokens) > 0 {
+			end = s.tokens[len(s.tokens)-1].Position.EndPos
+		}
+		return lexer.Token{Kind: lexer.EOF, Value: "eof", Position: lexer.Position{StartPos: end, EndPos: end}}
+	}
+	token := s.tokens[s.pos]
+	s.pos++
+	return token
 }
 
-func newParser(tokens []lexer.Token, initialSource string) *parser {
+/*
+Таблицы разбора заполняются один раз при загрузке пакета и дальше только
+читаются, поэтому Parse можно вызывать из нескольких горутин сразу.
+*/
+func init() {
 	createTokenLookups()
 	createTypeTokenLookups()
+}
 
+func newParser(source tokenSource, text func(pos lexer.Position) string) *parser {
 	p := &parser{
-		tokens:        tokens,
-		pos:           0,
-		initialSource: initialSource,
-		errors:        make([]string, 0),
+		source: source,
+		text:   text,
+		errors: make([]lexer.Error, 0),
 	}
+	p.next()
 
 	return p
 }
 
 func Parse(tokens []lexer.Token, initialSource string) (ast.Program, []string) {
-	p := newParser(tokens, initialSource)
-	body := make([]ast.Stmt, 0)
+	program, errs := ParseErrors(tokens, initialSource)
+	return program, lexer.Messages(errs)
+}
 
-	for p.hasTokens() {
-		body = append(body, parseStmt(p))
-	}
+/*
+Как Parse, но ошибки с позициями, например для подсветки в редакторе.
+*/
+func ParseErrors(tokens []lexer.Token, initialSource string) (ast.Program, []lexer.Error) {
+	p := newParser(&sliceSource{tokens: tokens}, func(pos lexer.Position) string {
+		return helpers.Snippet(initialSource, pos.StartPos, pos.EndPos)
+	})
+	return parseProgram(p)
+}
+
+/*
+Разбирает программу, забирая токены из потока по мере надобности.
+Ошибки лексера накапливаются в stream.Errors() и проверяются отдельно.
+*/
+func ParseStream(stream *lexer.Stream) (ast.Program, []string) {
+	program, errs := parseProgram(newParser(stream, stream.Text))
+	return program, lexer.Messages(errs)
+}
+
+func parseProgram(p *parser) (ast.Program, []lexer.Error) {
+	body := parseStmts(p, lexer.EOF)
 
+	end := 0
+	if len(body) > 0 {
+		end = body[len(body)-1].Pos().EndPos
+	}
 	return ast.Program{
-			Body: body,
+			Body:     body,
+			Comments: p.comments,
 			Position: lexer.Position{
 				StartPos: 0,
-				EndPos:   body[len(body)-1].Pos().EndPos,
+				EndPos:   end,
+				Line:     1,
+				Column:   1,
 			},
 		},
 		p.errors
 }
 
 func (p *parser) currentToken() lexer.Token {
-	return p.tokens[p.pos]
+	return p.current
 }
 
 func (p *parser) advance() lexer.Token {
-	tk := p.currentToken()
-	p.pos++
+	tk := p.current
+	// EOF повторяется, его комментарии уже собраны
+	if tk.Kind != lexer.EOF {
+		p.end = tk.Position.EndPos
+		p.next()
+	}
 	return tk
 }
 
+func (p *parser) next() {
+	// Строка, на которой закончился предыдущий токен
+	line := p.current.Position.Line + strings.Count(p.current.Raw, "\n")
+
+	p.current = p.source.Next()
+	for _, trivia := range p.current.Leading {
+		if trivia.IsComment() {
+			p.comments = append(p.comments, ast.Comment{
+				Text:     trivia.Text,
+				Position: trivia.Position,
+				Trailing: trivia.Position.Line == line,
+			})
+		}
+	}
+}
+
 func (p *parser) hasTokens() bool {
-	return p.pos < len(p.tokens) && p.currentTokenKind() != lexer.EOF
+	return p.currentTokenKind() != lexer.EOF
 }
 
 // func (p *parser) nextToken() lexer.Token {
@@ -75,20 +170,23 @@ func (p *parser) expectError(expectedKind lexer.TokenKind, err any) lexer.Token
 	token := p.currentToken()
 	if token.Kind != expectedKind {
 		if err == nil {
-			p.errors = append(p.errors, fmt.Sprintf("Syntax error: expected %s but got %s (\"%s\") at %s:\n%s",
+			message := fmt.Sprintf("Syntax error: expected %s but got %s (\"%s\")",
 				lexer.TokenKindString(expectedKind),
 				lexer.TokenKindString(token.Kind),
 				token.Value,
-				token.Position.String(),
-				p.initialSource[token.Position.StartPos:token.Position.EndPos],
-			))
+			)
+			p.report(lexer.Error{
+				Message:  message,
+				Position: token.Position,
+				Text:     fmt.Sprintf("%s at %s:\n%s", message, token.Position.String(), p.text(token.Position)),
+			})
 			return lexer.Token{
 				Kind:     lexer.ERROR,
 				Value:    token.Position.String(),
 				Position: token.Position,
 			}
 		} else {
-			p.errors = append(p.errors, p.error(err, &token.Position))
+			p.report(p.error(err, &token.Position))
 			return lexer.Token{
 				Kind:     lexer.ERROR,
 				Value:    token.Position.String(),
@@ -99,15 +197,155 @@ func (p *parser) expectError(expectedKind lexer.TokenKind, err any) lexer.Token
 	return p.advance()
 }
 
+/*
+Правило автоматической ';': перевод строки завершает выражение, если
+следующая строка начинается с токена, который сам может начать
+выражение. Поэтому a\n(b) и a\n- b - две инструкции (о второй
+предупреждает правило линтера split-line), а a\n+ b, x\n|> f и
+a\n? b : c - продолжение. Внутри скобок переводы строк не значимы.
+*/
+func (p *parser) atLineBreak(startsExpr func(kind lexer.TokenKind) bool) bool {
+	token := p.currentToken()
+	return token.NewlineBefore && p.nesting == 0 && startsExpr(token.Kind)
+}
+
+/*
+Конец инструкции: ';', перевод строки, '}' блока или конец входа.
+Две инструкции на одной строке без ';' - ошибка.
+*/
+func (p *parser) expectStmtEnd() {
+	token := p.currentToken()
+	switch {
+	case token.Kind == lexer.SEMI_COLON:
+		p.advance()
+	case token.Kind == lexer.EOF, token.Kind == lexer.CLOSE_CURLY, token.NewlineBefore:
+	default:
+		p.report(p.error(fmt.Sprintf("Expected ';' or a new line after statement but got %s", lexer.TokenKindString(token.Kind)), &token.Position))
+	}
+}
+
+/*
+Сообщает об ошибке, если разбор не восстанавливается после предыдущей.
+*/
+func (p *parser) report(err lexer.Error) {
+	if !p.panicking {
+		p.errors = append(p.errors, err)
+		p.unclosed = p.nesting
+	}
+	p.panicking = true
+}
+
+/*
+Пропускает токены после ошибки до границы инструкции: после ';', перед
+'}' охватывающего блока или ключевым словом, с которого начинается
+инструкция, либо после блока { ... }, если за ним не идёт else.
+Вложенные блоки пропускаются целиком.
+*/
+func (p *parser) synchronize() {
+	p.skipUnclosed()
+	depth := 0
+	for p.hasTokens() {
+		switch p.currentTokenKind() {
+		case lexer.OPEN_CURLY:
+			depth++
+		case lexer.CLOSE_CURLY:
+			if depth == 0 {
+				p.panicking = false
+				return
+			}
+			depth--
+			if depth == 0 {
+				p.advance()
+				if p.currentTokenKind() != lexer.ELSE {
+					p.panicking = false
+					return
+				}
+				continue
+			}
+		case lexer.SEMI_COLON:
+			if depth == 0 {
+				p.advance()
+				p.panicking = false
+				return
+			}
+		case lexer.LET, lexer.VAR, lexer.CONST, lexer.FUN, lexer.IF, lexer.TYPE:
+			if depth == 0 {
+				p.panicking = false
+				return
+			}
+		}
+		p.advance()
+	}
+	p.panicking = false
+}
+
+/*
+Пропускает остаток скобок () и шаблонных строк, внутри которых случилась
+ошибка: их токены до закрытия относятся к тому же выражению, и разбор
+их как инструкций дал бы новые ошибки. Если скобка так и не закрылась,
+пропуск останавливается на ';', '}' охватывающего блока или ключевом
+слове инструкции в начале строки.
+*/
+func (p *parser) skipUnclosed() {
+	depth, curly := 0, 0
+	for p.unclosed > 0 && p.hasTokens() {
+		token := p.currentToken()
+		switch token.Kind {
+		case lexer.OPEN_PAREN, lexer.TEMPLATE_START:
+			depth++
+		case lexer.CLOSE_PAREN, lexer.TEMPLATE_END:
+			if depth == 0 {
+				p.unclosed--
+			} else {
+				depth--
+			}
+		case lexer.OPEN_CURLY:
+			curly++
+		case lexer.CLOSE_CURLY:
+			if curly == 0 {
+				p.unclosed = 0
+				return
+			}
+			curly--
+		case lexer.SEMI_COLON:
+			if curly == 0 {
+				p.unclosed = 0
+				return
+			}
+		case lexer.LET, lexer.VAR, lexer.CONST, lexer.FUN, lexer.IF, lexer.TYPE:
+			if token.NewlineBefore && curly == 0 {
+				p.unclosed = 0
+				return
+			}
+		}
+		p.advance()
+	}
+	p.unclosed = 0
+}
+
 func (p *parser) expect(expectedKind lexer.TokenKind) lexer.Token {
 	return p.expectError(expectedKind, nil)
 }
 
-func (p *parser) error(err any, pos *lexer.Position) string {
+func (p *parser) error(err any, pos *lexer.Position) lexer.Error {
 	if pos == nil {
 		tokenPos := p.currentToken().Position
-		return fmt.Sprintf("Parser Error at %s:\n%s\n%s", tokenPos.String(), p.initialSource[tokenPos.StartPos:tokenPos.EndPos], err)
-	} else {
-		return fmt.Sprintf("Parser Error at %s:\n%s\n%s", pos.String(), p.initialSource[pos.StartPos:pos.EndPos], err)
+		pos = &tokenPos
+	}
+	return lexer.Error{
+		Message:  fmt.Sprint(err),
+		Position: *pos,
+		Text:     fmt.Sprintf("Parser Error at %s:\n%s\n%s", pos.String(), p.text(*pos), err),
+	}
+}
+
+/*
+Ошибка, в тексте которой позиция уже указана.
+*/
+func located(message string, pos lexer.Position) lexer.Error {
+	return lexer.Error{
+		Message:  message,
+		Position: pos,
+		Text:     message,
 	}
 }
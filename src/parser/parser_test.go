@@ -0,0 +1,194 @@
+package parser
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func parse(t *testing.T, source string) []string {
+	t.Helper()
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	_, errs = Parse(tokens, source)
+	return errs
+}
+
+/*
+Запускать с -race: разбор из многих горутин не должен гоняться за
+таблицами разбора.
+*/
+func TestParseConcurrently(t *testing.T) {
+	sources := []string{
+		"var a = 1 + 2 * 3\nprintln(a)",
+		"fun f(x: int): int { x > 0 ? x : -x }\nf(-2)",
+		"type Point = struct { x: int, y: int }",
+		"if true { 1 } else if false { 2 } else { 3 }",
+		"var s = `a ${1 + 2} b`",
+	}
+	tokens := make([][]lexer.Token, len(sources))
+	for i, source := range sources {
+		var errs []string
+		if tokens[i], errs = lexer.Tokenize(source); len(errs) > 0 {
+			t.Fatal(strings.Join(errs, "\n"))
+		}
+	}
+	var wg sync.WaitGroup
+	for i := range 64 {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, errs := Parse(tokens[i], sources[i]); len(errs) > 0 {
+				t.Errorf("%q: %s", sources[i], strings.Join(errs, "\n"))
+			}
+		}(i % len(sources))
+	}
+	wg.Wait()
+}
+
+func TestConditionalExpr(t *testing.T) {
+	for _, source := range []string{"true ? 1 : 2", "f(1) ? g(2) : h(3)"} {
+		if errs := parse(t, source); len(errs) > 0 {
+			t.Errorf("%q: %s", source, strings.Join(errs, "\n"))
+		}
+	}
+}
+
+/*
+Вызов в конвейере указывает на свой этап, а не на начало цепочки.
+*/
+func TestPipelineStagePosition(t *testing.T) {
+	source := "var y = 1\ny\n  |> inc\n  |> add(2, _)"
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	program, errs := Parse(tokens, source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	stmt := program.Body[1].(ast.ExprStmt)
+	if pos := stmt.Position; pos.Line != 2 || pos.Column != 1 {
+		t.Errorf("statement at %d:%d, want 2:1", pos.Line, pos.Column)
+	}
+	outer := stmt.Expr.(ast.CallExpr)
+	if pos := outer.Position; pos.Line != 4 || pos.Column != 6 {
+		t.Errorf("last stage at %d:%d, want 4:6", pos.Line, pos.Column)
+	}
+	inner := outer.Args[1].(ast.CallExpr)
+	if pos := inner.Position; pos.Line != 3 || pos.Column != 6 {
+		t.Errorf("first stage at %d:%d, want 3:6", pos.Line, pos.Column)
+	}
+}
+
+/*
+_ нельзя объявить, иначе такую переменную перекрыл бы плейсхолдер
+конвейера. Следующая инструкция после ошибки разбирается как обычно.
+*/
+func TestPlaceholderIsNotAName(t *testing.T) {
+	message := "_ cannot be used as a name, it is reserved for the pipeline placeholder"
+	tests := []struct {
+		source string
+		at     string
+	}{
+		{"var _ = 1\nvar a = 2", "1:5"},
+		{"const _ = 1", "1:7"},
+		{"fun _() { 1 }", "1:5"},
+		{"fun f(a: int, _: int) { a }", "1:15"},
+		{"type _ = int", "1:6"},
+		{"type P = struct { m(_: int) }", "1:21"},
+	}
+	for _, tt := range tests {
+		errs := parse(t, tt.source)
+		want := "Parser Error at " + tt.at + ":\n_\n" + message
+		if len(errs) == 0 || errs[0] != want {
+			t.Errorf("%q: errors %q, want first %q", tt.source, errs, want)
+		}
+	}
+
+	// Внутри этапа конвейера _ - плейсхолдер, а не имя
+	for _, source := range []string{"1 |> f(2, _)", "var __ = 1\n__ |> f(_)"} {
+		if errs := parse(t, source); len(errs) > 0 {
+			t.Errorf("%q: %s", source, strings.Join(errs, "\n"))
+		}
+	}
+}
+
+/*
+После ошибки внутри скобок или шаблонной строки разбор продолжается за
+их закрытием: остаток выражения не даёт новых ошибок, а следующая
+инструкция разбирается как обычно.
+*/
+func TestRecoverInsideParens(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"println(if true {1} else {2})\nvar x = 1", "NUD Handler expected for token if at 1:9"},
+		{"var s = `${ {} }`\nvar x = 1", "NUD Handler expected for token open_curly at 1:13"},
+		{"println(`a ${ {} } b ${1}`, (2 + ))\nvar x = 1", "NUD Handler expected for token open_curly at 1:15"},
+		{"println((1 +) * 2)\nvar x = 1", "NUD Handler expected for token close_paren at 1:13"},
+		{"println(1 2)\nvar x = 1", "Expected ',' between parameters"},
+		{"println(1\nvar x = 1", "Expected ',' between parameters"},
+	}
+	for _, tt := range tests {
+		tokens, errs := lexer.Tokenize(tt.source)
+		if len(errs) > 0 {
+			t.Fatal(strings.Join(errs, "\n"))
+		}
+		program, errs := Parse(tokens, tt.source)
+		if len(errs) != 1 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: errors %q, want one with %q", tt.source, errs, tt.want)
+		}
+		last := program.Body[len(program.Body)-1]
+		if decl, ok := last.(ast.VarDeclStmt); !ok || decl.Name != "x" {
+			t.Errorf("%q: last statement %T, want var x", tt.source, last)
+		}
+	}
+}
+
+/*
+Перевод строки завершает выражение, только если следующая строка сама
+может начать выражение.
+*/
+func TestLineContinuation(t *testing.T) {
+	tests := []struct {
+		source string
+		stmts  int
+	}{
+		{"a\n+ 1", 1},
+		{"a\n* 2\n/ 3", 1},
+		{"a -\n1", 1},
+		{"a\n|> f", 1},
+		{"a\n? b : c", 1},
+		{"a\n?? b", 1},
+		{"a\n== b", 1},
+		{"a\n= 1", 1},
+		{"f(a\n- 1)", 1},
+		{"(a\n- 1)", 1},
+		{"`${a\n- 1}`", 1},
+		{"a\n- 1", 2},
+		{"a\n++b", 2},
+		{"a\n(b)", 2},
+		{"a\n!b", 2},
+		{"var x = a\n- 1", 2},
+	}
+	for _, tt := range tests {
+		tokens, errs := lexer.Tokenize(tt.source)
+		if len(errs) > 0 {
+			t.Fatal(strings.Join(errs, "\n"))
+		}
+		program, errs := Parse(tokens, tt.source)
+		if len(errs) > 0 {
+			t.Errorf("%q: %s", tt.source, strings.Join(errs, "\n"))
+			continue
+		}
+		if len(program.Body) != tt.stmts {
+			t.Errorf("%q: %d statements, want %d", tt.source, len(program.Body), tt.stmts)
+		}
+	}
+}
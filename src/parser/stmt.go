@@ -14,35 +14,80 @@ func parseStmt(p *parser) ast.Stmt {
 	return parseExprStmt(p)
 }
 
+/*
+Инструкции до токена end ('}' блока или конца входа). Инструкция
+с ошибкой остаётся в результате, а разбор продолжается со следующей,
+поэтому частичное дерево получается и для сломанного файла.
+*/
+func parseStmts(p *parser, end lexer.TokenKind) []ast.Stmt {
+	body := make([]ast.Stmt, 0)
+
+	for p.hasTokens() && p.currentTokenKind() != end {
+		start := p.currentToken()
+		body = append(body, parseStmt(p))
+
+		if p.panicking {
+			p.synchronize()
+		}
+		// Токен, с которого не начинается ни одна инструкция (лишняя '}'
+		// или else), пропускаем, чтобы не сообщать о нём снова
+		if current := p.currentToken(); current.Kind != lexer.EOF && current.Kind == start.Kind && current.Position == start.Position {
+			p.advance()
+		}
+	}
+
+	return body
+}
+
 func parseExprStmt(p *parser) ast.ExprStmt {
+	start := p.currentToken()
 	expr := parseExpr(p, defaultBP)
+	if _, ok := expr.(ast.Error); ok {
+		return ast.ExprStmt{
+			Expr:     expr,
+			Position: expr.Pos(),
+		}
+	}
 
+	// Точка с запятой завершает инструкцию, а не вложенное выражение:
+	// иначе в a = b + c; правый операнд забирал бы её себе
+	p.expectStmtEnd()
+
+	// Позиция вызова в конвейере - это позиция этапа, а инструкция
+	// начинается со значения слева
 	return ast.ExprStmt{
 		Expr:     expr,
-		Position: expr.Pos(),
+		Position: lexer.Span(start.Position, expr.Pos().EndPos),
 	}
 }
 
 func parseBlockStmt(p *parser) ast.Stmt {
-	startPos := p.advance().Position.StartPos
-	body := []ast.Stmt{}
+	return parseBlock(p)
+}
 
-	for p.hasTokens() && p.currentTokenKind() != lexer.CLOSE_CURLY {
-		body = append(body, parseStmt(p))
+/*
+Блок { ... }. Даже без скобок возвращается блок с тем, что удалось
+разобрать, а об ошибке сообщается как обычно.
+*/
+func parseBlock(p *parser) ast.BlockStmt {
+	opening := p.expect(lexer.OPEN_CURLY)
+	if opening.Kind == lexer.ERROR {
+		return ast.BlockStmt{
+			Body:     []ast.Stmt{},
+			Position: opening.Position,
+		}
 	}
 
+	body := parseStmts(p, lexer.CLOSE_CURLY)
+
 	expected := p.expect(lexer.CLOSE_CURLY)
-	if expected.Kind == lexer.ERROR {
-		return ast.Error{
-			Position: &expected.Position,
-		}
+	end := expected.Position.EndPos
+	if expected.Kind == lexer.ERROR && len(body) > 0 {
+		end = body[len(body)-1].Pos().EndPos
 	}
 	return ast.BlockStmt{
-		Body: body,
-		Position: lexer.Position{
-			StartPos: startPos,
-			EndPos:   expected.Position.EndPos,
-		},
+		Body:     body,
+		Position: lexer.Span(opening.Position, end),
 	}
 }
 
@@ -52,7 +97,7 @@ func parseVarDecl(p *parser) ast.Stmt {
 	identName := p.expectError(lexer.IDENTIFIER,
 		fmt.Sprintf("Following %s expected variable name however instead recieved %s instead\n",
 			lexer.TokenKindString(startToken.Kind), lexer.TokenKindString(p.currentTokenKind())))
-	if identName.Kind == lexer.ERROR {
+	if identName.Kind == lexer.ERROR || placeholderName(p, identName) {
 		return ast.Error{
 			Position: &identName.Position,
 		}
@@ -63,6 +108,14 @@ func parseVarDecl(p *parser) ast.Stmt {
 	if p.currentTokenKind() == lexer.ASSIGNMENT {
 		p.advance()
 		assignmentValue = parseExpr(p, assignment)
+		if err, ok := assignmentValue.(ast.Error); ok {
+			return ast.VarDeclStmt{
+				IsConstant: isConstant,
+				Name:       identName.Value,
+				Value:      err,
+				Position:   lexer.Span(startToken.Position, identName.Position.EndPos),
+			}
+		}
 	}
 
 	var endPos int
@@ -73,24 +126,24 @@ func parseVarDecl(p *parser) ast.Stmt {
 		if assignmentValue != nil {
 			endPos = assignmentValue.Pos().EndPos
 		}
+		p.expectStmtEnd()
 	}
 
 	if isConstant && assignmentValue == nil {
-		p.errors = append(p.errors, "Cannot define constant variable without providing default value.")
+		p.errors = append(p.errors, located("Cannot define constant variable without providing default value.", identName.Position))
 	}
 
-	if assignmentValue != nil {
-		assignmentValue = ast.UndefinedLiteral{}
+	if assignmentValue == nil {
+		assignmentValue = ast.UndefinedLiteral{
+			Position: identName.Position,
+		}
 	}
 
 	return ast.VarDeclStmt{
 		IsConstant: isConstant,
 		Name:       identName.Value,
 		Value:      assignmentValue,
-		Position: lexer.Position{
-			StartPos: startToken.Position.StartPos,
-			EndPos:   endPos,
-		},
+		Position:   lexer.Span(startToken.Position, endPos),
 	}
 }
 
@@ -100,7 +153,7 @@ func parseParams(p *parser) ([]ast.Param, ast.Error) {
 		expectedName := p.expect(lexer.IDENTIFIER)
 		name := expectedName.Value
 
-		if expectedName.Kind == lexer.ERROR {
+		if expectedName.Kind == lexer.ERROR || placeholderName(p, expectedName) {
 			return params, ast.Error{
 				Position: &expectedName.Position,
 			}
@@ -131,10 +184,10 @@ func parseParams(p *parser) ([]ast.Param, ast.Error) {
 }
 
 func parseFunDecl(p *parser) ast.Stmt {
-	startPos := p.advance().Position.StartPos
+	start := p.advance().Position
 	expectedName := p.expect(lexer.IDENTIFIER)
 	name := expectedName.Value
-	if expectedName.Kind == lexer.ERROR {
+	if expectedName.Kind == lexer.ERROR || placeholderName(p, expectedName) {
 		return ast.Error{
 			Position: &expectedName.Position,
 		}
@@ -169,7 +222,7 @@ func parseFunDecl(p *parser) ast.Stmt {
 
 	var endPos int
 	if p.currentTokenKind() == lexer.OPEN_CURLY {
-		blockStmt := parseBlockStmt(p).(ast.BlockStmt)
+		blockStmt := parseBlock(p)
 		endPos = blockStmt.Pos().EndPos
 		body = blockStmt.Body
 	} else {
@@ -183,22 +236,18 @@ func parseFunDecl(p *parser) ast.Stmt {
 		Params:     params,
 		Body:       body,
 		ReturnType: returnType,
-		Position: lexer.Position{
-			StartPos: startPos,
-			EndPos:   endPos,
-		},
+		Position:   lexer.Span(start, endPos),
 	}
 }
 
 func parseIfStmt(p *parser) ast.Stmt {
-	startPos := p.advance().Position.StartPos
+	start := p.advance().Position
 	condition := parseExpr(p, assignment)
-
-	if p.currentTokenKind() == lexer.SEMI_COLON {
-		p.advance()
+	if err, ok := condition.(ast.Error); ok {
+		return err
 	}
 
-	consequentBlockStmt := parseBlockStmt(p).(ast.BlockStmt)
+	consequentBlockStmt := parseBlock(p)
 	var endPos int = consequentBlockStmt.Pos().EndPos
 
 	var alternate []ast.Stmt
@@ -206,9 +255,11 @@ func parseIfStmt(p *parser) ast.Stmt {
 		p.advance()
 
 		if p.currentTokenKind() == lexer.IF {
-			alternate = []ast.Stmt{parseIfStmt(p)}
+			elseIf := parseIfStmt(p)
+			alternate = []ast.Stmt{elseIf}
+			endPos = elseIf.Pos().EndPos
 		} else {
-			alternateBlockStmt := parseBlockStmt(p).(ast.BlockStmt)
+			alternateBlockStmt := parseBlock(p)
 			alternate = alternateBlockStmt.Body
 			endPos = alternateBlockStmt.Pos().EndPos
 		}
@@ -218,18 +269,15 @@ func parseIfStmt(p *parser) ast.Stmt {
 		Condition:  condition,
 		Consequent: consequentBlockStmt.Body,
 		Alternate:  alternate,
-		Position: lexer.Position{
-			StartPos: startPos,
-			EndPos:   endPos,
-		},
+		Position:   lexer.Span(start, endPos),
 	}
 }
 
 func parseTypeDecl(p *parser) ast.Stmt {
-	startPos := p.advance().Position.StartPos
+	start := p.advance().Position
 	aliasExpected := p.expect(lexer.IDENTIFIER)
 	alias := aliasExpected.Value
-	if aliasExpected.Kind == lexer.ERROR {
+	if aliasExpected.Kind == lexer.ERROR || placeholderName(p, aliasExpected) {
 		return ast.Error{
 			Position: &aliasExpected.Position,
 		}
@@ -243,13 +291,11 @@ func parseTypeDecl(p *parser) ast.Stmt {
 	}
 
 	aliasType := parseType(p, defaultBP)
+	p.expectStmtEnd()
 
 	return ast.TypeAliasDecl{
-		Name: alias,
-		Type: aliasType,
-		Position: lexer.Position{
-			StartPos: startPos,
-			EndPos:   aliasType.Pos().EndPos,
-		},
+		Name:     alias,
+		Type:     aliasType,
+		Position: lexer.Span(start, aliasType.Pos().EndPos),
 	}
 }
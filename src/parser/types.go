@@ -54,7 +54,7 @@ func parseType(p *parser, bp bindingPower) ast.Type {
 	nudFn, exists := typeNUDLU[token.Kind]
 
 	if !exists {
-		p.errors = append(p.errors, fmt.Sprintf("TYPE_NUD Handler expected for token %s at %s\n", lexer.TokenKindString(token.Kind), token.Position.String()))
+		p.report(located(fmt.Sprintf("TYPE_NUD Handler expected for token %s at %s\n", lexer.TokenKindString(token.Kind), token.Position.String()), token.Position))
 		return ast.Error{
 			Position: &token.Position,
 		}
@@ -62,15 +62,12 @@ func parseType(p *parser, bp bindingPower) ast.Type {
 
 	left := nudFn(p)
 
-	for typeBPLU[p.currentTokenKind()] > bp {
+	for typeBPLU[p.currentTokenKind()] > bp && !p.atLineBreak(startsType) {
 		token = p.currentToken()
 		ledFn, exists := typeLEDLU[token.Kind]
 
 		if !exists {
-			p.errors = append(p.errors, fmt.Sprintf("TYPE_LED Handler expected for token %s at %s\n", lexer.TokenKindString(token.Kind), token.Position.String()))
-			return ast.Error{
-				Position: &token.Position,
-			}
+			break
 		}
 
 		left = ledFn(p, left, bp)
@@ -79,8 +76,13 @@ func parseType(p *parser, bp bindingPower) ast.Type {
 	return left
 }
 
+func startsType(kind lexer.TokenKind) bool {
+	_, exists := typeNUDLU[kind]
+	return exists
+}
+
 func parseStruct(p *parser) ast.Type {
-	startPos := p.advance().Position.StartPos
+	start := p.advance().Position
 	members := make([]ast.Member, 0)
 	properties := make([]ast.PropertySignature, 0)
 	methods := make([]ast.MethodSignature, 0)
@@ -102,8 +104,9 @@ func parseStruct(p *parser) ast.Type {
 		if p.currentTokenKind() == lexer.COLON {
 			p.advance()
 			properties = append(properties, ast.PropertySignature{
-				Name: name,
-				Type: parseType(p, defaultBP),
+				Name:     name,
+				Type:     parseType(p, defaultBP),
+				Position: lexer.Span(expectedName.Position, p.end),
 			})
 		} else {
 			expectedOpenParen := p.expect(lexer.OPEN_PAREN)
@@ -132,16 +135,19 @@ func parseStruct(p *parser) ast.Type {
 			}
 
 			methods = append(methods, ast.MethodSignature{
-				Name:   name,
-				Params: params,
-				Type:   methodType,
+				Name:     name,
+				Params:   params,
+				Type:     methodType,
+				Position: lexer.Span(expectedName.Position, p.end),
 			})
 		}
 
 		if p.currentTokenKind() != lexer.CLOSE_CURLY {
 			expected := p.expectError(lexer.COMMA, fmt.Sprintf("Expected ',' between properties in structure declaration at %s", p.currentToken().Position.String()))
-			return ast.Error{
-				Position: &expected.Position,
+			if expected.Kind == lexer.ERROR {
+				return ast.Error{
+					Position: &expected.Position,
+				}
 			}
 		}
 	}
@@ -161,11 +167,8 @@ func parseStruct(p *parser) ast.Type {
 	}
 
 	return ast.Struct{
-		Members: members,
-		Position: lexer.Position{
-			StartPos: startPos,
-			EndPos:   expectedCloseCurly.Position.EndPos,
-		},
+		Members:  members,
+		Position: lexer.Span(start, expectedCloseCurly.Position.EndPos),
 	}
 }
 
@@ -206,7 +209,7 @@ func parsePrimaryType(p *parser) ast.Type {
 		p.advance()
 		return ast.VoidKeyword{}
 	default:
-		p.errors = append(p.errors, fmt.Sprintf("Cannot create primary_expr from %s at %s", lexer.TokenKindString(token.Kind), token.Position.String()))
+		p.report(located(fmt.Sprintf("Cannot create primary_expr from %s at %s", lexer.TokenKindString(token.Kind), token.Position.String()), token.Position))
 		return ast.Error{
 			Position: &token.Position,
 		}
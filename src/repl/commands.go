@@ -0,0 +1,221 @@
+package repl
+
+import (
+	"finescript/src/ast"
+	"finescript/src/formatter"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"finescript/src/runtime"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sanity-io/litter"
+)
+
+/*
+Команда сессии: :name arg. Аргумент в квадратных скобках необязателен.
+*/
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(r *REPL, arg string) (*runtime.ExitSignal, error)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"type", "expr", "Evaluates the expression and prints the type of its value", (*REPL).typeCommand},
+		{"ast", "code", "Prints the syntax tree of the code", (*REPL).astCommand},
+		{"tokens", "code", "Prints the tokens of the code", (*REPL).tokensCommand},
+		{"env", "", "Lists the variables declared in the session", (*REPL).envCommand},
+		{"load", "file", "Runs the file in the session", (*REPL).loadCommand},
+		{"reset", "", "Clears all declarations of the session", (*REPL).resetCommand},
+		{"help", "[name]", "Lists the commands or shows the signature and documentation of a command, function or type", (*REPL).helpCommand},
+		{"quit", "", "Ends the session", (*REPL).quitCommand},
+	}
+}
+
+func (r *REPL) command(line string) (*runtime.ExitSignal, error) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if c.args != "" && !strings.HasPrefix(c.args, "[") && arg == "" {
+			return nil, fmt.Errorf("Usage: :%s %s", c.name, c.args)
+		}
+		return c.run(r, arg)
+	}
+	return nil, fmt.Errorf("Unknown command :%s, type :help to list the commands", name)
+}
+
+func (r *REPL) typeCommand(arg string) (*runtime.ExitSignal, error) {
+	value, exit, err := r.execute(arg)
+	if err == nil && exit == nil && value != nil {
+		fmt.Fprintln(r.out, typeOf(value))
+	}
+	return exit, err
+}
+
+func (r *REPL) astCommand(arg string) (*runtime.ExitSignal, error) {
+	tokens, errs := lexer.Tokenize(arg)
+	if len(errs) > 0 {
+		return nil, errorList(errs)
+	}
+	program, errs := parser.Parse(tokens, arg)
+	if len(errs) > 0 {
+		return nil, errorList(errs)
+	}
+	fmt.Fprintln(r.out, litter.Sdump(program.Body))
+	return nil, nil
+}
+
+func (r *REPL) tokensCommand(arg string) (*runtime.ExitSignal, error) {
+	tokens, errs := lexer.Tokenize(arg)
+	if len(errs) > 0 {
+		return nil, errorList(errs)
+	}
+	fmt.Fprintln(r.out, litter.Sdump(tokens))
+	return nil, nil
+}
+
+/*
+Встроенные функции не показываются.
+*/
+func (r *REPL) envCommand(arg string) (*runtime.ExitSignal, error) {
+	for _, name := range r.env.Names() {
+		value, _ := r.env.Lookup(name)
+		switch value.(type) {
+		case runtime.NativeFnVal:
+		case runtime.FunctionVal, runtime.CompiledFnVal, runtime.TypeAliasVal, runtime.EnvironmentVal:
+			fmt.Fprintf(r.out, "%s: %s\n", name, typeOf(value))
+		default:
+			fmt.Fprintf(r.out, "%s: %s = %s\n", name, typeOf(value), runtime.Display(value))
+		}
+	}
+	return nil, nil
+}
+
+func (r *REPL) loadCommand(arg string) (*runtime.ExitSignal, error) {
+	source, err := os.ReadFile(arg)
+	if err != nil {
+		return nil, err
+	}
+	_, exit, err := r.execute(string(source))
+	return exit, err
+}
+
+func (r *REPL) resetCommand(arg string) (*runtime.ExitSignal, error) {
+	r.env = runtime.NewGlobalEnv(r.caps)
+	return nil, nil
+}
+
+/*
+Без аргумента - список команд. Для встроенной функции показываются
+сигнатура и описание, для функций и типов сессии - их объявление.
+*/
+func (r *REPL) helpCommand(arg string) (*runtime.ExitSignal, error) {
+	if arg == "" {
+		for _, c := range commands {
+			fmt.Fprintf(r.out, "%-16s %s\n", strings.TrimSpace(":"+c.name+" "+c.args), c.usage)
+		}
+		return nil, nil
+	}
+
+	for _, c := range commands {
+		if ":"+c.name == arg || c.name == arg {
+			fmt.Fprintf(r.out, "%s\n\n%s\n", strings.TrimSpace(":"+c.name+" "+c.args), c.usage)
+			return nil, nil
+		}
+	}
+
+	value, ok := r.env.Lookup(arg)
+	if !ok {
+		return nil, fmt.Errorf("No help for %q: it is neither a command nor a declared name", arg)
+	}
+	switch v := value.(type) {
+	case runtime.NativeFnVal:
+		fmt.Fprintf(r.out, "%s\n\n%s\n", declaration(v.Name, v.Params, v.ReturnType, v.Optional, v.Variadic), v.Doc)
+	case runtime.FunctionVal:
+		fmt.Fprintln(r.out, declaration(v.Name, v.Params, v.ReturnType, 0, false))
+	case runtime.CompiledFnVal:
+		fmt.Fprintln(r.out, declaration(v.Name, v.Params, v.ReturnType, 0, false))
+	case runtime.TypeAliasVal:
+		fmt.Fprintln(r.out, typeOf(v))
+	default:
+		fmt.Fprintf(r.out, "%s: %s\n", arg, typeOf(value))
+	}
+	return nil, nil
+}
+
+func (r *REPL) quitCommand(arg string) (*runtime.ExitSignal, error) {
+	return &runtime.ExitSignal{Code: 0}, nil
+}
+
+/*
+Тип значения в записи типов языка. У функций - их сигнатура.
+*/
+func typeOf(value runtime.RuntimeVal) string {
+	switch v := value.(type) {
+	case runtime.IntVal:
+		return "int"
+	case runtime.FloatVal:
+		return "float"
+	case runtime.StringVal:
+		return "string"
+	case runtime.BoolVal:
+		return "bool"
+	case runtime.NullVal:
+		return "null"
+	case runtime.UndefinedVal:
+		return "undefined"
+	case runtime.FunctionVal:
+		return signature(v.Params, v.ReturnType)
+	case runtime.CompiledFnVal:
+		return signature(v.Params, v.ReturnType)
+	case runtime.NativeFnVal:
+		return signature(v.Params, v.ReturnType)
+	case runtime.EnvironmentVal:
+		return "environment"
+	case runtime.TypeAliasVal:
+		return "type " + v.Name + " = " + formatter.Type(v.Type)
+	}
+	return "unknown"
+}
+
+func signature(params []ast.Param, returnType ast.Type) string {
+	if returnType == nil {
+		returnType = ast.VoidKeyword{}
+	}
+	return formatter.Type(ast.FunType{
+		Params:     params,
+		ReturnType: returnType,
+	})
+}
+
+/*
+Функция так, как её объявляют: fun name(a: int, b?: int): int. У
+необязательных параметров ставится ?, у последнего параметра
+Variadic-функции - многоточие.
+*/
+func declaration(name string, params []ast.Param, returnType ast.Type, optional int, variadic bool) string {
+	printed := make([]string, len(params))
+	for i, param := range params {
+		paramName := param.Name
+		if variadic && i == len(params)-1 {
+			paramName = "..." + paramName
+		}
+		if i >= len(params)-optional {
+			paramName += "?"
+		}
+		printed[i] = paramName + ": " + formatter.Type(param.Type)
+	}
+	if returnType == nil {
+		returnType = ast.VoidKeyword{}
+	}
+	return "fun " + name + "(" + strings.Join(printed, ", ") + "): " + formatter.Type(returnType)
+}
@@ -0,0 +1,139 @@
+package repl
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/runtime"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+Идентификатор в тех же классах символов, что и у лексера, и его
+возможно пустое начало.
+*/
+const (
+	identifier        = `[\p{L}\p{Nl}_][\p{L}\p{Nl}\p{Mn}\p{Mc}\p{Nd}\p{Pc}]*`
+	partialIdentifier = `(?:` + identifier + `)?`
+)
+
+var (
+	commandWord = regexp.MustCompile(`^\s*:` + partialIdentifier + `$`)
+	helpWord    = regexp.MustCompile(`^\s*:help\s+(` + partialIdentifier + `)$`)
+	memberWord  = regexp.MustCompile(`(` + identifier + `)\.(` + partialIdentifier + `)$`)
+	lastWord    = regexp.MustCompile(partialIdentifier + `$`)
+)
+
+/*
+Дополнение слова, которое заканчивается на курсоре. before - текст
+строки до курсора. Возвращает дополняемое слово и отсортированные
+варианты, которые с него начинаются:
+
+- в начале строки после двоеточия - команды сессии;
+
+- после :help - имена окружения и команды;
+
+- после "Псевдоним." - поля структуры, на которую ссылается псевдоним;
+
+- в остальных случаях - ключевые слова и имена, видимые в окружении.
+*/
+func (r *REPL) complete(before string) (word string, candidates []string) {
+	if commandWord.MatchString(before) {
+		word = strings.TrimSpace(before)
+		names := make([]string, len(commands))
+		for i, c := range commands {
+			names[i] = ":" + c.name
+		}
+		return word, withPrefix(names, word)
+	}
+	if match := helpWord.FindStringSubmatch(before); match != nil {
+		names := r.names()
+		for _, c := range commands {
+			names = append(names, c.name)
+		}
+		return match[1], withPrefix(names, match[1])
+	}
+	if match := memberWord.FindStringSubmatch(before); match != nil {
+		return match[2], withPrefix(r.fields(match[1]), match[2])
+	}
+
+	word = lastWord.FindString(before)
+	return word, withPrefix(append(lexer.Keywords(), r.names()...), word)
+}
+
+/*
+Имена из цепочки окружений сессии, включая встроенные функции.
+*/
+func (r *REPL) names() []string {
+	names := make([]string, 0)
+	for env := &r.env; env != nil; env = env.Parent() {
+		names = append(names, env.Names()...)
+	}
+	return names
+}
+
+/*
+Поля и методы структуры, которую обозначает псевдоним типа name.
+*/
+func (r *REPL) fields(name string) []string {
+	value, ok := r.env.Lookup(name)
+	if !ok {
+		return nil
+	}
+	alias, ok := value.(runtime.TypeAliasVal)
+	if !ok {
+		return nil
+	}
+	structType, ok := alias.Type.(ast.Struct)
+	if !ok {
+		return nil
+	}
+
+	fields := make([]string, 0, len(structType.Members))
+	for _, member := range structType.Members {
+		switch m := member.(type) {
+		case ast.PropertySignature:
+			fields = append(fields, m.Name)
+		case ast.MethodSignature:
+			fields = append(fields, m.Name)
+		}
+	}
+	return fields
+}
+
+/*
+Варианты с префиксом prefix без повторов.
+*/
+func withPrefix(words []string, prefix string) []string {
+	seen := make(map[string]bool)
+	matched := make([]string, 0)
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) && !seen[word] {
+			seen[word] = true
+			matched = append(matched, word)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+/*
+Общее начало всех вариантов. Сравнивается по символам, чтобы не разрезать
+многобайтовый символ UTF-8.
+*/
+func commonPrefix(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	prefix := []rune(words[0])
+	for _, word := range words[1:] {
+		runes := []rune(word)
+		n := 0
+		for n < len(prefix) && n < len(runes) && prefix[n] == runes[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
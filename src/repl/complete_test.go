@@ -0,0 +1,75 @@
+package repl
+
+import (
+	"finescript/src/runtime"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"print", "println"}, "print"},
+		{[]string{"привет", "прием"}, "при"},
+		{[]string{"ёж", "ель"}, ""},
+		{[]string{"日本語", "日本人"}, "日本"},
+	}
+	for _, tt := range tests {
+		got := commonPrefix(tt.words)
+		if got != tt.want {
+			t.Errorf("commonPrefix(%q) = %q, want %q", tt.words, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("commonPrefix(%q) = %q is not valid UTF-8", tt.words, got)
+		}
+	}
+}
+
+func TestCompleteUnicode(t *testing.T) {
+	r := New(strings.NewReader(""), io.Discard, runtime.CapAll, false, "")
+	if _, _, err := r.execute("var переменная = 1\nvar перевод = 2\ntype Точка = struct { икс: int, игрек: int }"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		before     string
+		word       string
+		candidates []string
+	}{
+		{"println(пере", "пере", []string{"перевод", "переменная"}},
+		{"1 + перем", "перем", []string{"переменная"}},
+		{"Точка.и", "и", []string{"игрек", "икс"}},
+		{":help пер", "пер", []string{"перевод", "переменная"}},
+		{":he", ":he", []string{":help"}},
+	}
+	for _, tt := range tests {
+		word, candidates := r.complete(tt.before)
+		if word != tt.word || !slices.Equal(candidates, tt.candidates) {
+			t.Errorf("complete(%q) = %q, %q, want %q, %q", tt.before, word, candidates, tt.word, tt.candidates)
+		}
+	}
+}
+
+/*
+Tab дописывает общее начало вариантов целыми символами.
+*/
+func TestCompleteWordInsertsRunes(t *testing.T) {
+	e := &editor{
+		out: io.Discard,
+		complete: func(before string) (string, []string) {
+			return "п", []string{"привет", "прием"}
+		},
+	}
+	s := &lineState{buf: []rune("п")}
+	s.pos = len(s.buf)
+	e.completeWord(s)
+	if got := string(s.buf); got != "при" {
+		t.Errorf("line = %q, want %q", got, "при")
+	}
+}
@@ -0,0 +1,262 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Ввод строки прерван через Ctrl+C.
+*/
+var errInterrupted = errors.New("interrupted")
+
+/*
+Построчный ввод. Если вход - терминал, строку можно редактировать:
+стрелки, Home/End, Backspace/Delete, перемещение по истории и
+сочетания клавиш Emacs (Ctrl+A, E, B, F, K, U, W, L, P, N), дополнение по
+Tab. Иначе строки читаются как есть, что удобно для передачи программы
+через pipe.
+*/
+type editor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	fd      int // -1, если вход - не файл
+	history *history
+	// Дополняемое слово перед курсором и варианты его дополнения
+	complete func(before string) (word string, candidates []string)
+}
+
+/*
+Читает строку без завершающего перевода строки. В конце ввода
+возвращается io.EOF.
+*/
+func (e *editor) readLine(prompt string) (string, error) {
+	if e.fd >= 0 {
+		if restore, err := makeRaw(e.fd); err == nil {
+			defer restore()
+			return e.edit(prompt)
+		}
+	}
+
+	fmt.Fprint(e.out, prompt)
+	line, err := e.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+/*
+Состояние редактируемой строки.
+*/
+type lineState struct {
+	prompt string
+	buf    []rune
+	pos    int
+	// Позиция в истории, len(entries) - новая строка
+	entry int
+	// Новая строка, которую вводили до перехода по истории
+	draft []rune
+}
+
+func (e *editor) edit(prompt string) (string, error) {
+	s := &lineState{
+		prompt: prompt,
+		entry:  len(e.history.entries),
+	}
+	e.refresh(s)
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(s.buf), nil
+		case ctrl('C'):
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case ctrl('D'):
+			if len(s.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			s.delete()
+		case ctrl('A'):
+			s.pos = 0
+		case ctrl('E'):
+			s.pos = len(s.buf)
+		case ctrl('B'):
+			s.move(-1)
+		case ctrl('F'):
+			s.move(1)
+		case ctrl('K'):
+			s.buf = s.buf[:s.pos]
+		case ctrl('U'):
+			s.buf = s.buf[s.pos:]
+			s.pos = 0
+		case ctrl('W'):
+			s.deleteWord()
+		case ctrl('L'):
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case ctrl('P'):
+			e.recall(s, -1)
+		case ctrl('N'):
+			e.recall(s, 1)
+		case ctrl('H'), 127:
+			if s.pos > 0 {
+				s.pos--
+				s.delete()
+			}
+		case '\t':
+			e.completeWord(s)
+		case 27:
+			e.escape(s)
+		default:
+			if unicode.IsPrint(r) {
+				s.insert(r)
+			}
+		}
+		e.refresh(s)
+	}
+}
+
+func ctrl(key rune) rune {
+	return key & 0x1f
+}
+
+/*
+Управляющие последовательности стрелок и клавиш Home, End, Delete в
+вариантах ESC [ и ESC O.
+*/
+func (e *editor) escape(s *lineState) {
+	kind, _, err := e.in.ReadRune()
+	if err != nil || kind != '[' && kind != 'O' {
+		return
+	}
+	sequence := ""
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return
+		}
+		sequence += string(r)
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+	}
+
+	switch sequence {
+	case "A":
+		e.recall(s, -1)
+	case "B":
+		e.recall(s, 1)
+	case "C":
+		s.move(1)
+	case "D":
+		s.move(-1)
+	case "H", "1~", "7~":
+		s.pos = 0
+	case "F", "4~", "8~":
+		s.pos = len(s.buf)
+	case "3~":
+		s.delete()
+	}
+}
+
+/*
+Заменяет строку соседней записью истории. Введённое до перехода
+запоминается и возвращается после последней записи.
+*/
+func (e *editor) recall(s *lineState, step int) {
+	entries := e.history.entries
+	entry := s.entry + step
+	if entry < 0 || entry > len(entries) {
+		return
+	}
+	if s.entry == len(entries) {
+		s.draft = s.buf
+	}
+	s.entry = entry
+
+	if entry == len(entries) {
+		s.buf = s.draft
+	} else {
+		s.buf = []rune(entries[entry])
+	}
+	s.pos = len(s.buf)
+}
+
+/*
+Дописывает общее начало вариантов дополнения, а если дописывать
+нечего - печатает варианты под строкой. В начале строки Tab делает
+отступ.
+*/
+func (e *editor) completeWord(s *lineState) {
+	before := string(s.buf[:s.pos])
+	if strings.TrimSpace(before) == "" {
+		s.insert(' ', ' ')
+		return
+	}
+
+	word, candidates := e.complete(before)
+	if prefix := commonPrefix(candidates); len(prefix) > len(word) {
+		s.insert([]rune(prefix[len(word):])...)
+		return
+	}
+	if len(candidates) > 1 {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+}
+
+/*
+Перерисовывает строку целиком и ставит курсор на место.
+*/
+func (e *editor) refresh(s *lineState) {
+	column := utf8.RuneCountInString(s.prompt) + s.pos
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K\r", s.prompt, string(s.buf))
+	if column > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dC", column)
+	}
+}
+
+func (s *lineState) insert(runes ...rune) {
+	buf := make([]rune, 0, len(s.buf)+len(runes))
+	buf = append(buf, s.buf[:s.pos]...)
+	buf = append(buf, runes...)
+	s.buf = append(buf, s.buf[s.pos:]...)
+	s.pos += len(runes)
+}
+
+func (s *lineState) delete() {
+	if s.pos < len(s.buf) {
+		s.buf = append(s.buf[:s.pos:s.pos], s.buf[s.pos+1:]...)
+	}
+}
+
+func (s *lineState) move(step int) {
+	s.pos = max(0, min(len(s.buf), s.pos+step))
+}
+
+/*
+Удаляет слово перед курсором вместе с пробелами после него.
+*/
+func (s *lineState) deleteWord() {
+	start := s.pos
+	for start > 0 && unicode.IsSpace(s.buf[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(s.buf[start-1]) {
+		start--
+	}
+	s.buf = append(s.buf[:start:start], s.buf[s.pos:]...)
+	s.pos = start
+}
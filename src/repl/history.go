@@ -0,0 +1,71 @@
+package repl
+
+import (
+	"os"
+	"strings"
+)
+
+// Сколько последних строк хранится в файле истории
+const historySize = 1000
+
+/*
+История введённых строк. Каждая строка сразу дописывается в файл, чтобы
+она сохранилась, даже если сессия завершится аварийно. Ошибки чтения и
+записи файла не мешают работе: история остаётся в памяти.
+*/
+type history struct {
+	path    string
+	entries []string
+}
+
+/*
+Загружает историю из path. Пустой path отключает файл истории.
+*/
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	if path == "" {
+		return h
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	// Файл, выросший сверх лимита, переписывается последними строками
+	if len(h.entries) > historySize {
+		h.entries = h.entries[len(h.entries)-historySize:]
+		os.WriteFile(path, []byte(strings.Join(h.entries, "\n")+"\n"), 0600)
+	}
+	return h
+}
+
+/*
+Пустые строки и повтор предыдущей строки не сохраняются.
+*/
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if len(h.entries) > 0 && h.entries[len(h.entries)-1] == line {
+		return
+	}
+	h.entries = append(h.entries, line)
+	if len(h.entries) > historySize {
+		h.entries = h.entries[1:]
+	}
+
+	if h.path == "" {
+		return
+	}
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	file.WriteString(line + "\n")
+}
@@ -0,0 +1,37 @@
+package repl
+
+import (
+	"finescript/src/lexer"
+	"regexp"
+	"strings"
+)
+
+var multilineString = regexp.MustCompile(`^unterminated string literal near "r?("""|''')`)
+
+/*
+Нужно ли продолжить ввод на следующей строке: остались незакрытые
+скобки, шаблонная или многострочная строка. Лишняя закрывающая скобка
+не ждёт продолжения - такую ошибку покажет парсер.
+*/
+func incomplete(source string) bool {
+	tokens, errs := lexer.Tokenize(source)
+	for _, err := range errs {
+		if strings.HasPrefix(err, "unterminated template string") || multilineString.MatchString(err) {
+			return true
+		}
+	}
+
+	depth := 0
+	for _, token := range tokens {
+		switch token.Kind {
+		case lexer.OPEN_PAREN, lexer.OPEN_BRACKET, lexer.OPEN_CURLY:
+			depth++
+		case lexer.CLOSE_PAREN, lexer.CLOSE_BRACKET, lexer.CLOSE_CURLY:
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth > 0
+}
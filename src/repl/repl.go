@@ -0,0 +1,187 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"finescript/src/ast"
+	"finescript/src/helpers"
+	"finescript/src/lexer"
+	"finescript/src/optimizer"
+	"finescript/src/parser"
+	"finescript/src/resolver"
+	"finescript/src/runtime"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	prompt             = "> "
+	continuationPrompt = "... "
+)
+
+/*
+Интерактивная сессия. Ввод продолжается на следующих строках, пока не
+закрыты скобки. Ошибки разбора и выполнения печатаются, а окружение с
+объявленными переменными сохраняется. Строки, начинающиеся с двоеточия,
+- команды самой сессии.
+*/
+type REPL struct {
+	editor   *editor
+	out      io.Writer
+	caps     runtime.Capability
+	optimize bool
+	env      runtime.Environment
+}
+
+/*
+historyFile - файл истории ввода, пустая строка отключает его.
+*/
+func New(in io.Reader, out io.Writer, caps runtime.Capability, optimize bool, historyFile string) *REPL {
+	fd := -1
+	if file, ok := in.(*os.File); ok {
+		fd = int(file.Fd())
+	}
+	r := &REPL{
+		editor: &editor{
+			in:      bufio.NewReader(in),
+			out:     out,
+			fd:      fd,
+			history: loadHistory(historyFile),
+		},
+		out:      out,
+		caps:     caps,
+		optimize: optimize,
+		env:      runtime.NewGlobalEnv(caps),
+	}
+	r.editor.complete = r.complete
+	return r
+}
+
+/*
+Работает до конца ввода, :quit или вызова exit() и возвращает код
+завершения.
+*/
+func (r *REPL) Run() int {
+	for {
+		source, err := r.read()
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+			return 1
+		}
+
+		var exit *runtime.ExitSignal
+		if trimmed := strings.TrimSpace(source); strings.HasPrefix(trimmed, ":") {
+			exit, err = r.command(trimmed[1:])
+		} else if trimmed != "" {
+			var result runtime.RuntimeVal
+			result, exit, err = r.execute(source)
+			if err == nil && exit == nil && result != nil {
+				fmt.Fprintln(r.out, runtime.Format(result))
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+		}
+		if exit != nil {
+			return exit.Code
+		}
+	}
+}
+
+/*
+Читает ввод целиком: строки с продолжением склеиваются. Команды сессии
+всегда занимают одну строку.
+*/
+func (r *REPL) read() (string, error) {
+	lines := make([]string, 0, 1)
+	for {
+		current := prompt
+		if len(lines) > 0 {
+			current = continuationPrompt
+		}
+		line, err := r.editor.readLine(current)
+		if err != nil {
+			return "", err
+		}
+		r.editor.history.add(line)
+		lines = append(lines, line)
+
+		source := strings.Join(lines, "\n")
+		if strings.HasPrefix(strings.TrimSpace(source), ":") || !incomplete(source) {
+			return source, nil
+		}
+	}
+}
+
+/*
+Разбирает и выполняет source в окружении сессии. Инструкции выполняются
+по одной, так что объявленное до ошибки остаётся в окружении. Результат
+- значение последней инструкции или nil, если это объявление функции
+или типа.
+*/
+func (r *REPL) execute(source string) (runtime.RuntimeVal, *runtime.ExitSignal, error) {
+	program, err := r.parse(source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result runtime.RuntimeVal = runtime.NullVal{}
+	for _, stmt := range program.Body {
+		value, exit, err := r.run(source, stmt)
+		if err != nil || exit != nil {
+			return nil, exit, err
+		}
+		result = value
+		switch stmt.(type) {
+		case ast.FunDeclStmt, ast.TypeAliasDecl:
+			result = nil
+		}
+	}
+	return result, nil, nil
+}
+
+func (r *REPL) parse(source string) (ast.Program, error) {
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		return ast.Program{}, errorList(errs)
+	}
+	program, errs := parser.Parse(tokens, source)
+	if len(errs) > 0 {
+		return ast.Program{}, errorList(errs)
+	}
+	if r.optimize {
+		program = optimizer.Optimize(program)
+	}
+	program, errs = resolver.Resolve(program, source, r.env.Names())
+	if len(errs) > 0 {
+		return ast.Program{}, errorList(errs)
+	}
+	return program, nil
+}
+
+func (r *REPL) run(source string, stmt ast.Stmt) (value runtime.RuntimeVal, exit *runtime.ExitSignal, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			pos := stmt.Pos()
+			err = fmt.Errorf("Runtime Error at %s:\n%s\n%v", pos.String(), helpers.Snippet(source, pos.StartPos, pos.EndPos), rec)
+		}
+	}()
+	value, exit = runtime.Run(stmt, r.env)
+	return value, exit, nil
+}
+
+/*
+Ошибки разбора одним сообщением. Некоторые сообщения парсера
+заканчиваются переводом строки.
+*/
+func errorList(errs []string) error {
+	return errors.New(strings.TrimSpace(strings.Join(errs, "\n")))
+}
@@ -0,0 +1,124 @@
+package repl
+
+import (
+	"finescript/src/runtime"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+/*
+Сессия со вводом из строки: без терминала редактор печатает
+приглашение и читает строку целиком.
+*/
+func session(script string) (output string, code int) {
+	var out strings.Builder
+	code = New(strings.NewReader(script), &out, runtime.CapAll, false, "").Run()
+	return out.String(), code
+}
+
+func TestSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+		code   int
+	}{
+		{"values", "var a = 1\na + 1\n", "> 1\n> 2\n> ", 0},
+		{"declarations print nothing", "fun f(x: int) { x * 2 }\ntype P = struct { x: int }\nf(4)\n", "> > > 8\n> ", 0},
+
+		// Продолжение ввода
+		{"open block", "fun f(x: int) {\n  x * 2\n}\nf(4)\n", "> ... ... > 8\n> ", 0},
+		{"open parens", "var b = (1 +\n  2)\nb\n", "> ... 3\n> 3\n> ", 0},
+		{"template interpolation", "var s = `a ${\n1 + 1}`\ns\n", "> ... a 2\n> a 2\n> ", 0},
+		{"multiline string", "var t = \"\"\"x\ny\"\"\"\nt\n", "> ... x\ny\n> x\ny\n> ", 0},
+		{"operator at line end is not continued", "1 +\n2\n", "> NUD Handler expected for token eof at 1:4\n> 2\n> ", 0},
+		{"extra closing paren", "1)\n2\n", "> Parser Error at 1:2:\n)\nExpected ';' or a new line after statement but got close_paren\n> 2\n> ", 0},
+		{"input ends inside block", "(1 +\n", "> ... ", 0},
+
+		// Команды
+		{"help", ":help\n", "> :type expr       Evaluates the expression and prints the type of its value\n" +
+			":ast code        Prints the syntax tree of the code\n" +
+			":tokens code     Prints the tokens of the code\n" +
+			":env             Lists the variables declared in the session\n" +
+			":load file       Runs the file in the session\n" +
+			":reset           Clears all declarations of the session\n" +
+			":help [name]     Lists the commands or shows the signature and documentation of a command, function or type\n" +
+			":quit            Ends the session\n> ", 0},
+		{"help command", ":help quit\n", "> :quit\n\nEnds the session\n> ", 0},
+		{"help builtin", ":help sprintf\n", "> fun sprintf(...values: any): string\n\nReturns the values converted to strings and joined without separators.\n> ", 0},
+		{"help session function", "fun f(x: int): int { x }\n:help f\n", "> > fun f(x: int): int\n> ", 0},
+		{"help unknown", ":help nope\n", "> No help for \"nope\": it is neither a command nor a declared name\n> ", 0},
+		{"type", ":type 1.5\n:type \"s\"\nfun f(x: int) { x }\n:type f\n", "> float\n> string\n> > fun (x: int) => void\n> ", 0},
+		{"env", "var a = 1\nvar s = \"x\"\nfun f() { 1 }\n:env\n", "> 1\n> x\n> > a: int = 1\nf: fun () => void\ns: string = \"x\"\n> ", 0},
+		{"reset", "var a = 1\n:reset\n:env\na\n", "> 1\n> > > Resolver Error at 1:1:\na\nCannot resolve \"a\" as it does not exist.\n> ", 0},
+		{"unknown command", ":bogus\n", "> Unknown command :bogus, type :help to list the commands\n> ", 0},
+		{"missing argument", ":load\n", "> Usage: :load file\n> ", 0},
+		{"quit", ":quit\n1\n", "> ", 0},
+		{"exit", "exit(3)\n1\n", "> ", 3},
+
+		// Ошибки не завершают сессию
+		{"resolver error", "missing + 1\nvar b = 2\nb\n", "> Resolver Error at 1:1:\nmissing\nCannot resolve \"missing\" as it does not exist.\n> 2\n> 2\n> ", 0},
+		{"parser error", "1 2\n3\n", "> Parser Error at 1:3:\n2\nExpected ';' or a new line after statement but got int\n> 3\n> ", 0},
+		{"lexer error", "1 @ 2\n3\n", "> unrecognized token near \"@ 2\" at 1:3\n> 3\n> ", 0},
+		{"runtime error keeps variable", "var c = 1\nc = \"x\"\nc\n", "> 1\n> Runtime Error at 1:1:\nc = \"x\"\nTypes of assigne and expr not equals\n> 1\n> ", 0},
+		{"statements before error are kept", "var d = 1; d = 2; 1 / 0; d = 5\nd\n", "> Runtime Error at 1:19:\n1 / 0\nruntime error: integer divide by zero\n> 2\n> ", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, code := session(tt.script)
+			if got != tt.want || code != tt.code {
+				t.Errorf("got %q with code %d, want %q with code %d", got, code, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	r := New(strings.NewReader(""), io.Discard, runtime.CapAll, false, "")
+	if _, _, err := r.execute("var counter = 1\nfun count() { 1 }\ntype Point = struct { x: int, y: int, move(dx: int): int }"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		before     string
+		word       string
+		candidates []string
+	}{
+		{":", ":", []string{":ast", ":env", ":help", ":load", ":quit", ":reset", ":tokens", ":type"}},
+		{":t", ":t", []string{":tokens", ":type"}},
+		{"  :re", ":re", []string{":reset"}},
+		{":help ty", "ty", []string{"type"}},
+		{":help cou", "cou", []string{"count", "counter"}},
+		{"pri", "pri", []string{"print", "println"}},
+		{"va", "va", []string{"var"}},
+		{"1 + cou", "cou", []string{"count", "counter"}},
+		{"Point.", "", []string{"move", "x", "y"}},
+		{"Point.m", "m", []string{"move"}},
+		{"counter.", "", []string{}},
+		{"zzz", "zzz", []string{}},
+		{"1 + ", "", nil},
+	}
+	for _, tt := range tests {
+		word, candidates := r.complete(tt.before)
+		if tt.candidates == nil {
+			if word != tt.word || len(candidates) == 0 {
+				t.Errorf("complete(%q) = %q, %q, want every name", tt.before, word, candidates)
+			}
+			continue
+		}
+		if word != tt.word || !slices.Equal(candidates, tt.candidates) {
+			t.Errorf("complete(%q) = %q, %q, want %q, %q", tt.before, word, candidates, tt.word, tt.candidates)
+		}
+		if prefix := commonPrefix(candidates); len(candidates) > 0 && !strings.HasPrefix(prefix, word) {
+			t.Errorf("complete(%q): common prefix %q does not extend %q", tt.before, prefix, word)
+		}
+	}
+
+	// После :reset имена сессии больше не предлагаются
+	r.command("reset")
+	if _, candidates := r.complete("cou"); len(candidates) != 0 {
+		t.Errorf("after :reset complete(\"cou\") = %q", candidates)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package repl
+
+import "errors"
+
+/*
+Редактирование строки поддерживается только в Linux, в остальных
+системах строки читаются как есть.
+*/
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.New("line editing is not supported on this platform")
+}
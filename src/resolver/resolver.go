@@ -0,0 +1,292 @@
+package resolver
+
+import (
+	"finescript/src/ast"
+	"finescript/src/helpers"
+	"finescript/src/lexer"
+	"fmt"
+)
+
+type declaration struct {
+	slot     int
+	declared bool
+}
+
+/*
+Область видимости, соответствующая одному окружению интерпретатора:
+программе, блоку, ветке if или вызову функции.
+*/
+type scope struct {
+	names   map[string]*declaration
+	parent  *scope
+	slots   int
+	global  bool
+	fnLevel int // Глубина вложенности функций, в которой открыта область
+	// Область вызывает eval, evalIn или currentEnv: eval может объявить
+	// в ней имя, которого нет в исходном тексте
+	dynamic bool
+}
+
+type resolver struct {
+	scope   *scope
+	fnLevel int
+	text    func(pos lexer.Position) string
+	errors  []lexer.Error
+}
+
+/*
+Привязывает идентификаторы программы к слотам окружений.
+
+Локальные переменные получают пару (глубина, слот), глобальные остаются
+доступны по имени, чтобы их видел eval. globals - имена, уже объявленные
+в глобальном окружении, например встроенные функции. В областях, которые
+вызывают eval, имена из внешних областей ищутся по имени во время
+выполнения: eval может их перекрыть или объявить. Ошибки - обращения
+к несуществующим именам вне таких областей, повторные объявления
+и использование переменной до её объявления.
+*/
+func Resolve(program ast.Program, initialSource string, globals []string) (ast.Program, []string) {
+	program, errs := ResolveErrors(program, initialSource, globals)
+	return program, lexer.Messages(errs)
+}
+
+/*
+Как Resolve, но ошибки с позициями, например для подсветки в редакторе.
+*/
+func ResolveErrors(program ast.Program, initialSource string, globals []string) (ast.Program, []lexer.Error) {
+	return resolve(program, func(pos lexer.Position) string {
+		return helpers.Snippet(initialSource, pos.StartPos, pos.EndPos)
+	}, globals)
+}
+
+/*
+Как Resolve, но фрагменты кода в ошибках берутся из потока, из которого
+программа была разобрана, как у парсера.
+*/
+func ResolveStream(program ast.Program, stream *lexer.Stream, globals []string) (ast.Program, []string) {
+	program, errs := resolve(program, stream.Text, globals)
+	return program, lexer.Messages(errs)
+}
+
+func resolve(program ast.Program, text func(pos lexer.Position) string, globals []string) (ast.Program, []lexer.Error) {
+	r := &resolver{
+		scope: &scope{
+			names:  make(map[string]*declaration),
+			global: true,
+		},
+		text:   text,
+		errors: make([]lexer.Error, 0),
+	}
+
+	for _, name := range globals {
+		r.scope.names[name] = &declaration{
+			slot:     -1,
+			declared: true,
+		}
+	}
+
+	r.scope.dynamic = ast.ScopeCallsEval(program.Body)
+	r.hoist(program.Body)
+	program.Body = r.resolveStmts(program.Body)
+
+	return program, r.errors
+}
+
+func (r *resolver) error(err any, pos lexer.Position) {
+	r.errors = append(r.errors, lexer.Error{
+		Message:  fmt.Sprint(err),
+		Position: pos,
+		Text:     fmt.Sprintf("Resolver Error at %s:\n%s\n%s", pos.String(), r.text(pos), err),
+	})
+}
+
+func (r *resolver) enterScope(body []ast.Stmt) {
+	r.scope = &scope{
+		names:   make(map[string]*declaration),
+		parent:  r.scope,
+		fnLevel: r.fnLevel,
+		dynamic: ast.ScopeCallsEval(body),
+	}
+}
+
+func (r *resolver) leaveScope() {
+	r.scope = r.scope.parent
+}
+
+/*
+Заранее регистрирует все объявления тела, чтобы слоты совпадали
+с порядком объявления во время выполнения, а функции могли ссылаться
+на переменные, объявленные после них.
+*/
+func (r *resolver) hoist(body []ast.Stmt) {
+	for _, node := range body {
+		switch stmt := node.(type) {
+		case ast.VarDeclStmt:
+			r.register(stmt.Name, stmt.Position)
+		case ast.FunDeclStmt:
+			r.register(stmt.Name, stmt.Position)
+		case ast.TypeAliasDecl:
+			r.register(stmt.Name, stmt.Position)
+		}
+	}
+}
+
+func (r *resolver) register(name string, pos lexer.Position) *declaration {
+	if _, exists := r.scope.names[name]; exists {
+		r.error(fmt.Sprintf("Variable \"%s\" is already declared in this scope.", name), pos)
+	}
+
+	decl := &declaration{
+		slot: -1,
+	}
+	if !r.scope.global {
+		decl.slot = r.scope.slots
+		r.scope.slots++
+	}
+	r.scope.names[name] = decl
+	return decl
+}
+
+func (r *resolver) declare(name string) *ast.Binding {
+	decl := r.scope.names[name]
+	decl.declared = true
+	if decl.slot < 0 {
+		return nil
+	}
+	return &ast.Binding{
+		Slot: decl.slot,
+	}
+}
+
+func (r *resolver) lookup(ident ast.Identifier) ast.Identifier {
+	depth := 0
+	for s := r.scope; s != nil; s = s.parent {
+		decl, exists := s.names[ident.Name]
+		if !exists {
+			// Имя могло появиться в окружении этой области
+			// во время выполнения, поэтому его ищут по имени
+			if s.dynamic {
+				return ident
+			}
+			depth++
+			continue
+		}
+
+		// Тела функций выполняются позже, поэтому им доступны
+		// переменные, объявленные после самой функции
+		if !decl.declared && s.fnLevel == r.fnLevel {
+			r.error(fmt.Sprintf("Cannot use \"%s\" before its declaration.", ident.Name), ident.Position)
+		}
+		if decl.slot >= 0 {
+			ident.Binding = &ast.Binding{
+				Depth: depth,
+				Slot:  decl.slot,
+			}
+		}
+		return ident
+	}
+
+	r.error(fmt.Sprintf("Cannot resolve \"%s\" as it does not exist.", ident.Name), ident.Position)
+	return ident
+}
+
+func (r *resolver) resolveStmts(body []ast.Stmt) []ast.Stmt {
+	resolved := make([]ast.Stmt, 0, len(body))
+	for _, stmt := range body {
+		resolved = append(resolved, r.resolveStmt(stmt))
+	}
+	return resolved
+}
+
+func (r *resolver) resolveScope(body []ast.Stmt) []ast.Stmt {
+	r.enterScope(body)
+	defer r.leaveScope()
+
+	r.hoist(body)
+	return r.resolveStmts(body)
+}
+
+func (r *resolver) resolveStmt(node ast.Stmt) ast.Stmt {
+	switch stmt := node.(type) {
+	case ast.BlockStmt:
+		stmt.Body = r.resolveScope(stmt.Body)
+		return stmt
+	case ast.VarDeclStmt:
+		stmt.Value = r.resolveExpr(stmt.Value)
+		stmt.Binding = r.declare(stmt.Name)
+		return stmt
+	case ast.FunDeclStmt:
+		stmt.Binding = r.declare(stmt.Name)
+		return r.resolveFunction(stmt)
+	case ast.TypeAliasDecl:
+		stmt.Binding = r.declare(stmt.Name)
+		return stmt
+	case ast.IfStmt:
+		stmt.Condition = r.resolveExpr(stmt.Condition)
+		stmt.Consequent = r.resolveScope(stmt.Consequent)
+		stmt.Alternate = r.resolveScope(stmt.Alternate)
+		return stmt
+	case ast.ExprStmt:
+		stmt.Expr = r.resolveExpr(stmt.Expr)
+		return stmt
+	default:
+		return node
+	}
+}
+
+func (r *resolver) resolveFunction(stmt ast.FunDeclStmt) ast.FunDeclStmt {
+	r.fnLevel++
+	r.enterScope(stmt.Body)
+	defer func() {
+		r.leaveScope()
+		r.fnLevel--
+	}()
+
+	for _, param := range stmt.Params {
+		r.register(param.Name, stmt.Position).declared = true
+	}
+	r.hoist(stmt.Body)
+	stmt.Body = r.resolveStmts(stmt.Body)
+
+	return stmt
+}
+
+func (r *resolver) resolveExpr(node ast.Expr) ast.Expr {
+	switch expr := node.(type) {
+	case ast.Identifier:
+		return r.lookup(expr)
+	case ast.UnaryExpr:
+		expr.Expr = r.resolveExpr(expr.Expr)
+		return expr
+	case ast.BinaryExpr:
+		expr.Left = r.resolveExpr(expr.Left)
+		expr.Right = r.resolveExpr(expr.Right)
+		return expr
+	case ast.AssignExpr:
+		expr.Assigne = r.resolveExpr(expr.Assigne)
+		expr.Expr = r.resolveExpr(expr.Expr)
+		return expr
+	case ast.CallExpr:
+		args := make([]ast.Expr, 0, len(expr.Args))
+		for _, arg := range expr.Args {
+			args = append(args, r.resolveExpr(arg))
+		}
+		expr.Args = args
+		expr.Caller = r.resolveExpr(expr.Caller)
+		return expr
+	case ast.ConditionalExpr:
+		expr.Condition = r.resolveExpr(expr.Condition)
+		expr.Consequent = r.resolveExpr(expr.Consequent)
+		expr.Alternate = r.resolveExpr(expr.Alternate)
+		return expr
+	case ast.TemplateExpr:
+		parts := make([]ast.Expr, 0, len(expr.Parts))
+		for _, part := range expr.Parts {
+			parts = append(parts, r.resolveExpr(part))
+		}
+		expr.Parts = parts
+		return expr
+	default:
+		return node
+	}
+}
@@ -0,0 +1,100 @@
+package resolver
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"finescript/src/runtime"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func resolveSource(t *testing.T, source string, env runtime.Environment) (ast.Program, []string) {
+	t.Helper()
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	program, errs := parser.Parse(tokens, source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	return Resolve(program, source, env.Names())
+}
+
+/*
+Имена, которые объявляет eval, resolver не знает: в областях с eval они
+остаются без привязки и находятся по имени во время выполнения.
+*/
+func TestNamesInEvalScopesResolvedAtRuntime(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"eval(\"var k = 1\")\nk", "1"},
+		{"fun f() {\n  eval(\"var w = 2\")\n  w\n}\nf()", "2"},
+		{"fun f(a: int) {\n  eval(\"var w = a * 3\")\n  {\n    w + 1\n  }\n}\nf(2)", "7"},
+		{"fun f() {\n  var k = 1\n  {\n    eval(\"var k = 2\")\n    k\n  }\n}\nf()", "2"},
+		{"var k = 1\n{\n  eval(\"var k = 2\")\n  k\n}", "2"},
+		{"fun f() {\n  var k = 1\n  {\n    evalIn(currentEnv(), \"k = 3\")\n  }\n  k\n}\nf()", "3"},
+	}
+	for _, tt := range tests {
+		env := runtime.NewGlobalEnv(runtime.CapAll)
+		program, errs := resolveSource(t, tt.source, env)
+		if len(errs) > 0 {
+			t.Errorf("%q: %s", tt.source, strings.Join(errs, "\n"))
+			continue
+		}
+		result, _ := runtime.Run(program, env)
+		if got := runtime.Format(result); got != tt.want {
+			t.Errorf("%q = %s, want %s", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"var a = 1\nvar a = 2", "Variable \"a\" is already declared in this scope."},
+		{"{\n  a\n  var a = 1\n}", "Cannot use \"a\" before its declaration."},
+		{"println(missing)", "Cannot resolve \"missing\" as it does not exist."},
+		{"fun f() {\n  eval(\"var w = 2\")\n}\nw", "Cannot resolve \"w\" as it does not exist."},
+		{"fun f() {\n  {\n    eval(\"var w = 2\")\n  }\n  w\n}", "Cannot resolve \"w\" as it does not exist."},
+	}
+	for _, tt := range tests {
+		_, errs := resolveSource(t, tt.source, runtime.NewGlobalEnv(runtime.CapAll))
+		if len(errs) != 1 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: errors %q, want %q", tt.source, errs, tt.want)
+		}
+	}
+}
+
+/*
+Программа, прочитанная потоком, показывает в ошибках тот же фрагмент
+кода, что и прочитанная целиком: текст, который ушёл из окна потока,
+читается из spool.
+*/
+func TestResolveStreamSnippet(t *testing.T) {
+	source := "var a = 1\nprintln(a)\nvar a = 2\n" + strings.Repeat("println(a)\n", 20)
+	spool, err := os.CreateTemp(t.TempDir(), "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	stream := lexer.NewStream(iotest.OneByteReader(strings.NewReader(source))).KeepSource(spool)
+	program, errs := parser.ParseStream(stream)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	env := runtime.NewGlobalEnv(runtime.CapAll)
+	_, errs = ResolveStream(program, stream, env.Names())
+	want := "Resolver Error at 3:1:\nvar a = 2\nVariable \"a\" is already declared in this scope."
+	if len(errs) != 1 || errs[0] != want {
+		t.Errorf("errors %q, want %q", errs, want)
+	}
+}
@@ -1,12 +1,10 @@
 package runtime
 
 import (
-	"bufio"
-	"finescript/src/lexer"
-	"finescript/src/parser"
-	"fmt"
+	"math/rand"
 	"os"
 	"strings"
+	"time"
 )
 
 func handleArgs(argsCount int, paramCount int) {
@@ -24,12 +22,12 @@ func Sprintf(args []RuntimeVal, env Environment) RuntimeVal {
 }
 
 func Print(args []RuntimeVal, env Environment) RuntimeVal {
-	print(sprint_format(args))
+	env.write(sprint_format(args))
 	return NullVal{}
 }
 
 func Println(args []RuntimeVal, env Environment) RuntimeVal {
-	println(sprint_format(args))
+	env.write(sprint_format(args) + "\n")
 	return NullVal{}
 }
 
@@ -62,9 +60,7 @@ func Input(args []RuntimeVal, env Environment) RuntimeVal {
 		}
 	}
 	handleArgs(len(args), 1)
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print(ToString(args[0]).Value)
-	text, err := reader.ReadString('\n')
+	text, err := env.readLine(ToString(args[0]).Value)
 	if err != nil {
 		panic(err)
 	}
@@ -73,24 +69,6 @@ func Input(args []RuntimeVal, env Environment) RuntimeVal {
 	}
 }
 
-func Eval(args []RuntimeVal, env Environment) RuntimeVal {
-	handleArgs(len(args), 1)
-	if _, ok := args[0].(StringVal); ok {
-		tokens, errs := lexer.Tokenize(args[0].(StringVal).Value)
-		if len(errs) > 0 {
-			panic(strings.Join(errs, "\n"))
-		}
-		ast, errs := parser.Parse(tokens, args[0].(StringVal).Value)
-		if len(errs) > 0 {
-			panic(strings.Join(errs, "\n"))
-		}
-		result := EvaluateStmt(ast, GlobalEnv())
-		return result
-	}
-
-	panic("String required for eval function")
-}
-
 // func nativeLen(args []RuntimeVal, env Environment) RuntimeVal {
 // 	handleArgs(len(args), 1)
 // 	return IntVal{
@@ -99,6 +77,82 @@ func Eval(args []RuntimeVal, env Environment) RuntimeVal {
 // }
 
 func Exit(args []RuntimeVal, env Environment) RuntimeVal {
-	os.Exit(0)
+	code := 0
+	if len(args) > 0 {
+		handleArgs(len(args), 1)
+		code = int(ToInt(args[0]).Value)
+	}
+	panic(ExitSignal{
+		Code: code,
+	})
+}
+
+func Getenv(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 1)
+	value, ok := os.LookupEnv(ToString(args[0]).Value)
+	if !ok {
+		return NullVal{}
+	}
+	return StringVal{
+		Value: value,
+	}
+}
+
+func ReadFile(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 1)
+	content, err := os.ReadFile(ToString(args[0]).Value)
+	if err != nil {
+		panic(err)
+	}
+	return StringVal{
+		Value: string(content),
+	}
+}
+
+func WriteFile(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 2)
+	err := os.WriteFile(ToString(args[0]).Value, []byte(ToString(args[1]).Value), 0644)
+	if err != nil {
+		panic(err)
+	}
+	return NullVal{}
+}
+
+func FileExists(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 1)
+	_, err := os.Stat(ToString(args[0]).Value)
+	return BoolVal{
+		Value: err == nil,
+	}
+}
+
+func Now(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 0)
+	return IntVal{
+		Value: time.Now().UnixMilli(),
+	}
+}
+
+func Sleep(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 1)
+	time.Sleep(time.Duration(ToInt(args[0]).Value) * time.Millisecond)
 	return NullVal{}
 }
+
+func Random(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 0)
+	return FloatVal{
+		Value: rand.Float64(),
+	}
+}
+
+func RandomInt(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 2)
+	min, max := ToInt(args[0]).Value, ToInt(args[1]).Value
+	if max < min {
+		panic("randomInt: max is less than min")
+	}
+	return IntVal{
+		Value: min + rand.Int63n(max-min+1),
+	}
+}
@@ -0,0 +1,127 @@
+package runtime
+
+import (
+	"finescript/src/ast"
+	"fmt"
+	"strings"
+)
+
+/*
+Группы встроенных функций, которые хост может выдать программе.
+
+Базовые функции (sprintf, int, float, string, bool) доступны всегда,
+остальные устанавливаются в глобальное окружение только при наличии
+соответствующей возможности.
+*/
+type Capability uint
+
+const (
+	CapIO Capability = 1 << iota
+	CapEval
+	CapProcess
+	CapFS
+	CapTime
+	CapRandom
+
+	CapNone Capability = 0
+	CapAll             = CapIO | CapEval | CapProcess | CapFS | CapTime | CapRandom
+)
+
+var capabilityNames = []struct {
+	cap  Capability
+	name string
+}{
+	{CapIO, "io"},
+	{CapEval, "eval"},
+	{CapProcess, "process"},
+	{CapFS, "fs"},
+	{CapTime, "time"},
+	{CapRandom, "random"},
+}
+
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+func (c Capability) String() string {
+	if c == CapNone {
+		return "none"
+	}
+
+	names := make([]string, 0)
+	for _, cn := range capabilityNames {
+		if c.Has(cn.cap) {
+			names = append(names, cn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+/*
+Разбирает список групп через запятую, например "io,time,random".
+
+"all" и "none" обозначают все группы и ни одной соответственно.
+*/
+func ParseCapabilities(list string) (Capability, error) {
+	caps := CapNone
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+			continue
+		case "all":
+			caps |= CapAll
+			continue
+		case "none":
+			continue
+		}
+
+		found := false
+		for _, cn := range capabilityNames {
+			if cn.name == name {
+				caps |= cn.cap
+				found = true
+				break
+			}
+		}
+		if !found {
+			return CapNone, fmt.Errorf("unknown capability %q", name)
+		}
+	}
+	return caps, nil
+}
+
+/*
+Сигнал завершения программы, который поднимает exit(code).
+
+Вместо os.Exit интерпретатор раскручивает стек паникой с этим значением,
+а хост перехватывает её через Run и сам решает, что делать с кодом.
+*/
+type ExitSignal struct {
+	Code int
+}
+
+func (e ExitSignal) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+/*
+Выполняет программу и перехватывает вызов exit().
+
+Если программа вызвала exit, возвращается сигнал с кодом завершения,
+остальные паники пробрасываются дальше.
+*/
+func Run(node ast.Stmt, env Environment) (result RuntimeVal, exit *ExitSignal) {
+	defer func() {
+		if r := recover(); r != nil {
+			signal, ok := r.(ExitSignal)
+			if !ok {
+				panic(r)
+			}
+			result = NullVal{}
+			exit = &signal
+		}
+	}()
+
+	return EvaluateStmt(node, env), nil
+}
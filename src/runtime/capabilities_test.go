@@ -0,0 +1,156 @@
+package runtime
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		list string
+		want Capability
+		err  string
+	}{
+		{"", CapNone, ""},
+		{"none", CapNone, ""},
+		{"all", CapAll, ""},
+		{"io", CapIO, ""},
+		{" io , time,random ", CapIO | CapTime | CapRandom, ""},
+		{"fs,,process", CapFS | CapProcess, ""},
+		{"none,eval", CapEval, ""},
+		{"io,all", CapAll, ""},
+		{"io,network", CapNone, `unknown capability "network"`},
+		{"IO", CapNone, `unknown capability "IO"`},
+	}
+	for _, tt := range tests {
+		got, err := ParseCapabilities(tt.list)
+		message := ""
+		if err != nil {
+			message = err.Error()
+		}
+		if got != tt.want || message != tt.err {
+			t.Errorf("%q: got %v, %q, want %v, %q", tt.list, got, message, tt.want, tt.err)
+		}
+	}
+}
+
+func TestCapabilityString(t *testing.T) {
+	tests := []struct {
+		caps Capability
+		want string
+	}{
+		{CapNone, "none"},
+		{CapAll, "io,eval,process,fs,time,random"},
+		{CapRandom | CapIO, "io,random"},
+	}
+	for _, tt := range tests {
+		if got := tt.caps.String(); got != tt.want {
+			t.Errorf("%d: got %q, want %q", tt.caps, got, tt.want)
+		}
+		if parsed, err := ParseCapabilities(tt.caps.String()); err != nil || parsed != tt.caps {
+			t.Errorf("%q does not parse back: %v, %v", tt.want, parsed, err)
+		}
+	}
+}
+
+/*
+В глобальное окружение попадают базовые функции и функции разрешённых
+групп, и только они.
+*/
+func TestNewGlobalEnvFiltersBuiltins(t *testing.T) {
+	base := []string{"bool", "float", "int", "sprintf", "string"}
+	tests := []struct {
+		caps Capability
+		want []string
+	}{
+		{CapNone, base},
+		{CapIO, append([]string{"input", "print", "println"}, base...)},
+		{CapProcess | CapTime, append([]string{"exit", "getenv", "now", "sleep"}, base...)},
+		{CapEval, append([]string{"currentEnv", "eval", "evalIn", "evalSandbox", "newEnv"}, base...)},
+		{CapFS | CapRandom, append([]string{"fileExists", "random", "randomInt", "readFile", "writeFile"}, base...)},
+	}
+	for _, tt := range tests {
+		env := NewGlobalEnv(tt.caps)
+		want := slices.Clone(tt.want)
+		slices.Sort(want)
+		if got := env.Names(); !slices.Equal(got, want) {
+			t.Errorf("%v: got %v, want %v", tt.caps, got, want)
+		}
+	}
+
+	all, global := NewGlobalEnv(CapAll), GlobalEnv()
+	if len(all.Names()) != 22 {
+		t.Errorf("all capabilities: got %v", all.Names())
+	}
+	if !slices.Equal(global.Names(), all.Names()) {
+		t.Errorf("GlobalEnv differs from NewGlobalEnv(CapAll): %v", global.Names())
+	}
+}
+
+func parse(t *testing.T, source string) ast.Program {
+	t.Helper()
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	program, errs := parser.Parse(tokens, source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	return program
+}
+
+/*
+exit() раскручивает стек до Run из любой глубины, в том числе из eval,
+и дальше программа не выполняется.
+*/
+func TestExitThroughRun(t *testing.T) {
+	tests := []struct {
+		source string
+		code   int
+	}{
+		{"exit(3)", 3},
+		{"exit()", 0},
+		{"var a = 1\nexit(a + 4)", 5},
+		{"fun f(n: int) {\n  if n > 0 {\n    f(n - 1)\n  } else {\n    exit(7)\n  }\n}\nf(3)", 7},
+		{"eval(\"exit(2)\")", 2},
+		{"evalSandbox(\"fun g() { exit(9) }\\ng()\")", 9},
+	}
+	for _, tt := range tests {
+		result, exit := Run(parse(t, tt.source), NewGlobalEnv(CapProcess|CapEval))
+		if exit == nil || exit.Code != tt.code {
+			t.Errorf("%q: exit %v, want code %d", tt.source, exit, tt.code)
+			continue
+		}
+		if _, ok := result.(NullVal); !ok {
+			t.Errorf("%q: result %v, want null", tt.source, result)
+		}
+	}
+
+	env := NewGlobalEnv(CapProcess)
+	Run(parse(t, "var a = 1\nexit()\na = 2"), env)
+	if a, _ := env.Lookup("a"); Format(a) != "1" {
+		t.Errorf("statement after exit was executed, a = %s", Format(a))
+	}
+
+	result, exit := Run(parse(t, "1 + 2"), NewGlobalEnv(CapNone))
+	if exit != nil || Format(result) != "3" {
+		t.Errorf("program without exit: result %v, exit %v", result, exit)
+	}
+}
+
+/*
+Остальные паники Run не перехватывает.
+*/
+func TestRunRethrowsErrors(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Run swallowed a runtime error")
+		}
+	}()
+	Run(parse(t, "exit(1)"), NewGlobalEnv(CapNone))
+}
@@ -0,0 +1,54 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+Ввод и вывод встроенных функций print, println и input. Без консоли
+print и println пишут в stderr процесса, а input выводит подсказку
+в stdout и читает stdin.
+*/
+type console struct {
+	output io.Writer
+	// nil, если у программы нет ввода
+	input *bufio.Reader
+}
+
+/*
+Направляет вывод print, println и подсказки input в output, а input
+читает строки из input. Если input равен nil, вызов input() - ошибка
+выполнения: так программа под отладчиком не читает поток протокола.
+
+Консоль наследуют окружения, созданные после этого внутри env, и
+окружения evalSandbox и newEnv.
+*/
+func (env *Environment) SetConsole(output io.Writer, input io.Reader) {
+	env.console = &console{output: output}
+	if input != nil {
+		env.console.input = bufio.NewReader(input)
+	}
+}
+
+func (env *Environment) write(text string) {
+	if env.console == nil {
+		print(text)
+		return
+	}
+	io.WriteString(env.console.output, text)
+}
+
+func (env *Environment) readLine(prompt string) (string, error) {
+	if env.console == nil {
+		fmt.Print(prompt)
+		return bufio.NewReader(os.Stdin).ReadString('\n')
+	}
+	if env.console.input == nil {
+		panic("input() is not available: the program has no standard input")
+	}
+	io.WriteString(env.console.output, prompt)
+	return env.console.input.ReadString('\n')
+}
@@ -0,0 +1,28 @@
+package runtime
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+)
+
+/*
+Хук отладчика. Интерпретатор вызывает Stmt и Expr перед выполнением
+каждой инструкции и вычислением каждого выражения, EnterCall и ExitCall -
+при входе в FunctionVal и выходе из неё. Хук может приостановить
+программу, просто не возвращая управление.
+*/
+type Debugger interface {
+	Stmt(stmt ast.Stmt, env Environment)
+	Expr(expr ast.Expr, env Environment)
+	// scope - окружение вызова с параметрами, site - место вызова
+	EnterCall(fn FunctionVal, scope Environment, site lexer.Position)
+	ExitCall(fn FunctionVal)
+}
+
+/*
+Подключает отладчик к окружению. Его наследуют окружения, созданные
+после этого внутри env, поэтому подключать нужно до запуска программы.
+*/
+func (env *Environment) SetDebugger(debugger Debugger) {
+	env.debugger = debugger
+}
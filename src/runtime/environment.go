@@ -1,81 +1,248 @@
 package runtime
 
 import (
+	"finescript/src/ast"
 	"fmt"
+	"sort"
 )
 
+type builtinGroup struct {
+	cap       Capability
+	functions []NativeFnVal
+}
+
+func builtinGroups() []builtinGroup {
+	var (
+		anyType    = ast.AnyKeyword{}
+		intType    = ast.IntKeyword{}
+		floatType  = ast.FloatKeyword{}
+		stringType = ast.StringKeyword{}
+		boolType   = ast.BoolKeyword{}
+		nullType   = ast.NullKeyword{}
+	)
+	param := func(name string, typ ast.Type) ast.Param {
+		return ast.Param{Name: name, Type: typ}
+	}
+
+	return []builtinGroup{
+		{CapNone, []NativeFnVal{
+			{
+				Name: "sprintf", Call: Sprintf,
+				Params: []ast.Param{param("values", anyType)}, ReturnType: stringType, Variadic: true,
+				Doc: "Returns the values converted to strings and joined without separators.",
+			},
+			{
+				Name: "int", Call: Int,
+				Params: []ast.Param{param("value", anyType)}, ReturnType: intType,
+				Doc: "Converts the value to an integer. Floats are truncated, strings are parsed.",
+			},
+			{
+				Name: "float", Call: Float,
+				Params: []ast.Param{param("value", anyType)}, ReturnType: floatType,
+				Doc: "Converts the value to a float. Strings are parsed, true is 1.0 and false is 0.0.",
+			},
+			{
+				Name: "string", Call: String,
+				Params: []ast.Param{param("value", anyType)}, ReturnType: stringType,
+				Doc: "Converts the value to its string representation.",
+			},
+			{
+				Name: "bool", Call: Bool,
+				Params: []ast.Param{param("value", anyType)}, ReturnType: boolType,
+				Doc: "Converts the value to a boolean. Positive numbers and non-empty strings are true.",
+			},
+		}},
+		{CapIO, []NativeFnVal{
+			{
+				Name: "print", Call: Print,
+				Params: []ast.Param{param("values", anyType)}, ReturnType: nullType, Variadic: true,
+				Doc: "Writes the values to stderr without separators.",
+			},
+			{
+				Name: "println", Call: Println,
+				Params: []ast.Param{param("values", anyType)}, ReturnType: nullType, Variadic: true,
+				Doc: "Writes the values to stderr without separators and ends the line.",
+			},
+			{
+				Name: "input", Call: Input,
+				Params: []ast.Param{param("prompt", anyType)}, ReturnType: stringType, Optional: 1,
+				Doc: "Prints the prompt and returns the next line of stdin without surrounding whitespace.",
+			},
+		}},
+		{CapEval, []NativeFnVal{
+			{
+				Name: "eval", Call: Eval,
+				Params: []ast.Param{param("code", stringType)}, ReturnType: anyType,
+				Doc: "Runs the code in the caller's environment and returns the value of its last statement.",
+			},
+			{
+				Name: "evalSandbox", Call: EvalSandbox,
+				Params: []ast.Param{param("code", stringType)}, ReturnType: anyType,
+				Doc: "Runs the code in a new global environment with the same builtins as the caller.",
+			},
+			{
+				Name: "evalIn", Call: EvalIn,
+				Params: []ast.Param{param("environment", anyType), param("code", stringType)}, ReturnType: anyType,
+				Doc: "Runs the code in the environment returned by newEnv or currentEnv.",
+			},
+			{
+				Name: "newEnv", Call: NewEnv,
+				ReturnType: anyType,
+				Doc:        "Returns a new isolated global environment.",
+			},
+			{
+				Name: "currentEnv", Call: CurrentEnv,
+				ReturnType: anyType,
+				Doc:        "Returns the caller's environment.",
+			},
+		}},
+		{CapProcess, []NativeFnVal{
+			{
+				Name: "exit", Call: Exit,
+				Params: []ast.Param{param("code", intType)}, ReturnType: ast.VoidKeyword{}, Optional: 1,
+				Doc: "Ends the program with the exit code, 0 by default.",
+			},
+			{
+				Name: "getenv", Call: Getenv,
+				Params: []ast.Param{param("name", stringType)}, ReturnType: ast.UnionType{Types: []ast.Type{stringType, nullType}},
+				Doc: "Returns the environment variable or null if it is not set.",
+			},
+		}},
+		{CapFS, []NativeFnVal{
+			{
+				Name: "readFile", Call: ReadFile,
+				Params: []ast.Param{param("path", stringType)}, ReturnType: stringType,
+				Doc: "Returns the contents of the file.",
+			},
+			{
+				Name: "writeFile", Call: WriteFile,
+				Params: []ast.Param{param("path", stringType), param("content", stringType)}, ReturnType: nullType,
+				Doc: "Replaces the contents of the file, creating it if needed.",
+			},
+			{
+				Name: "fileExists", Call: FileExists,
+				Params: []ast.Param{param("path", stringType)}, ReturnType: boolType,
+				Doc: "Reports whether a file or directory exists at the path.",
+			},
+		}},
+		{CapTime, []NativeFnVal{
+			{
+				Name: "now", Call: Now,
+				ReturnType: intType,
+				Doc:        "Returns the current Unix time in milliseconds.",
+			},
+			{
+				Name: "sleep", Call: Sleep,
+				Params: []ast.Param{param("milliseconds", intType)}, ReturnType: nullType,
+				Doc: "Pauses the program for the number of milliseconds.",
+			},
+		}},
+		{CapRandom, []NativeFnVal{
+			{
+				Name: "random", Call: Random,
+				ReturnType: floatType,
+				Doc:        "Returns a random float in [0, 1).",
+			},
+			{
+				Name: "randomInt", Call: RandomInt,
+				Params: []ast.Param{param("min", intType), param("max", intType)}, ReturnType: intType,
+				Doc: "Returns a random integer between min and max inclusive.",
+			},
+		}},
+		// {CapNone, []NativeFnVal{
+		// 	{Name: "len", Call: nativeLen},
+		// }},
+	}
+}
+
+/*
+Глобальное окружение со всеми группами встроенных функций.
+*/
 func GlobalEnv() Environment {
-	env := Environment{
-		parent:    nil,
-		variables: make(map[string]variable),
-	}
-
-	env.declareVar("print", NativeFnVal{
-		Name: "print",
-		Call: Print,
-	}, true)
-
-	env.declareVar("println", NativeFnVal{
-		Name: "println",
-		Call: Println,
-	}, true)
-
-	env.declareVar("sprintf", NativeFnVal{
-		Name: "sprintf",
-		Call: Sprintf,
-	}, true)
-
-	env.declareVar("int", NativeFnVal{
-		Name: "int",
-		Call: Int,
-	}, true)
-
-	env.declareVar("float", NativeFnVal{
-		Name: "float",
-		Call: Float,
-	}, true)
-
-	env.declareVar("string", NativeFnVal{
-		Name: "string",
-		Call: String,
-	}, true)
-
-	env.declareVar("bool", NativeFnVal{
-		Name: "bool",
-		Call: Bool,
-	}, true)
-
-	env.declareVar("input", NativeFnVal{
-		Name: "input",
-		Call: Input,
-	}, true)
-
-	env.declareVar("eval", NativeFnVal{
-		Name: "eval",
-		Call: Eval,
-	}, true)
-
-	// env.declareVar("len", NativeFnVal{
-	// 	Name: "len",
-	// 	Call: nativeLen,
-	// }, true)
-
-	env.declareVar("exit", NativeFnVal{
-		Name: "exit",
-		Call: Exit,
-	}, true)
+	return NewGlobalEnv(CapAll)
+}
+
+/*
+Глобальное окружение, в которое установлены только разрешённые хостом
+группы встроенных функций.
+*/
+func NewGlobalEnv(caps Capability) Environment {
+	env := newEnvironment(nil)
+	env.caps = caps
+
+	for _, group := range builtinGroups() {
+		if !caps.Has(group.cap) {
+			continue
+		}
+		for _, fn := range group.functions {
+			env.declareVar(fn.Name, fn, true)
+		}
+	}
 
 	return env
 }
 
+/*
+Type - тип первого значения переменной, отличного от null и undefined.
+Переменную можно обнулить, но потом ей снова можно присвоить только
+значение этого типа.
+*/
 type variable struct {
 	IsConstant bool
 	Value      RuntimeVal
+	Type       ast.Type
 }
 
+/*
+Окружение хранит переменные по имени и, если их позиции вычислил
+resolver, ещё и в массиве слотов. Обе таблицы ссылаются на одни и те же
+ячейки, поэтому присваивание видно при любом способе доступа.
+*/
 type Environment struct {
 	parent    *Environment
-	variables map[string]variable
+	variables map[string]*variable
+	slots     *[]*variable
+	caps      Capability // Задаётся только у глобального окружения
+	// Копируются в дочерние окружения, чтобы не искать их по цепочке
+	// перед каждым выражением
+	debugger Debugger
+	tracer   Tracer
+	console  *console
+	// Исходный код выполняемого eval, если он есть: ошибка в нём
+	// запоминает позицию выражения
+	locate string
+}
+
+func newEnvironment(parent *Environment) Environment {
+	env := Environment{
+		parent:    parent,
+		variables: make(map[string]*variable),
+		slots:     &[]*variable{},
+	}
+	if parent != nil {
+		env.debugger = parent.debugger
+		env.tracer = parent.tracer
+		env.console = parent.console
+		env.locate = parent.locate
+	}
+	return env
+}
+
+func (env *Environment) capabilities() Capability {
+	if env.parent == nil {
+		return env.caps
+	}
+	return env.parent.capabilities()
+}
+
+/*
+Новое глобальное окружение с теми же возможностями и консолью, что
+и у env, для evalSandbox и newEnv.
+*/
+func (env *Environment) sandbox() Environment {
+	sandbox := NewGlobalEnv(env.capabilities())
+	sandbox.console = env.console
+	return sandbox
 }
 
 func (env *Environment) declareVar(varname string, value RuntimeVal, isConstant bool) RuntimeVal {
@@ -83,32 +250,112 @@ func (env *Environment) declareVar(varname string, value RuntimeVal, isConstant
 		panic("Variable exists")
 	}
 
-	env.variables[varname] = variable{
+	env.variables[varname] = &variable{
 		IsConstant: isConstant,
 		Value:      value,
+		Type:       valueType(value),
+	}
+
+	return value
+}
+
+/*
+Объявляет переменную в слоте, назначенном resolver.
+*/
+func (env *Environment) declareSlot(varname string, binding *ast.Binding, value RuntimeVal, isConstant bool) RuntimeVal {
+	if binding == nil {
+		return env.declareVar(varname, value, isConstant)
 	}
 
+	env.declareVar(varname, value, isConstant)
+	for len(*env.slots) <= binding.Slot {
+		*env.slots = append(*env.slots, nil)
+	}
+	(*env.slots)[binding.Slot] = env.variables[varname]
+
 	return value
 }
 
-func (env *Environment) assignVar(varname string, value RuntimeVal) RuntimeVal {
+func (env *Environment) lookupVar(varname string) variable {
 	newEnv := env.resolve(varname)
+	return *newEnv.variables[varname]
+}
 
-	if newEnv.variables[varname].IsConstant {
+func (v *variable) assign(varname string, value RuntimeVal) RuntimeVal {
+	if v.IsConstant {
 		panic(fmt.Sprintf("Cannot reasign to variable \"%s\" as it was declared constant.", varname))
 	}
 
-	newEnv.variables[varname] = variable{
-		IsConstant: false,
-		Value:      value,
+	if typ := valueType(value); typ != nil {
+		if v.Type == nil {
+			v.Type = typ
+		} else if typ != v.Type {
+			panic("Types of assigne and expr not equals")
+		}
 	}
+	v.Value = value
 
 	return value
 }
 
-func (env *Environment) lookupVar(varname string) variable {
-	newEnv := env.resolve(varname)
-	return newEnv.variables[varname]
+/*
+Находит ячейку переменной: по слоту, если resolver привязал
+идентификатор, иначе по имени.
+*/
+func (env *Environment) lookupIdent(ident ast.Identifier) *variable {
+	if ident.Binding == nil {
+		return env.resolve(ident.Name).variables[ident.Name]
+	}
+	return env.slot(ident.Name, ident.Binding.Depth, ident.Binding.Slot)
+}
+
+func (env *Environment) slot(varname string, depth int, slot int) *variable {
+	scope := env
+	for range depth {
+		scope = scope.parent
+	}
+	if slot >= len(*scope.slots) || (*scope.slots)[slot] == nil {
+		panic(fmt.Sprintf("Cannot resolve \"%s\" as it does not exist.", varname))
+	}
+	return (*scope.slots)[slot]
+}
+
+/*
+Имена переменных, объявленных непосредственно в этом окружении.
+*/
+func (env *Environment) Names() []string {
+	names := make([]string, 0, len(env.variables))
+	for name := range env.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+Окружение, внутри которого создано это, или nil у глобального.
+*/
+func (env *Environment) Parent() *Environment {
+	return env.parent
+}
+
+/*
+Указывают ли две копии Environment на одно и то же окружение.
+*/
+func (env *Environment) Same(other *Environment) bool {
+	return env.slots == other.slots
+}
+
+/*
+Ищет переменную по имени во всей цепочке окружений.
+*/
+func (env *Environment) Lookup(varname string) (RuntimeVal, bool) {
+	for scope := env; scope != nil; scope = scope.parent {
+		if v, exists := scope.variables[varname]; exists {
+			return v.Value, true
+		}
+	}
+	return nil, false
 }
 
 func (env *Environment) resolve(varname string) *Environment {
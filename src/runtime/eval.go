@@ -0,0 +1,173 @@
+package runtime
+
+import (
+	"finescript/src/ast"
+	"finescript/src/helpers"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"fmt"
+	"strings"
+)
+
+/*
+Ошибка внутри кода, переданного в eval.
+
+Позиции в Errors отсчитываются от начала вычисляемой строки, а CallSite
+указывает на вызов eval в исходной программе.
+*/
+type EvalError struct {
+	Source   string
+	Errors   []string
+	CallSite *lexer.Position
+}
+
+func (e *EvalError) Error() string {
+	callSite := "unknown position"
+	if e.CallSite != nil {
+		callSite = e.CallSite.String()
+	}
+	return fmt.Sprintf("Eval Error in \"%s\" called at %s:\n%s",
+		helpers.Ellipsis(e.Source, 20), callSite, strings.Join(e.Errors, "\n"))
+}
+
+/*
+Дополняет ошибку eval позицией вызова. Используется через defer
+при вызове встроенных функций.
+*/
+func annotateCallSite(pos lexer.Position) {
+	if r := recover(); r != nil {
+		if err, ok := r.(*EvalError); ok && err.CallSite == nil {
+			err.CallSite = &pos
+		}
+		panic(r)
+	}
+}
+
+/*
+Ошибка выполнения внутри eval вместе с позицией самого вложенного
+выражения, в котором она произошла.
+*/
+type locatedError struct {
+	Value    any
+	Position lexer.Position
+}
+
+func (e *locatedError) Error() string {
+	return fmt.Sprint(e.Value)
+}
+
+/*
+Запоминает позицию выражения node, если ошибку ещё не отметило
+вложенное выражение. Используется через defer.
+*/
+func locateError(node ast.Expr) {
+	if r := recover(); r != nil {
+		switch r.(type) {
+		case ExitSignal, *EvalError, *locatedError:
+			panic(r)
+		}
+		panic(&locatedError{Value: r, Position: node.Pos()})
+	}
+}
+
+/*
+Разбирает и выполняет source в окружении env.
+
+Ошибки лексера, парсера и выполнения оборачиваются в EvalError,
+сигнал exit() пробрасывается без изменений.
+*/
+func evalSource(source string, env Environment) RuntimeVal {
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		panic(&EvalError{Source: source, Errors: errs})
+	}
+	program, errs := parser.Parse(tokens, source)
+	if len(errs) > 0 {
+		panic(&EvalError{Source: source, Errors: errs})
+	}
+
+	var result RuntimeVal = NullVal{}
+	for _, stmt := range program.Body {
+		result = evalSourceStmt(source, stmt, env)
+	}
+	return result
+}
+
+func evalSourceStmt(source string, stmt ast.Stmt, env Environment) RuntimeVal {
+	defer func() {
+		if r := recover(); r != nil {
+			pos := stmt.Pos()
+			switch err := r.(type) {
+			case ExitSignal, *EvalError:
+				panic(r)
+			case *locatedError:
+				pos = err.Position
+				r = err.Value
+			}
+			panic(&EvalError{
+				Source: source,
+				Errors: []string{fmt.Sprintf("Runtime Error at %s:\n%s\n%v", pos.String(), helpers.Snippet(source, pos.StartPos, pos.EndPos), r)},
+			})
+		}
+	}()
+
+	env.locate = source
+	return EvaluateStmt(stmt, env)
+}
+
+func evalArgSource(arg RuntimeVal, fnName string) string {
+	source, ok := arg.(StringVal)
+	if !ok {
+		panic(fmt.Sprintf("String required for %s function", fnName))
+	}
+	return source.Value
+}
+
+/*
+eval(code) - выполняет код в окружении вызывающего.
+*/
+func Eval(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 1)
+	return evalSource(evalArgSource(args[0], "eval"), env)
+}
+
+/*
+evalSandbox(code) - выполняет код в новом глобальном окружении
+с теми же возможностями, что и у вызывающего.
+*/
+func EvalSandbox(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 1)
+	return evalSource(evalArgSource(args[0], "evalSandbox"), env.sandbox())
+}
+
+/*
+evalIn(environment, code) - выполняет код в переданном окружении.
+*/
+func EvalIn(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 2)
+	target, ok := args[0].(EnvironmentVal)
+	if !ok {
+		panic("Environment required as first argument of evalIn function")
+	}
+	return evalSource(evalArgSource(args[1], "evalIn"), target.Env)
+}
+
+/*
+newEnv() - создаёт новое изолированное окружение.
+*/
+func NewEnv(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 0)
+	return EnvironmentVal{
+		Env: env.sandbox(),
+	}
+}
+
+/*
+currentEnv() - возвращает окружение вызывающего.
+*/
+func CurrentEnv(args []RuntimeVal, env Environment) RuntimeVal {
+	handleArgs(len(args), 0)
+	return EnvironmentVal{
+		Env: env,
+	}
+}
@@ -0,0 +1,29 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+)
+
+/*
+Ошибка выполнения внутри eval указывает на выражение, в котором она
+произошла, а не на начало инструкции.
+*/
+func TestEvalErrorPosition(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`eval("var b = 2\nb + 1 + missing")`, "Runtime Error at 2:9:\nmissing\n"},
+		{`eval("println(1, 2 & 1.5)")`, "Runtime Error at 1:12:\n2 & 1.5\n"},
+		{`eval("fun f() { missing }\nvar x = 1\nx = f()")`, "Runtime Error at 1:11:\nmissing\n"},
+		{`fun g() { missing }
+eval("var x = 1\nx = g()")`, "Runtime Error at 2:5:\ng()\n"},
+	}
+	for _, tt := range tests {
+		_, err := run(tt.source)
+		if !strings.Contains(err, tt.want) {
+			t.Errorf("%q: error %q does not contain %q", tt.source, err, tt.want)
+		}
+	}
+}
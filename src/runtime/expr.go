@@ -3,6 +3,9 @@ package runtime
 import (
 	"finescript/src/ast"
 	"finescript/src/lexer"
+	"fmt"
+	"math"
+	"strings"
 )
 
 func evalLogicalOperations(leftVal RuntimeVal, rightVal RuntimeVal, Op lexer.Token) RuntimeVal {
@@ -15,20 +18,96 @@ func evalLogicalOperations(leftVal RuntimeVal, rightVal RuntimeVal, Op lexer.Tok
 		return BoolVal{
 			Value: ToBool(leftVal).Value || ToBool(rightVal).Value,
 		}
+	case lexer.QUESTION_QUESTION:
+		if IsNullish(leftVal) {
+			return rightVal
+		}
+		return leftVal
 	default:
 		panic("Unknown Binary Operator")
 	}
 }
 
+/*
+Равенство для == и !=: значения равны, если у них один тип и одно
+значение. Целые и дробные числа сравниваются как числа, поэтому 1 == 1.0.
+null и undefined равны только самим себе: null == null, но
+null != undefined и null != false. Функции и окружения равны, только если
+это одно и то же объявление или окружение.
+*/
+func Equals(leftVal RuntimeVal, rightVal RuntimeVal) bool {
+	switch l := leftVal.(type) {
+	case IntVal:
+		switch r := rightVal.(type) {
+		case IntVal:
+			return l.Value == r.Value
+		case FloatVal:
+			return float64(l.Value) == r.Value
+		}
+	case FloatVal:
+		switch r := rightVal.(type) {
+		case IntVal:
+			return l.Value == float64(r.Value)
+		case FloatVal:
+			return l.Value == r.Value
+		}
+	case StringVal:
+		r, ok := rightVal.(StringVal)
+		return ok && l.Value == r.Value
+	case BoolVal:
+		r, ok := rightVal.(BoolVal)
+		return ok && l.Value == r.Value
+	case NullVal:
+		_, ok := rightVal.(NullVal)
+		return ok
+	case UndefinedVal:
+		_, ok := rightVal.(UndefinedVal)
+		return ok
+	case FunctionVal:
+		r, ok := rightVal.(FunctionVal)
+		return ok && l.Name == r.Name && sameBody(l.Body, r.Body) && l.DeclarationEnv.Same(&r.DeclarationEnv)
+	case CompiledFnVal:
+		r, ok := rightVal.(CompiledFnVal)
+		return ok && l.Code == r.Code && sameClosure(l.Closure, r.Closure)
+	case NativeFnVal:
+		r, ok := rightVal.(NativeFnVal)
+		return ok && l.Name == r.Name
+	case EnvironmentVal:
+		r, ok := rightVal.(EnvironmentVal)
+		return ok && l.Env.Same(&r.Env)
+	case TypeAliasVal:
+		r, ok := rightVal.(TypeAliasVal)
+		return ok && l.Name == r.Name
+	}
+	return false
+}
+
+func sameBody(left []ast.Stmt, right []ast.Stmt) bool {
+	if len(left) == 0 || len(right) == 0 {
+		return len(left) == len(right)
+	}
+	return &left[0] == &right[0]
+}
+
+func sameClosure(left any, right any) bool {
+	// Замыкание vm умеет сравнивать себя само
+	if l, ok := left.(interface{ Same(other any) bool }); ok {
+		return l.Same(right)
+	}
+	l, lok := left.(Environment)
+	r, rok := right.(Environment)
+	return lok && rok && l.Same(&r)
+}
+
 func evalComparisonOperations(leftVal RuntimeVal, rightVal RuntimeVal, Op lexer.Token) RuntimeVal {
 	switch Op.Kind {
 	case lexer.EQUALS:
 		return BoolVal{
-			Value: ToBool(leftVal).Value == ToBool(rightVal).Value,
+			Value: Equals(leftVal, rightVal),
 		}
 	case lexer.NOT_EQUALS:
 		return BoolVal{
-			Value: ToBool(leftVal).Value != ToBool(rightVal).Value,
+			Value: !Equals(leftVal, rightVal),
 		}
 	case lexer.LESS:
 		return BoolVal{
@@ -179,22 +258,145 @@ func evalArithmetiсOperations(leftVal RuntimeVal, rightVal RuntimeVal, Op lexer
 		default:
 			panic("These types cannot be separated from each other using a remainder.")
 		}
+
+	case lexer.STAR_STAR:
+		switch leftType := leftVal.(type) {
+		case IntVal:
+			switch right := rightVal.(type) {
+			case IntVal:
+				if right.Value >= 0 {
+					return IntVal{
+						Value: intPow(leftType.Value, right.Value),
+					}
+				}
+				return FloatVal{
+					Value: math.Pow(float64(leftType.Value), float64(right.Value)),
+				}
+			case FloatVal:
+				return FloatVal{
+					Value: math.Pow(float64(leftType.Value), right.Value),
+				}
+			default:
+				panic("These types cannot be raised to a power.")
+			}
+		case FloatVal:
+			return FloatVal{
+				Value: math.Pow(leftType.Value, ToFloat(rightVal).Value),
+			}
+		default:
+			panic("These types cannot be raised to a power.")
+		}
+
+	case lexer.TILDE_SLASH:
+		// Целочисленное деление с отбрасыванием дробной части для любых чисел
+		switch leftVal.(type) {
+		case IntVal, FloatVal:
+		default:
+			panic("These types cannot be integer divided.")
+		}
+		switch right := rightVal.(type) {
+		case IntVal:
+			if right.Value == 0 {
+				panic("Integer division by zero.")
+			}
+			if left, ok := leftVal.(IntVal); ok {
+				return IntVal{
+					Value: left.Value / right.Value,
+				}
+			}
+		case FloatVal:
+			if right.Value == 0 {
+				panic("Integer division by zero.")
+			}
+		default:
+			panic("These types cannot be integer divided.")
+		}
+		return IntVal{
+			Value: int64(math.Trunc(ToFloat(leftVal).Value / ToFloat(rightVal).Value)),
+		}
+
+	default:
+		return evalBitwiseOperations(leftVal, rightVal, Op)
+	}
+}
+
+/*
+Битовые операторы определены только для целых чисел.
+*/
+func evalBitwiseOperations(leftVal RuntimeVal, rightVal RuntimeVal, Op lexer.Token) RuntimeVal {
+	switch Op.Kind {
+	case lexer.AMPERSAND, lexer.PIPE, lexer.CARET, lexer.SHIFT_LEFT, lexer.SHIFT_RIGHT:
 	default:
 		return evalComparisonOperations(leftVal, rightVal, Op)
 	}
+
+	left, leftOk := leftVal.(IntVal)
+	right, rightOk := rightVal.(IntVal)
+	if !leftOk || !rightOk {
+		panic("Bitwise operators can only be applied to integers.")
+	}
+
+	switch Op.Kind {
+	case lexer.AMPERSAND:
+		return IntVal{Value: left.Value & right.Value}
+	case lexer.PIPE:
+		return IntVal{Value: left.Value | right.Value}
+	case lexer.CARET:
+		return IntVal{Value: left.Value ^ right.Value}
+	}
+
+	if right.Value < 0 {
+		panic(fmt.Sprintf("Shift count cannot be negative, got %d.", right.Value))
+	}
+	if Op.Kind == lexer.SHIFT_LEFT {
+		return IntVal{Value: left.Value << right.Value}
+	}
+	return IntVal{Value: left.Value >> right.Value}
+}
+
+/*
+Целая степень возведением в квадрат, переполнение - как у умножения int.
+*/
+func intPow(base int64, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+/*
+Применяет бинарный оператор op к уже вычисленным операндам.
+*/
+func BinaryOp(leftVal RuntimeVal, rightVal RuntimeVal, op lexer.Token) RuntimeVal {
+	return evalArithmetiсOperations(leftVal, rightVal, op)
 }
 
 func evalBinaryExpr(expr ast.BinaryExpr, env Environment) RuntimeVal {
 	leftVal := evaluateExpr(expr.Left, env)
+	// Правая часть ?? вычисляется, только если левая отсутствует
+	if expr.Op.Kind == lexer.QUESTION_QUESTION && !IsNullish(leftVal) {
+		return leftVal
+	}
 	rightVal := evaluateExpr(expr.Right, env)
 
-	return evalArithmetiсOperations(leftVal, rightVal, expr.Op)
+	return BinaryOp(leftVal, rightVal, expr.Op)
 }
 
-func evalUnaryExpr(expr ast.UnaryExpr, env Environment) RuntimeVal {
-	value := evaluateExpr(expr.Expr, env)
-	switch expr.Op.Kind {
+/*
+Применяет унарный оператор op к значению. Для ++ и -- возвращается
+новое значение, присваивание выполняет вызывающий.
+*/
+func UnaryOp(value RuntimeVal, op lexer.Token) RuntimeVal {
+	switch op.Kind {
 	case lexer.MINUS:
+		if integer, ok := value.(IntVal); ok {
+			return IntVal{Value: -integer.Value}
+		}
 		return FloatVal{
 			Value: -ToFloat(value).Value,
 		}
@@ -202,43 +404,117 @@ func evalUnaryExpr(expr ast.UnaryExpr, env Environment) RuntimeVal {
 		return BoolVal{
 			Value: !ToBool(value).Value,
 		}
+	case lexer.TILDE:
+		integer, ok := value.(IntVal)
+		if !ok {
+			panic("Bitwise operators can only be applied to integers.")
+		}
+		return IntVal{
+			Value: ^integer.Value,
+		}
 	case lexer.PLUS_PLUS:
-		var result RuntimeVal = FloatVal{
-			Value: ToFloat(value).Value + 1,
+		if integer, ok := value.(IntVal); ok {
+			return IntVal{Value: integer.Value + 1}
 		}
-		switch ident := expr.Expr.(type) {
-		case ast.Identifier:
-			env.assignVar(ident.Name, result)
+		return FloatVal{
+			Value: ToFloat(value).Value + 1,
 		}
-		return result
 	case lexer.MINUS_MINUS:
-		var result RuntimeVal = FloatVal{
+		if integer, ok := value.(IntVal); ok {
+			return IntVal{Value: integer.Value - 1}
+		}
+		return FloatVal{
 			Value: ToFloat(value).Value - 1,
 		}
+	default:
+		panic("Unknown Unary Operator")
+	}
+}
+
+func evalUnaryExpr(expr ast.UnaryExpr, env Environment) RuntimeVal {
+	result := UnaryOp(evaluateExpr(expr.Expr, env), expr.Op)
+	switch expr.Op.Kind {
+	case lexer.PLUS_PLUS, lexer.MINUS_MINUS:
 		switch ident := expr.Expr.(type) {
 		case ast.Identifier:
-			env.assignVar(ident.Name, result)
+			env.lookupIdent(ident).assign(ident.Name, result)
 		}
-		return result
-	default:
-		panic("Unknown Unary Operator")
 	}
+	return result
 }
 
 func evalCallExpr(expr ast.CallExpr, env Environment) RuntimeVal {
+	// У f?.(args) функция вычисляется первой, чтобы не вычислять
+	// аргументы, если её нет
+	if expr.Optional {
+		caller := evaluateExpr(expr.Caller, env)
+		if IsNullish(caller) {
+			return UndefinedVal{}
+		}
+		return Call(caller, evalArgs(expr.Args, env), env, expr.Position)
+	}
+
+	args := evalArgs(expr.Args, env)
+	return Call(evaluateExpr(expr.Caller, env), args, env, expr.Position)
+}
+
+func evalArgs(exprs []ast.Expr, env Environment) []RuntimeVal {
 	var args []RuntimeVal
-	for _, arg := range expr.Args {
+	for _, arg := range exprs {
 		args = append(args, evaluateExpr(arg, env))
 	}
-	caller := evaluateExpr(expr.Caller, env)
+	return args
+}
+
+func evalConditionalExpr(expr ast.ConditionalExpr, env Environment) RuntimeVal {
+	if ToBool(evaluateExpr(expr.Condition, env)).Value {
+		return evaluateExpr(expr.Consequent, env)
+	}
+	return evaluateExpr(expr.Alternate, env)
+}
 
+func evalTemplateExpr(expr ast.TemplateExpr, env Environment) RuntimeVal {
+	parts := make([]RuntimeVal, 0, len(expr.Parts))
+	for _, part := range expr.Parts {
+		parts = append(parts, evaluateExpr(part, env))
+	}
+	return Template(parts)
+}
+
+/*
+Склеивает части шаблонной строки. Значения приводятся к строке так же,
+как при выводе через print.
+*/
+func Template(parts []RuntimeVal) StringVal {
+	var sb strings.Builder
+	for _, part := range parts {
+		sb.WriteString(Format(part))
+	}
+	return StringVal{Value: sb.String()}
+}
+
+/*
+Вызывает функцию с уже вычисленными аргументами. pos указывает на место
+вызова и используется в сообщениях об ошибках eval.
+*/
+func Call(caller RuntimeVal, args []RuntimeVal, env Environment, pos lexer.Position) RuntimeVal {
+	if env.tracer != nil {
+		return traceCall(caller, args, env, pos)
+	}
+	return call(caller, args, env, pos)
+}
+
+func call(caller RuntimeVal, args []RuntimeVal, env Environment, pos lexer.Position) RuntimeVal {
 	switch callerType := caller.(type) {
 	case NativeFnVal:
+		defer annotateCallSite(pos)
 		return callerType.Call(args, env)
 	case FunctionVal:
-		scope := Environment{
-			parent:    &callerType.DeclarationEnv,
-			variables: make(map[string]variable),
+		scope := newEnvironment(&callerType.DeclarationEnv)
+		// Позиции в функции, объявленной вне этого eval, относятся к
+		// другому исходному коду, ошибку в ней отметит место вызова
+		if scope.locate != env.locate {
+			scope.locate = ""
 		}
 
 		for i, param := range callerType.Params {
@@ -247,7 +523,11 @@ func evalCallExpr(expr ast.CallExpr, env Environment) RuntimeVal {
 			} else if len(callerType.Params) < len(args) {
 				panic("Arg num more then Param num")
 			}
-			scope.declareVar(param.Name, args[i], false)
+			scope.declareSlot(param.Name, &ast.Binding{Slot: i}, args[i], false)
+		}
+		if scope.debugger != nil {
+			scope.debugger.EnterCall(callerType, scope, pos)
+			defer scope.debugger.ExitCall(callerType)
 		}
 
 		var result RuntimeVal = NullVal{}
@@ -261,62 +541,96 @@ func evalCallExpr(expr ast.CallExpr, env Environment) RuntimeVal {
 	}
 }
 
-func evalAssignExpr(expr ast.AssignExpr, env Environment) RuntimeVal {
-	switch assigne := expr.Assigne.(type) {
-	case ast.Identifier:
-		equals := false
-		for typ := range inferType(env.lookupVar(assigne.Name).Value) {
-			for exprTyp := range inferType(evaluateExpr(expr.Expr, env)) {
-				if typ == exprTyp {
-					equals = true
-					break
-				}
+/*
+Составные присваивания, которые вычисляются через бинарный оператор.
+*/
+var compoundOperators = map[lexer.TokenKind]lexer.TokenKind{
+	lexer.STAR_EQUALS:        lexer.STAR,
+	lexer.SLASH_EQUALS:       lexer.SLASH,
+	lexer.PERCENT_EQUALS:     lexer.PERCENT,
+	lexer.STAR_STAR_EQUALS:   lexer.STAR_STAR,
+	lexer.TILDE_SLASH_EQUALS: lexer.TILDE_SLASH,
+	lexer.AMPERSAND_EQUALS:   lexer.AMPERSAND,
+	lexer.PIPE_EQUALS:        lexer.PIPE,
+	lexer.CARET_EQUALS:       lexer.CARET,
+	lexer.SHIFT_LEFT_EQUALS:  lexer.SHIFT_LEFT,
+	lexer.SHIFT_RIGHT_EQUALS: lexer.SHIFT_RIGHT,
+}
+
+/*
+Является ли kind составным присваиванием вида op=.
+*/
+func IsCompoundAssign(kind lexer.TokenKind) bool {
+	_, exists := compoundOperators[kind]
+	return exists || kind == lexer.PLUS_EQUALS || kind == lexer.MINUS_EQUALS
+}
+
+/*
+Вычисляет результат составного присваивания (+=, -=, *= и т.д.) для
+текущего значения переменной current.
+*/
+func CompoundAssign(current RuntimeVal, value RuntimeVal, op lexer.Token) RuntimeVal {
+	if binaryKind, exists := compoundOperators[op.Kind]; exists {
+		return BinaryOp(current, value, lexer.Token{
+			Kind:     binaryKind,
+			Value:    strings.TrimSuffix(op.Value, "="),
+			Position: op.Position,
+		})
+	}
+
+	switch op.Kind {
+	case lexer.PLUS_EQUALS:
+		switch var_ := current.(type) {
+		case IntVal:
+			return IntVal{
+				Value: var_.Value + ToInt(value).Value,
 			}
-			if equals {
-				break
+		case FloatVal:
+			return FloatVal{
+				Value: var_.Value + ToFloat(value).Value,
 			}
+		case StringVal:
+			return StringVal{
+				Value: var_.Value + ToString(value).Value,
+			}
+		default:
+			panic("Cannot use PLUS_EQUALS to this assigne")
 		}
-
-		if !equals {
-			panic("Types of assigne and expr not equals")
-		}
-
-		switch expr.Op.Kind {
-		case lexer.ASSIGNMENT:
-			return env.assignVar(assigne.Name, evaluateExpr(expr.Expr, env))
-		case lexer.PLUS_EQUALS:
-			switch var_ := env.lookupVar(assigne.Name).Value.(type) {
-			case IntVal:
-				return env.assignVar(assigne.Name, IntVal{
-					Value: var_.Value + ToInt(evaluateExpr(expr.Expr, env)).Value,
-				})
-			case FloatVal:
-				return env.assignVar(assigne.Name, FloatVal{
-					Value: var_.Value + ToFloat(evaluateExpr(expr.Expr, env)).Value,
-				})
-			case StringVal:
-				return env.assignVar(assigne.Name, StringVal{
-					Value: var_.Value + ToString(evaluateExpr(expr.Expr, env)).Value,
-				})
-			default:
-				panic("Cannot use PLUS_EQUALS to this assigne")
+	case lexer.MINUS_EQUALS:
+		switch var_ := current.(type) {
+		case IntVal:
+			return IntVal{
+				Value: var_.Value - ToInt(value).Value,
 			}
-		case lexer.MINUS_EQUALS:
-			switch var_ := env.lookupVar(assigne.Name).Value.(type) {
-			case IntVal:
-				return env.assignVar(assigne.Name, IntVal{
-					Value: var_.Value - ToInt(evaluateExpr(expr.Expr, env)).Value,
-				})
-			case FloatVal:
-				return env.assignVar(assigne.Name, FloatVal{
-					Value: var_.Value - ToFloat(evaluateExpr(expr.Expr, env)).Value,
-				})
-			default:
-				panic("Cannot use MINUS_EQUALS to this assigne")
+		case FloatVal:
+			return FloatVal{
+				Value: var_.Value - ToFloat(value).Value,
 			}
 		default:
-			panic("Unknown Op kind")
+			panic("Cannot use MINUS_EQUALS to this assigne")
+		}
+	default:
+		panic("Unknown Op kind")
+	}
+}
+
+func evalAssignExpr(expr ast.AssignExpr, env Environment) RuntimeVal {
+	switch assigne := expr.Assigne.(type) {
+	case ast.Identifier:
+		target := env.lookupIdent(assigne)
+		current := target.Value
+		// x ??= y не вычисляет y, если у x уже есть значение
+		if expr.Op.Kind == lexer.QUESTION_QUESTION_EQUALS {
+			if !IsNullish(current) {
+				return current
+			}
+			return target.assign(assigne.Name, evaluateExpr(expr.Expr, env))
+		}
+		value := evaluateExpr(expr.Expr, env)
+		if expr.Op.Kind == lexer.ASSIGNMENT {
+			return target.assign(assigne.Name, value)
 		}
+		return target.assign(assigne.Name, CompoundAssign(current, value, expr.Op))
 
 	default:
 		panic("Invalid left hand side expr inside assignment expr")
@@ -0,0 +1,130 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+)
+
+/*
+Выполняет код в новом глобальном окружении и возвращает ошибку
+выполнения, если она была.
+*/
+func run(source string) (result RuntimeVal, err string) {
+	defer func() {
+		if r := recover(); r != nil {
+			evalErr, ok := r.(*EvalError)
+			if !ok {
+				panic(r)
+			}
+			err = strings.Join(evalErr.Errors, "\n")
+		}
+	}()
+	return evalSource(source, GlobalEnv()), ""
+}
+
+func TestEquality(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"1 == 6", false},
+		{"1 != 6", true},
+		{"6 == 6", true},
+		{"1 == 1.0", true},
+		{"\"x\" == \"y\"", false},
+		{"\"x\" == \"x\"", true},
+		{"true == 1", false},
+		{"\"\" == false", false},
+		{"0 == false", false},
+		{"null == null", true},
+		{"null == undefined", false},
+		{"null == false", false},
+		{"0 != null", true},
+		{"println == println", true},
+		{"println == print", false},
+		{"fun f() {}\nfun g() {}\nf == g", false},
+		{"fun f() {}\nvar h = f\nf == h", true},
+	}
+	for _, tt := range tests {
+		result, err := run(tt.source)
+		if err != "" {
+			t.Errorf("%q: %s", tt.source, err)
+			continue
+		}
+		if got := ToBool(result).Value; got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+}
+
+/*
+Переменная сохраняет тип первого значения, даже если её обнулили.
+*/
+func TestAssignKeepsType(t *testing.T) {
+	tests := []struct {
+		source string
+		ok     bool
+	}{
+		{"var a = 1\na = 2", true},
+		{"var a = 1\na = \"s\"", false},
+		{"var a = 1\na = null\na = 3", true},
+		{"var a = 1\na = null\na = \"s\"", false},
+		{"var a = null\na = \"s\"\na = undefined\na = \"t\"", true},
+		{"var a = null\na = \"s\"\na = 1", false},
+		{"var a = undefined\na ??= 1\na = \"s\"", false},
+		{"var a = 1\na++\na--\na = 5", true},
+		{"var a = 1.5\na++\na = 2.5", true},
+	}
+	for _, tt := range tests {
+		_, err := run(tt.source)
+		if ok := err == ""; ok != tt.ok {
+			t.Errorf("%q: ok = %v, want %v (%s)", tt.source, ok, tt.ok, err)
+		}
+		if err != "" && !strings.Contains(err, "Types of assigne and expr not equals") {
+			t.Errorf("%q: unexpected error %s", tt.source, err)
+		}
+	}
+}
+
+func TestOptionalCallSkipsArgs(t *testing.T) {
+	result, err := run("var calls = 0\nfun count() { calls = calls + 1 }\nvar f = null\nf?.(count())\ncount?.(count())\ncalls")
+	if err != "" {
+		t.Fatal(err)
+	}
+	if Format(result) != "2" {
+		t.Errorf("calls = %s, want 2", Format(result))
+	}
+}
+
+/*
+Минус у целого числа оставляет его целым, поэтому с ним работают битовые
+операторы.
+*/
+func TestNegativeIntegers(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"5 & -1", "5"},
+		{"-8 >> 1", "-4"},
+		{"~-1", "0"},
+		{"-5", "-5"},
+		{"-2.5", "-2.5"},
+		{"var a = 3\na = -a\na", "-3"},
+	}
+	for _, tt := range tests {
+		result, err := run(tt.source)
+		if err != "" {
+			t.Errorf("%q: %s", tt.source, err)
+			continue
+		}
+		if got := Format(result); got != tt.want {
+			t.Errorf("%q = %s, want %s", tt.source, got, tt.want)
+		}
+	}
+
+	_, err := run("1 >> -1")
+	if !strings.Contains(err, "Shift count cannot be negative, got -1.") {
+		t.Errorf("1 >> -1: unexpected error %q", err)
+	}
+}
@@ -85,6 +85,10 @@ func ToString(val RuntimeVal) StringVal {
 		return StringVal{
 			Value: strconv.FormatBool(v.Value),
 		}
+	case NullVal, UndefinedVal:
+		return StringVal{
+			Value: Format(v),
+		}
 	default:
 		panic(fmt.Errorf("unsupported type for string conversion: %T", val))
 	}
@@ -106,11 +110,27 @@ func ToBool(val RuntimeVal) BoolVal {
 		}
 	case BoolVal:
 		return v
+	case NullVal, UndefinedVal:
+		return BoolVal{
+			Value: false,
+		}
 	default:
 		panic(fmt.Errorf("unsupported type for bool conversion: %T", val))
 	}
 }
 
+/*
+null и undefined - отсутствующие значения, на которые реагируют ??, ??= и ?.
+*/
+func IsNullish(val RuntimeVal) bool {
+	switch val.(type) {
+	case NullVal, UndefinedVal:
+		return true
+	default:
+		return false
+	}
+}
+
 //
 // Форматирование
 //
@@ -131,6 +151,8 @@ func Format(val RuntimeVal) string {
 		}
 	case NullVal:
 		return "null"
+	case UndefinedVal:
+		return "undefined"
 	// case ArrayVal:
 	// 	result := "["
 	// 	for i, elem := range valType.Elements {
@@ -160,13 +182,44 @@ func Format(val RuntimeVal) string {
 		}
 		result += ")"
 		return result
+	case CompiledFnVal:
+		result := valType.Name + "("
+		for _, param := range valType.Params {
+			result += fmt.Sprintf("%s: %s, ", param.Name, param.Type)
+		}
+		result += ")"
+		return result
 	case NativeFnVal:
 		return valType.Name + "()"
+	case EnvironmentVal:
+		return "<environment>"
 	default:
 		return fmt.Sprintf("%#v", val)
 	}
 }
 
+/*
+Значение для отладочного вывода: строки в кавычках, функции, окружения
+и типы - одним словом с именем, как в трассировке и отладчике.
+*/
+func Display(value RuntimeVal) string {
+	switch v := value.(type) {
+	case StringVal:
+		return strconv.Quote(v.Value)
+	case FunctionVal:
+		return "fun " + v.Name
+	case NativeFnVal:
+		return "fun " + v.Name
+	case CompiledFnVal:
+		return "fun " + v.Name
+	case EnvironmentVal:
+		return "environment"
+	case TypeAliasVal:
+		return "type " + v.Name
+	}
+	return Format(value)
+}
+
 func sprint_format(vals []RuntimeVal) string {
 	result := ""
 	for _, val := range vals {
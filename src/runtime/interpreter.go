@@ -6,15 +6,25 @@ import (
 )
 
 func EvaluateStmt(node ast.Stmt, env Environment) RuntimeVal {
+	if env.debugger != nil {
+		env.debugger.Stmt(node, env)
+	}
+	if env.tracer != nil {
+		return traceStmt(node, env)
+	}
+	return evaluateStmt(node, env)
+}
+
+func evaluateStmt(node ast.Stmt, env Environment) RuntimeVal {
 	switch stmt := node.(type) {
 	case ast.Program:
 		return evalProgram(stmt, env)
 	case ast.BlockStmt:
 		return evalBlockStmt(stmt, env)
 	case ast.VarDeclStmt:
-		return env.declareVar(stmt.Name, evaluateExpr(stmt.Value, env), stmt.IsConstant)
+		return env.declareSlot(stmt.Name, stmt.Binding, evaluateExpr(stmt.Value, env), stmt.IsConstant)
 	case ast.FunDeclStmt:
-		return env.declareVar(stmt.Name, FunctionVal{
+		return env.declareSlot(stmt.Name, stmt.Binding, FunctionVal{
 			Name:           stmt.Name,
 			Params:         stmt.Params,
 			Body:           stmt.Body,
@@ -23,7 +33,7 @@ func EvaluateStmt(node ast.Stmt, env Environment) RuntimeVal {
 		}, true)
 	case ast.TypeAliasDecl:
 		resolveType(stmt.Type, env)
-		return env.declareVar(stmt.Name, TypeAliasVal{
+		return env.declareSlot(stmt.Name, stmt.Binding, TypeAliasVal{
 			Name: stmt.Name,
 			Type: resolveType(stmt.Type, env),
 		}, true)
@@ -37,9 +47,15 @@ func EvaluateStmt(node ast.Stmt, env Environment) RuntimeVal {
 }
 
 func evaluateExpr(node ast.Expr, env Environment) RuntimeVal {
+	if env.debugger != nil {
+		env.debugger.Expr(node, env)
+	}
+	if env.locate != "" {
+		defer locateError(node)
+	}
 	switch expr := node.(type) {
 	case ast.Identifier:
-		return env.lookupVar(expr.Name).Value
+		return env.lookupIdent(expr).Value
 	case ast.IntLiteral:
 		return IntVal{
 			Value: expr.Value,
@@ -56,6 +72,10 @@ func evaluateExpr(node ast.Expr, env Environment) RuntimeVal {
 		return BoolVal{
 			Value: expr.Value,
 		}
+	case ast.NullLiteral:
+		return NullVal{}
+	case ast.UndefinedLiteral:
+		return UndefinedVal{}
 	// case ast.ArrayLiteral:
 	// 	result := make([]RuntimeVal, 0)
 	// 	for _, elem := range expr.Elements {
@@ -69,9 +89,16 @@ func evaluateExpr(node ast.Expr, env Environment) RuntimeVal {
 	case ast.UnaryExpr:
 		return evalUnaryExpr(expr, env)
 	case ast.AssignExpr:
+		if env.tracer != nil {
+			return traceAssign(expr, env)
+		}
 		return evalAssignExpr(expr, env)
 	case ast.CallExpr:
 		return evalCallExpr(expr, env)
+	case ast.ConditionalExpr:
+		return evalConditionalExpr(expr, env)
+	case ast.TemplateExpr:
+		return evalTemplateExpr(expr, env)
 	default:
 		panic(fmt.Sprintf("Unknown Expr at %s", expr.Pos().String()))
 	}
@@ -0,0 +1,79 @@
+package runtime
+
+import (
+	"finescript/src/ast"
+)
+
+/*
+Доступ к переменным для vm. Те переменные, до которых может добраться
+eval, она хранит в тех же окружениях, что и интерпретатор: ячейки
+доступны и по слоту, и по имени, поэтому eval внутри байткода видит
+переменные программы, а программа - объявленное в eval. Остальные
+лежат в кадрах vm ячейками Slot.
+*/
+
+/*
+Переменная в кадре vm. Присваивание проверяет константность и тип
+так же, как у переменной окружения.
+*/
+type Slot struct {
+	variable
+}
+
+func NewSlot(value RuntimeVal, isConstant bool) Slot {
+	return Slot{variable{
+		IsConstant: isConstant,
+		Value:      value,
+		Type:       valueType(value),
+	}}
+}
+
+func (s *Slot) Assign(varname string, value RuntimeVal) RuntimeVal {
+	return s.assign(varname, value)
+}
+
+/*
+Новое окружение внутри env, как у блока или вызова функции.
+*/
+func (env *Environment) Child() Environment {
+	parent := *env
+	return newEnvironment(&parent)
+}
+
+/*
+Объявляет переменную только по имени, как глобальные переменные
+программы.
+*/
+func (env *Environment) DeclareName(varname string, value RuntimeVal, isConstant bool) {
+	env.declareVar(varname, value, isConstant)
+}
+
+/*
+Объявляет переменную в слоте slot этого окружения.
+*/
+func (env *Environment) DeclareSlot(varname string, slot int, value RuntimeVal, isConstant bool) {
+	env.declareSlot(varname, &ast.Binding{Slot: slot}, value, isConstant)
+}
+
+/*
+Значение переменной из слота окружения, которое лежит на depth уровней
+выше env. varname нужен только для сообщения об ошибке.
+*/
+func (env *Environment) LookupSlot(varname string, depth int, slot int) RuntimeVal {
+	return env.slot(varname, depth, slot).Value
+}
+
+func (env *Environment) AssignSlot(varname string, depth int, slot int, value RuntimeVal) RuntimeVal {
+	return env.slot(varname, depth, slot).assign(varname, value)
+}
+
+/*
+Значение переменной по имени; ошибка, если её нет во всей цепочке.
+*/
+func (env *Environment) LookupName(varname string) RuntimeVal {
+	return env.resolve(varname).variables[varname].Value
+}
+
+func (env *Environment) AssignName(varname string, value RuntimeVal) RuntimeVal {
+	return env.resolve(varname).variables[varname].assign(varname, value)
+}
@@ -14,10 +14,7 @@ func evalProgram(stmt ast.Program, env Environment) RuntimeVal {
 
 func evalBlockStmt(stmt ast.BlockStmt, env Environment) RuntimeVal {
 	var lastEvaluated RuntimeVal = NullVal{}
-	scope := Environment{
-		parent:    &env,
-		variables: make(map[string]variable),
-	}
+	scope := newEnvironment(&env)
 
 	for _, bodyStmt := range stmt.Body {
 		lastEvaluated = EvaluateStmt(bodyStmt, scope)
@@ -29,19 +26,13 @@ func evalIfStmt(stmt ast.IfStmt, env Environment) RuntimeVal {
 	condition := ToBool(evaluateExpr(stmt.Condition, env)).Value
 
 	if condition {
-		scope := Environment{
-			parent:    &env,
-			variables: make(map[string]variable),
-		}
+		scope := newEnvironment(&env)
 
 		for _, consequentStmt := range stmt.Consequent {
 			EvaluateStmt(consequentStmt, scope)
 		}
 	} else {
-		scope := Environment{
-			parent:    &env,
-			variables: make(map[string]variable),
-		}
+		scope := newEnvironment(&env)
 
 		for _, alternateStmt := range stmt.Alternate {
 			EvaluateStmt(alternateStmt, scope)
@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+)
+
+type TraceKind int
+
+const (
+	TraceStmt TraceKind = iota
+	TraceCall
+	TraceAssign
+)
+
+/*
+Событие выполнения. Name - имя вызываемой функции или переменной, которой
+присваивается значение, Stmt заполнен у инструкций, Args - у вызовов.
+Value передаётся только в Exit: результат инструкции или вызова либо
+присвоенное значение.
+*/
+type TraceEvent struct {
+	Kind     TraceKind
+	Position lexer.Position
+	Stmt     ast.Stmt
+	Name     string
+	Args     []RuntimeVal
+	Value    RuntimeVal
+}
+
+/*
+Получатель событий выполнения инструкций, вызовов функций и
+присваиваний. Enter и Exit приходят парами, вложенные события - между
+ними. Если выполнение прервано ошибкой или exit(), Exit не приходит.
+*/
+type Tracer interface {
+	Enter(event TraceEvent)
+	Exit(event TraceEvent)
+}
+
+/*
+Подключает трассировку к окружению. Как и отладчик, её наследуют
+окружения, созданные после этого внутри env.
+*/
+func (env *Environment) SetTracer(tracer Tracer) {
+	env.tracer = tracer
+}
+
+/*
+Сама программа событием не считается: событиями будут её инструкции.
+*/
+func traceStmt(node ast.Stmt, env Environment) RuntimeVal {
+	if _, ok := node.(ast.Program); ok {
+		return evaluateStmt(node, env)
+	}
+	event := TraceEvent{
+		Kind:     TraceStmt,
+		Position: node.Pos(),
+		Stmt:     node,
+	}
+	env.tracer.Enter(event)
+	event.Value = evaluateStmt(node, env)
+	env.tracer.Exit(event)
+	return event.Value
+}
+
+func traceCall(caller RuntimeVal, args []RuntimeVal, env Environment, pos lexer.Position) RuntimeVal {
+	event := TraceEvent{
+		Kind:     TraceCall,
+		Position: pos,
+		Args:     args,
+	}
+	switch fn := caller.(type) {
+	case NativeFnVal:
+		event.Name = fn.Name
+	case FunctionVal:
+		event.Name = fn.Name
+	}
+	env.tracer.Enter(event)
+	event.Value = call(caller, args, env, pos)
+	env.tracer.Exit(event)
+	return event.Value
+}
+
+func traceAssign(expr ast.AssignExpr, env Environment) RuntimeVal {
+	event := TraceEvent{
+		Kind:     TraceAssign,
+		Position: expr.Position,
+	}
+	if assigne, ok := expr.Assigne.(ast.Identifier); ok {
+		event.Name = assigne.Name
+	}
+	env.tracer.Enter(event)
+	event.Value = evalAssignExpr(expr, env)
+	env.tracer.Exit(event)
+	return event.Value
+}
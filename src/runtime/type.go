@@ -5,10 +5,20 @@ import (
 )
 
 func resolveType(typ ast.Type, env Environment) ast.Type {
+	return ResolveType(typ, func(name string) RuntimeVal {
+		return env.lookupVar(name).Value
+	})
+}
+
+/*
+Раскрывает псевдонимы типов внутри typ. lookup возвращает значение,
+объявленное под именем псевдонима.
+*/
+func ResolveType(typ ast.Type, lookup func(name string) RuntimeVal) ast.Type {
 	switch t := typ.(type) {
 
 	case ast.TypeAlias:
-		val := env.lookupVar(t.Name).Value
+		val := lookup(t.Name)
 		typeAlias, ok := val.(TypeAliasVal)
 		if !ok {
 			// p.errors = append(p.errors, fmt.Sprintf("Expected type alias, got something else at %s", typeAlias.Type.Pos().String()))
@@ -17,24 +27,24 @@ func resolveType(typ ast.Type, env Environment) ast.Type {
 			// }
 			panic("Expected type alias, got something else")
 		}
-		return resolveType(typeAlias.Type, env)
+		return ResolveType(typeAlias.Type, lookup)
 
 	case ast.ArrayType:
 		return ast.ArrayType{
-			ElementType: resolveType(t.ElementType, env),
+			ElementType: ResolveType(t.ElementType, lookup),
 		}
 
 	case ast.UnionType:
 		resolved := make([]ast.Type, 0, len(t.Types))
 		for _, inner := range t.Types {
-			resolved = append(resolved, resolveType(inner, env))
+			resolved = append(resolved, ResolveType(inner, lookup))
 		}
 		return ast.UnionType{Types: resolved}
 
 	case ast.IntersectionType:
 		resolved := make([]ast.Type, 0, len(t.Types))
 		for _, inner := range t.Types {
-			resolved = append(resolved, resolveType(inner, env))
+			resolved = append(resolved, ResolveType(inner, lookup))
 		}
 		return ast.IntersectionType{Types: resolved}
 
@@ -43,12 +53,12 @@ func resolveType(typ ast.Type, env Environment) ast.Type {
 		for _, p := range t.Params {
 			params = append(params, ast.Param{
 				Name: p.Name,
-				Type: resolveType(p.Type, env),
+				Type: ResolveType(p.Type, lookup),
 			})
 		}
 		return ast.FunType{
 			Params:     params,
-			ReturnType: resolveType(t.ReturnType, env),
+			ReturnType: ResolveType(t.ReturnType, lookup),
 		}
 
 	case ast.Struct:
@@ -57,21 +67,23 @@ func resolveType(typ ast.Type, env Environment) ast.Type {
 			switch member := m.(type) {
 			case ast.PropertySignature:
 				members = append(members, ast.PropertySignature{
-					Name: member.Name,
-					Type: resolveType(member.Type, env),
+					Name:     member.Name,
+					Type:     ResolveType(member.Type, lookup),
+					Position: member.Position,
 				})
 			case ast.MethodSignature:
 				params := make([]ast.Param, 0, len(member.Params))
 				for _, p := range member.Params {
 					params = append(params, ast.Param{
 						Name: p.Name,
-						Type: resolveType(p.Type, env),
+						Type: ResolveType(p.Type, lookup),
 					})
 				}
 				members = append(members, ast.MethodSignature{
-					Name:   member.Name,
-					Params: params,
-					Type:   resolveType(member.Type, env),
+					Name:     member.Name,
+					Params:   params,
+					Type:     ResolveType(member.Type, lookup),
+					Position: member.Position,
 				})
 			default:
 				panic("Unknown struct member type")
@@ -115,6 +127,11 @@ func inferType(val RuntimeVal) []ast.Type {
 			Params:     r.Params,
 			ReturnType: r.ReturnType,
 		})
+	case CompiledFnVal:
+		types = append(types, ast.FunKeyword{}, ast.FunType{
+			Params:     r.Params,
+			ReturnType: r.ReturnType,
+		})
 	case NativeFnVal:
 		types = append(types, ast.FunKeyword{})
 	case TypeAliasVal:
@@ -123,3 +140,20 @@ func inferType(val RuntimeVal) []ast.Type {
 
 	return types
 }
+
+/*
+Основной тип значения без literal-типов: int, string, fun и так далее.
+У null и undefined типа нет, их можно присвоить любой переменной.
+*/
+func valueType(val RuntimeVal) ast.Type {
+	switch val.(type) {
+	case NullVal, UndefinedVal:
+		return nil
+	case EnvironmentVal:
+		return ast.ObjectKeyword{}
+	}
+	if types := inferType(val); len(types) > 0 {
+		return types[0]
+	}
+	return nil
+}
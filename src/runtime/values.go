@@ -60,15 +60,48 @@ type FunctionVal struct {
 
 func (r FunctionVal) runtime_val() {}
 
+/*
+Функция, скомпилированная в байткод.
+
+Code хранит прототип функции из пакета compiler, а Closure - окружение
+и кадр, захваченные виртуальной машиной при создании функции.
+*/
+type CompiledFnVal struct {
+	Name       string
+	Params     []ast.Param
+	ReturnType ast.Type
+	Code       any
+	Closure    any
+}
+
+func (r CompiledFnVal) runtime_val() {}
+
 type FunctionCall = func(args []RuntimeVal, env Environment) RuntimeVal
 
+/*
+Встроенная функция. Params, ReturnType и Doc описывают её для справки и
+подсказок, а число аргументов проверяет сам Call. Последние Optional
+параметров можно не передавать, а если Variadic, последний параметр
+принимает любое число аргументов.
+*/
 type NativeFnVal struct {
-	Name string
-	Call FunctionCall
+	Name       string
+	Params     []ast.Param
+	ReturnType ast.Type
+	Optional   int
+	Variadic   bool
+	Doc        string
+	Call       FunctionCall
 }
 
 func (r NativeFnVal) runtime_val() {}
 
+type EnvironmentVal struct {
+	Env Environment
+}
+
+func (r EnvironmentVal) runtime_val() {}
+
 type TypeAliasVal struct {
 	Name string
 	Type ast.Type
@@ -0,0 +1,142 @@
+package tracer
+
+import (
+	"finescript/src/helpers"
+	"finescript/src/lexer"
+	"finescript/src/runtime"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+Печатает журнал выполнения: каждое событие - строка с позицией, вложенные
+события - с отступом. Результат инструкции или присваивания без
+вложенных событий дописывается в ту же строку после =>, иначе выводится
+отдельной строкой с той же позицией. Вызов всегда закрывается отдельной
+строкой, потому что функция может что-то напечатать.
+
+Если заданы имена функций, печатаются только их вызовы и всё, что
+выполняется внутри них.
+*/
+type Logger struct {
+	out       io.Writer
+	text      func(pos lexer.Position) string
+	functions map[string]bool
+	depth     int
+	// Глубина внешнего вызова из functions, -1 вне такого вызова
+	root int
+	// Последняя строка ждёт результата своего события
+	pending bool
+}
+
+/*
+source - текст программы для вывода инструкций. Если его нет,
+печатаются только позиции.
+*/
+func NewLogger(out io.Writer, source string, functions []string) *Logger {
+	return newLogger(out, func(pos lexer.Position) string {
+		return helpers.Snippet(source, pos.StartPos, pos.EndPos)
+	}, functions)
+}
+
+/*
+Как NewLogger, но текст инструкций берётся из потока, из которого
+программа была разобрана.
+*/
+func NewStreamLogger(out io.Writer, stream *lexer.Stream, functions []string) *Logger {
+	return newLogger(out, stream.Text, functions)
+}
+
+func newLogger(out io.Writer, text func(pos lexer.Position) string, functions []string) *Logger {
+	l := &Logger{
+		out:       out,
+		text:      text,
+		functions: make(map[string]bool),
+	}
+	for _, name := range functions {
+		l.functions[name] = true
+	}
+	if len(l.functions) > 0 {
+		l.root = -1
+	}
+	return l
+}
+
+func (l *Logger) Enter(event runtime.TraceEvent) {
+	if l.root < 0 {
+		if event.Kind != runtime.TraceCall || !l.functions[event.Name] {
+			l.depth++
+			return
+		}
+		l.root = l.depth
+	}
+
+	l.finishLine()
+	fmt.Fprintf(l.out, "%s%s %s", l.indent(), event.Position.String(), l.describe(event))
+	if event.Kind == runtime.TraceCall {
+		fmt.Fprintln(l.out)
+	} else {
+		l.pending = true
+	}
+	l.depth++
+}
+
+func (l *Logger) Exit(event runtime.TraceEvent) {
+	l.depth--
+	if l.root < 0 {
+		return
+	}
+
+	if l.pending {
+		fmt.Fprintf(l.out, " => %s\n", runtime.Display(event.Value))
+		l.pending = false
+	} else {
+		label := ""
+		if event.Kind == runtime.TraceCall {
+			label = event.Name + " "
+		}
+		fmt.Fprintf(l.out, "%s%s %s=> %s\n", l.indent(), event.Position.String(), label, runtime.Display(event.Value))
+	}
+
+	if len(l.functions) > 0 && l.depth == l.root {
+		l.root = -1
+	}
+}
+
+/*
+Переводит строку, если она ждала результата, а началось вложенное
+событие.
+*/
+func (l *Logger) finishLine() {
+	if l.pending {
+		fmt.Fprintln(l.out)
+		l.pending = false
+	}
+}
+
+func (l *Logger) indent() string {
+	return strings.Repeat("  ", l.depth-max(l.root, 0))
+}
+
+func (l *Logger) describe(event runtime.TraceEvent) string {
+	switch event.Kind {
+	case runtime.TraceCall:
+		args := make([]string, len(event.Args))
+		for i, arg := range event.Args {
+			args[i] = runtime.Display(arg)
+		}
+		return "call " + event.Name + "(" + strings.Join(args, ", ") + ")"
+	case runtime.TraceAssign:
+		return "assign " + event.Name
+	}
+
+	code := l.text(event.Stmt.Pos())
+	if line, _, multiline := strings.Cut(code, "\n"); multiline {
+		code = strings.TrimSpace(line) + " ..."
+	}
+	if code == "" {
+		return "statement"
+	}
+	return helpers.Ellipsis(code, 60)
+}
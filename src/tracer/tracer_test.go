@@ -0,0 +1,142 @@
+package tracer
+
+import (
+	"finescript/src/ast"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"finescript/src/resolver"
+	"finescript/src/runtime"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const program = `fun sq(x: int) {
+  x * x
+}
+fun twice(x: int) {
+  sq(x) + sq(x)
+}
+var a = 1
+a = twice(2)
+if a > 1 {
+  a += 1
+}
+`
+
+func parse(t *testing.T, source string) ast.Program {
+	t.Helper()
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	program, errs := parser.Parse(tokens, source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	return program
+}
+
+/*
+Журнал выполнения программы с заданным логгером.
+*/
+func trace(t *testing.T, program ast.Program, logger func(out *strings.Builder) *Logger) string {
+	t.Helper()
+	env := runtime.NewGlobalEnv(runtime.CapAll)
+	program, errs := resolver.Resolve(program, "", env.Names())
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	var out strings.Builder
+	env.SetTracer(logger(&out))
+	runtime.Run(program, env)
+	return out.String()
+}
+
+func TestTrace(t *testing.T) {
+	want := `1:1 fun sq(x: int) { ... => fun sq
+4:1 fun twice(x: int) { ... => fun twice
+7:1 var a = 1 => 1
+8:1 a = twice(2)
+  8:1 assign a
+    8:5 call twice(2)
+      5:3 sq(x) + sq(x)
+        5:3 call sq(2)
+          2:3 x * x => 4
+        5:3 sq => 4
+        5:11 call sq(2)
+          2:3 x * x => 4
+        5:11 sq => 4
+      5:3 => 8
+    8:5 twice => 8
+  8:1 => 8
+8:1 => 8
+9:1 if a > 1 { ...
+  10:3 a += 1
+    10:3 assign a => 9
+  10:3 => 9
+9:1 => null
+`
+	got := trace(t, parse(t, program), func(out *strings.Builder) *Logger {
+		return NewLogger(out, program, nil)
+	})
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTraceFunctions(t *testing.T) {
+	tests := []struct {
+		functions []string
+		want      string
+	}{
+		{[]string{"sq"}, "5:3 call sq(2)\n  2:3 x * x => 4\n5:3 sq => 4\n5:11 call sq(2)\n  2:3 x * x => 4\n5:11 sq => 4\n"},
+		// Вложенный вызов отслеживаемой функции не начинает журнал заново
+		{[]string{"twice", "sq"}, "8:5 call twice(2)\n  5:3 sq(x) + sq(x)\n    5:3 call sq(2)\n      2:3 x * x => 4\n    5:3 sq => 4\n    5:11 call sq(2)\n      2:3 x * x => 4\n    5:11 sq => 4\n  5:3 => 8\n8:5 twice => 8\n"},
+		{[]string{"missing"}, ""},
+	}
+	for _, tt := range tests {
+		got := trace(t, parse(t, program), func(out *strings.Builder) *Logger {
+			return NewLogger(out, program, tt.functions)
+		})
+		if got != tt.want {
+			t.Errorf("%v: got\n%s\nwant\n%s", tt.functions, got, tt.want)
+		}
+	}
+}
+
+/*
+Программа, прочитанная потоком, печатается так же, как прочитанная
+целиком: текст инструкций берётся из копии входа. Без текста остаются
+только позиции.
+*/
+func TestStreamLogger(t *testing.T) {
+	spool, err := os.CreateTemp(t.TempDir(), "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	stream := lexer.NewStream(iotest.OneByteReader(strings.NewReader(program))).KeepSource(spool)
+	parsed, errs := parser.ParseStream(stream)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	got := trace(t, parsed, func(out *strings.Builder) *Logger {
+		return NewStreamLogger(out, stream, nil)
+	})
+	want := trace(t, parse(t, program), func(out *strings.Builder) *Logger {
+		return NewLogger(out, program, nil)
+	})
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	got = trace(t, parse(t, "var b = 2"), func(out *strings.Builder) *Logger {
+		return NewLogger(out, "", nil)
+	})
+	if want := "1:1 statement => 2\n"; got != want {
+		t.Errorf("without source got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,310 @@
+package vm
+
+import (
+	"finescript/src/compiler"
+	"finescript/src/lexer"
+	"finescript/src/runtime"
+	"fmt"
+)
+
+/*
+Стековая виртуальная машина, выполняющая байткод пакета compiler
+с той же семантикой, что и интерпретатор runtime.
+
+Программа выполняется в env, переданном в New. Локальные переменные
+блоков и вызовов функций лежат в кадрах - массивах, к которым байткод
+обращается по номеру слота. Только области, до которых может добраться
+eval, получают дочерние окружения runtime, как в интерпретаторе, чтобы
+eval видел их переменные по имени.
+*/
+type VM struct {
+	constants []runtime.RuntimeVal
+	main      *compiler.Function
+	env       runtime.Environment
+	stack     []runtime.RuntimeVal
+}
+
+/*
+Локальные переменные одного блока или вызова функции.
+*/
+type frame struct {
+	slots  []runtime.Slot
+	parent *frame
+}
+
+func newFrame(size int, parent *frame) *frame {
+	return &frame{
+		slots:  make([]runtime.Slot, size),
+		parent: parent,
+	}
+}
+
+/*
+Ячейка переменной на depth кадров выше. Слот, в который ещё не
+записано значение, - переменная, которая ещё не объявлена.
+*/
+func (f *frame) slot(depth int, slot int, vm *VM, name compiler.Instructions) *runtime.Slot {
+	for range depth {
+		f = f.parent
+	}
+	cell := &f.slots[slot]
+	if cell.Value == nil {
+		panic(fmt.Sprintf("Cannot resolve \"%s\" as it does not exist.", vm.name(name)))
+	}
+	return cell
+}
+
+/*
+Окружение и кадр, в которых создана функция.
+*/
+type closure struct {
+	env   runtime.Environment
+	frame *frame
+}
+
+func (c closure) Same(other any) bool {
+	o, ok := other.(closure)
+	return ok && c.frame == o.frame && c.env.Same(&o.env)
+}
+
+func New(bytecode *compiler.Bytecode, env runtime.Environment) *VM {
+	return &VM{
+		constants: bytecode.Constants,
+		main:      bytecode.Main,
+		env:       env,
+		stack:     make([]runtime.RuntimeVal, 0, 256),
+	}
+}
+
+/*
+Выполняет программу и перехватывает вызов exit(), как runtime.Run.
+*/
+func (vm *VM) Run() (result runtime.RuntimeVal, exit *runtime.ExitSignal) {
+	defer func() {
+		if r := recover(); r != nil {
+			signal, ok := r.(runtime.ExitSignal)
+			if !ok {
+				panic(r)
+			}
+			result = runtime.NullVal{}
+			exit = &signal
+		}
+	}()
+
+	return vm.execute(vm.main, vm.env, nil), nil
+}
+
+func (vm *VM) push(val runtime.RuntimeVal) {
+	vm.stack = append(vm.stack, val)
+}
+
+func (vm *VM) pop() runtime.RuntimeVal {
+	val := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return val
+}
+
+func (vm *VM) peek() runtime.RuntimeVal {
+	return vm.stack[len(vm.stack)-1]
+}
+
+func (vm *VM) name(ins compiler.Instructions) string {
+	return vm.constants[compiler.ReadUint32(ins)].(runtime.StringVal).Value
+}
+
+func (vm *VM) execute(fn *compiler.Function, env runtime.Environment, fr *frame) runtime.RuntimeVal {
+	ins := fn.Instructions
+
+	for ip := 0; ip < len(ins); {
+		op := compiler.Opcode(ins[ip])
+		ip++
+
+		switch op {
+		case compiler.OpConstant:
+			vm.push(vm.constants[compiler.ReadUint32(ins[ip:])])
+			ip += 4
+		case compiler.OpNull:
+			vm.push(runtime.NullVal{})
+		case compiler.OpUndefined:
+			vm.push(runtime.UndefinedVal{})
+		case compiler.OpPop:
+			vm.pop()
+
+		case compiler.OpDefineLocal:
+			slot := compiler.ReadUint32(ins[ip:])
+			constant := compiler.ReadUint8(ins[ip+4:]) == 1
+			fr.slots[slot] = runtime.NewSlot(vm.peek(), constant)
+			ip += 5
+		case compiler.OpDefine:
+			slot := compiler.ReadUint32(ins[ip:])
+			constant := compiler.ReadUint8(ins[ip+4:]) == 1
+			env.DeclareSlot(vm.name(ins[ip+5:]), slot, vm.peek(), constant)
+			ip += 9
+		case compiler.OpDefineName:
+			constant := compiler.ReadUint8(ins[ip:]) == 1
+			env.DeclareName(vm.name(ins[ip+1:]), vm.peek(), constant)
+			ip += 5
+		case compiler.OpGetLocal:
+			depth := compiler.ReadUint16(ins[ip:])
+			slot := compiler.ReadUint32(ins[ip+2:])
+			vm.push(fr.slot(depth, slot, vm, ins[ip+6:]).Value)
+			ip += 10
+		case compiler.OpSetLocal:
+			depth := compiler.ReadUint16(ins[ip:])
+			slot := compiler.ReadUint32(ins[ip+2:])
+			fr.slot(depth, slot, vm, ins[ip+6:]).Assign(vm.name(ins[ip+6:]), vm.peek())
+			ip += 10
+		case compiler.OpGetEnv:
+			depth := compiler.ReadUint16(ins[ip:])
+			slot := compiler.ReadUint32(ins[ip+2:])
+			vm.push(env.LookupSlot(vm.name(ins[ip+6:]), depth, slot))
+			ip += 10
+		case compiler.OpSetEnv:
+			depth := compiler.ReadUint16(ins[ip:])
+			slot := compiler.ReadUint32(ins[ip+2:])
+			env.AssignSlot(vm.name(ins[ip+6:]), depth, slot, vm.peek())
+			ip += 10
+		case compiler.OpGetName:
+			vm.push(env.LookupName(vm.name(ins[ip:])))
+			ip += 4
+		case compiler.OpSetName:
+			env.AssignName(vm.name(ins[ip:]), vm.peek())
+			ip += 4
+
+		case compiler.OpEnterFrame:
+			fr = newFrame(compiler.ReadUint32(ins[ip:]), fr)
+			ip += 4
+		case compiler.OpLeaveFrame:
+			fr = fr.parent
+		case compiler.OpEnterScope:
+			env = env.Child()
+		case compiler.OpLeaveScope:
+			env = *env.Parent()
+
+		case compiler.OpBinary:
+			right := vm.pop()
+			left := vm.pop()
+			vm.push(runtime.BinaryOp(left, right, operator(ins[ip:])))
+			ip += 2
+		case compiler.OpUnary:
+			vm.push(runtime.UnaryOp(vm.pop(), operator(ins[ip:])))
+			ip += 2
+		case compiler.OpCompound:
+			value := vm.pop()
+			vm.push(runtime.CompoundAssign(vm.pop(), value, operator(ins[ip:])))
+			ip += 2
+
+		case compiler.OpJump:
+			ip = compiler.ReadUint32(ins[ip:])
+		case compiler.OpJumpIfFalse:
+			if runtime.ToBool(vm.pop()).Value {
+				ip += 4
+			} else {
+				ip = compiler.ReadUint32(ins[ip:])
+			}
+
+		case compiler.OpJumpIfNotNullish:
+			// Значение остаётся результатом, если оно есть, иначе
+			// вычисляется запасное
+			if runtime.IsNullish(vm.peek()) {
+				vm.pop()
+				ip += 4
+			} else {
+				ip = compiler.ReadUint32(ins[ip:])
+			}
+
+		case compiler.OpJumpIfNullish:
+			if runtime.IsNullish(vm.peek()) {
+				vm.pop()
+				vm.push(runtime.UndefinedVal{})
+				ip = compiler.ReadUint32(ins[ip:])
+			} else {
+				ip += 4
+			}
+
+		case compiler.OpClosure:
+			compiled := vm.constants[compiler.ReadUint32(ins[ip:])].(runtime.CompiledFnVal)
+			compiled.Closure = closure{env: env, frame: fr}
+			vm.push(compiled)
+			ip += 4
+		case compiler.OpCall, compiler.OpOptionalCall:
+			argc := compiler.ReadUint16(ins[ip:])
+			site := ip - 1
+			ip += 2
+
+			// У обычного вызова функция вычисляется после аргументов и
+			// лежит над ними, у ?.() - до них и лежит под ними
+			var caller runtime.RuntimeVal
+			if op == compiler.OpCall {
+				caller = vm.pop()
+			} else {
+				caller = vm.stack[len(vm.stack)-argc-1]
+			}
+			// Аргументы остаются на стеке до вызова: vm.call копирует их
+			// в кадр или в новый срез
+			args := vm.stack[len(vm.stack)-argc:]
+			result := vm.call(caller, args, env, fn, site)
+			vm.stack = vm.stack[:len(vm.stack)-argc]
+			if op == compiler.OpOptionalCall {
+				vm.pop()
+			}
+			vm.push(result)
+
+		case compiler.OpTemplate:
+			count := compiler.ReadUint32(ins[ip:])
+			ip += 4
+			parts := make([]runtime.RuntimeVal, count)
+			copy(parts, vm.stack[len(vm.stack)-count:])
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(runtime.Template(parts))
+
+		case compiler.OpReturn:
+			return vm.pop()
+
+		default:
+			panic(fmt.Sprintf("Unknown opcode %d", op))
+		}
+	}
+
+	return runtime.NullVal{}
+}
+
+/*
+Встроенные функции получают окружение места вызова, как в
+интерпретаторе. args указывает на стек vm, поэтому не должен
+пережить вызов.
+*/
+func (vm *VM) call(caller runtime.RuntimeVal, args []runtime.RuntimeVal, env runtime.Environment, fn *compiler.Function, site int) runtime.RuntimeVal {
+	compiled, ok := caller.(runtime.CompiledFnVal)
+	if !ok {
+		return runtime.Call(caller, append([]runtime.RuntimeVal(nil), args...), env, fn.Positions[site])
+	}
+
+	if len(compiled.Params) > len(args) {
+		panic("Arg num less then Param num")
+	} else if len(compiled.Params) > 0 && len(compiled.Params) < len(args) {
+		panic("Arg num more then Param num")
+	}
+
+	code := compiled.Code.(*compiler.Function)
+	captured := compiled.Closure.(closure)
+	if code.Env {
+		scope := captured.env.Child()
+		for i, param := range compiled.Params {
+			scope.DeclareSlot(param.Name, i, args[i], false)
+		}
+		return vm.execute(code, scope, captured.frame)
+	}
+
+	fr := newFrame(code.Slots, captured.frame)
+	for i := range compiled.Params {
+		fr.slots[i] = runtime.NewSlot(args[i], false)
+	}
+	return vm.execute(code, captured.env, fr)
+}
+
+func operator(ins compiler.Instructions) lexer.Token {
+	return lexer.Token{
+		Kind: lexer.TokenKind(compiler.ReadUint16(ins)),
+	}
+}
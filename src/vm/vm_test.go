@@ -0,0 +1,297 @@
+package vm
+
+import (
+	"finescript/src/ast"
+	"finescript/src/compiler"
+	"finescript/src/lexer"
+	"finescript/src/parser"
+	"finescript/src/resolver"
+	"finescript/src/runtime"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func parse(t testing.TB, source string) ast.Program {
+	t.Helper()
+	tokens, errs := lexer.Tokenize(source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	program, errs := parser.Parse(tokens, source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	return program
+}
+
+func runTree(t testing.TB, source string) runtime.RuntimeVal {
+	t.Helper()
+	env := runtime.NewGlobalEnv(runtime.CapAll)
+	program, errs := resolver.Resolve(parse(t, source), source, env.Names())
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	result, _ := runtime.Run(program, env)
+	return result
+}
+
+func runVM(t testing.TB, source string) runtime.RuntimeVal {
+	t.Helper()
+	bytecode, errs := compiler.Compile(parse(t, source), source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	result, _ := New(bytecode, runtime.NewGlobalEnv(runtime.CapAll)).Run()
+	return result
+}
+
+/*
+Значение последней инструкции или текст ошибки выполнения.
+*/
+func outcome(run func() runtime.RuntimeVal) (result string) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = fmt.Sprint("panic: ", r)
+		}
+	}()
+	return runtime.Format(run())
+}
+
+/*
+Обе машины должны вернуть одно и то же значение последней инструкции
+или упасть с одной и той же ошибкой.
+*/
+func assertSame(t *testing.T, source string, want string) {
+	t.Helper()
+	tree := outcome(func() runtime.RuntimeVal { return runTree(t, source) })
+	vm := outcome(func() runtime.RuntimeVal { return runVM(t, source) })
+	if tree != want || vm != want {
+		t.Errorf("tree = %s, vm = %s, want %s", tree, vm, want)
+	}
+}
+
+func TestSameResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"arithmetic", "var a = 2\nvar b = 3\na * b + 1", "7"},
+		{"closure", "fun counter(start: int) {\n  var n = start\n  fun next() { n = n + 1 }\n  next\n}\nvar next = counter(10)\nnext()\nnext()", "12"},
+		{"recursion", "fun fib(n: int): int { n < 2 ? n : fib(n - 1) + fib(n - 2) }\nfib(15)", "610"},
+		{"block scope", "var x = 1\n{\n  var x = 2\n  x = x + 1\n}\nx", "1"},
+		{"if branches", "var x = 0\nif x < 1 { var y = 5\n x = y } else { x = 2 }\nx", "5"},
+		{"forward reference", "fun f() { g() }\nfun g() { 42 }\nf()", "42"},
+		{"equality", "sprintf(1 == 6, 1 == 1.0, \"x\" != \"y\", null == undefined)", "falsetruetruefalse"},
+		{"optional call", "var calls = 0\nfun count() { calls = calls + 1 }\nvar f = null\nf?.(count())\ncount?.(count())\ncalls", "2"},
+		{"optional call with arguments", "fun id(x: int) { x }\nvar f = null\nsprintf(id?.(7), f?.(1))", "7undefined"},
+		{"independent closures", "fun counter() {\n  var n = 0\n  fun next() { n = n + 1 }\n  next\n}\nvar a = counter()\nvar b = counter()\na()\na()\nsprintf(a(), b())", "31"},
+		{"closure over block", "fun make() {\n  var f = null\n  {\n    var hidden = 7\n    fun get() { hidden }\n    f = get\n  }\n  f\n}\nmake()()", "7"},
+		{"closure equality", "fun make() {\n  fun f() { 1 }\n  f\n}\nvar a = make()\nvar b = a\nsprintf(a == b, make() == make())", "truefalse"},
+		{"nested blocks", "fun f(a: int) {\n  var x = a\n  {\n    var y = x + 1\n    {\n      x = y * 2\n    }\n  }\n  x\n}\nf(3)", "8"},
+		{"sticky type", "fun f() {\n  var x = 1\n  x = \"s\"\n}\nf()", "panic: Types of assigne and expr not equals"},
+		{"null keeps type", "fun f() {\n  var x = 1\n  x = null\n  x = 2\n}\nf()", "2"},
+		{"used before declaration", "fun outer() {\n  fun f() { x }\n  f()\n  var x = 1\n}\nouter()", "panic: Cannot resolve \"x\" as it does not exist."},
+		{"argument count", "fun f(a: int, b: int) { a }\nf(1)", "panic: Arg num less then Param num"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSame(t, tt.source, tt.want)
+		})
+	}
+}
+
+/*
+Индексы констант и адреса переходов больше 65535 не должны обрезаться.
+*/
+func TestLargeProgram(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("var x = 0\nif true {\n")
+	for i := range 70000 {
+		fmt.Fprintf(&sb, "  x = x + %d\n", i%7+1)
+	}
+	sb.WriteString("}\nx")
+	assertSame(t, sb.String(), "280000")
+
+	sb.Reset()
+	sb.WriteString("var s = 0\n")
+	want := 0
+	for i := range 70000 {
+		fmt.Fprintf(&sb, "s = s + %d\n", i)
+		want += i
+	}
+	sb.WriteString("s")
+	assertSame(t, sb.String(), fmt.Sprint(want))
+}
+
+/*
+Запускать с -race: программы в отдельных глобальных окружениях не
+должны делить изменяемое состояние ни в интерпретаторе, ни в vm.
+*/
+func TestRunConcurrently(t *testing.T) {
+	source := `
+fun counter() {
+  var n = 0
+  fun next() {
+    n += 1
+    n
+  }
+  next
+}
+var c = counter()
+var total = 0
+if true {
+  var first = c()
+  total = first + c() + c()
+}
+eval("total = total * 2")
+total`
+	program := parse(t, source)
+	bytecode, errs := compiler.Compile(program, source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	globals := runtime.NewGlobalEnv(runtime.CapAll)
+	resolved, errs := resolver.Resolve(program, source, globals.Names())
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+
+	var wg sync.WaitGroup
+	for i := range 64 {
+		wg.Add(1)
+		go func(vm bool) {
+			defer wg.Done()
+			env := runtime.NewGlobalEnv(runtime.CapAll)
+			var result runtime.RuntimeVal
+			if vm {
+				result, _ = New(bytecode, env).Run()
+			} else {
+				result, _ = runtime.Run(resolved, env)
+			}
+			if got := runtime.Format(result); got != "12" {
+				t.Errorf("vm = %t: got %s, want 12", vm, got)
+			}
+		}(i%2 == 1)
+	}
+	wg.Wait()
+}
+
+/*
+exit() из любой глубины останавливает обе машины с тем же кодом.
+*/
+func TestExit(t *testing.T) {
+	tests := []struct {
+		source string
+		code   int
+	}{
+		{"exit(3)", 3},
+		{"exit()", 0},
+		{"var a = 1\nexit(a + 4)\na = 2", 5},
+		{"fun f(n: int) {\n  if n > 0 {\n    f(n - 1)\n  } else {\n    exit(7)\n  }\n}\nf(3)", 7},
+		{"fun g() {\n  var k = 6\n  eval(\"exit(k)\")\n}\ng()", 6},
+	}
+	for _, tt := range tests {
+		program := parse(t, tt.source)
+		bytecode, errs := compiler.Compile(program, tt.source)
+		if len(errs) > 0 {
+			t.Fatal(strings.Join(errs, "\n"))
+		}
+		vmEnv := runtime.NewGlobalEnv(runtime.CapAll)
+		_, vmExit := New(bytecode, vmEnv).Run()
+
+		treeEnv := runtime.NewGlobalEnv(runtime.CapAll)
+		resolved, errs := resolver.Resolve(program, tt.source, treeEnv.Names())
+		if len(errs) > 0 {
+			t.Fatal(strings.Join(errs, "\n"))
+		}
+		_, treeExit := runtime.Run(resolved, treeEnv)
+
+		if vmExit == nil || treeExit == nil || vmExit.Code != tt.code || treeExit.Code != tt.code {
+			t.Errorf("%q: vm exit %v, tree exit %v, want code %d", tt.source, vmExit, treeExit, tt.code)
+		}
+		for _, env := range []runtime.Environment{vmEnv, treeEnv} {
+			if a, ok := env.Lookup("a"); ok && runtime.Format(a) != "1" {
+				t.Errorf("%q: statement after exit was executed, a = %s", tt.source, runtime.Format(a))
+			}
+		}
+	}
+}
+
+func TestConstantsDeduplicated(t *testing.T) {
+	source := "var a = 1\nvar b = 1\nvar c = \"x\" + \"x\"\n1.5 + 1.5"
+	bytecode, errs := compiler.Compile(parse(t, source), source)
+	if len(errs) > 0 {
+		t.Fatal(strings.Join(errs, "\n"))
+	}
+	seen := make(map[runtime.RuntimeVal]bool)
+	for _, constant := range bytecode.Constants {
+		if seen[constant] {
+			t.Errorf("constant %s is stored twice", runtime.Format(constant))
+		}
+		seen[constant] = true
+	}
+}
+
+/*
+eval выполняется в окружении места вызова и видит переменные программы
+и параметры функций.
+*/
+func TestEvalSeesVariables(t *testing.T) {
+	assertSame(t, "var k = 1\neval(\"k = k + 1\")\nk", "2")
+	assertSame(t, "fun double(a: int) { eval(\"a * 2\") }\ndouble(21)", "42")
+	assertSame(t, "fun f() {\n  var w = 1\n  {\n    eval(\"w = w + 2\")\n  }\n  w\n}\nf()", "3")
+	assertSame(t, "fun outer(a: int) {\n  fun inner() { eval(\"a + 1\") }\n  inner()\n}\nouter(4)", "5")
+}
+
+/*
+Имя, объявленное через eval, перекрывает внешнее в обеих машинах, как
+у resolver: и глобальное, и локальное.
+*/
+func TestEvalShadows(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"global in block", "var g = 1\nvar r = 0\n{\n  eval(\"var g = 2\")\n  r = g\n}\nsprintf(r, g)", "21"},
+		{"local in block", "fun f() {\n  var k = 1\n  {\n    eval(\"var k = 2\")\n    k\n  }\n}\nf()", "2"},
+		{"seen from nested function", "fun f() {\n  eval(\"var w = 5\")\n  fun g() { w }\n  g()\n}\nf()", "5"},
+		{"evalIn current environment", "fun f() {\n  var k = 1\n  {\n    evalIn(currentEnv(), \"var k = 3\")\n    k\n  }\n}\nf()", "3"},
+		{"outer scope keeps its value", "fun f() {\n  var k = 1\n  {\n    eval(\"var k = 2\")\n  }\n  k\n}\nf()", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSame(t, tt.source, tt.want)
+		})
+	}
+}
+
+const benchmarkSource = `fun fib(n: int): int { n < 2 ? n : fib(n - 1) + fib(n - 2) }
+fib(20)`
+
+func BenchmarkTree(b *testing.B) {
+	program := parse(b, benchmarkSource)
+	env := runtime.NewGlobalEnv(runtime.CapAll)
+	program, errs := resolver.Resolve(program, benchmarkSource, env.Names())
+	if len(errs) > 0 {
+		b.Fatal(strings.Join(errs, "\n"))
+	}
+	b.ResetTimer()
+	for range b.N {
+		runtime.Run(program, runtime.NewGlobalEnv(runtime.CapAll))
+	}
+}
+
+func BenchmarkVM(b *testing.B) {
+	bytecode, errs := compiler.Compile(parse(b, benchmarkSource), benchmarkSource)
+	if len(errs) > 0 {
+		b.Fatal(strings.Join(errs, "\n"))
+	}
+	b.ResetTimer()
+	for range b.N {
+		New(bytecode, runtime.NewGlobalEnv(runtime.CapAll)).Run()
+	}
+}